@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// credentials of a feed, applied to its requests only
+// every value is hidden from the logs, references or not
+type Auth struct {
+	Basic   *BasicAuth        `json:"basic,omitempty"`
+	Bearer  string            `json:"bearer,omitempty"`  // token of the 'Authorization: Bearer' header
+	Cookies map[string]string `json:"cookies,omitempty"` // by name
+	Headers map[string]string `json:"headers,omitempty"` // e.g. api keys, unlike the headers of the feed
+	Cert    *ClientCert       `json:"cert,omitempty"`
+}
+
+type BasicAuth struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// a client tls certificate, as pem files
+type ClientCert struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// a copy of the credentials with their references expanded
+// their values are registered as secrets
+func (a *Auth) expand() (*Auth, error) {
+	out := &Auth{}
+	var err error
+
+	if a.Basic != nil {
+		out.Basic = &BasicAuth{}
+		if out.Basic.User, err = expandSecret(a.Basic.User); err != nil {
+			return nil, err
+		}
+		if out.Basic.Password, err = expandSecret(a.Basic.Password); err != nil {
+			return nil, err
+		}
+		// as sent in the 'Authorization' header
+		registerSecret(base64.StdEncoding.EncodeToString([]byte(out.Basic.User + ":" + out.Basic.Password)))
+	}
+
+	if out.Bearer, err = expandSecret(a.Bearer); err != nil {
+		return nil, err
+	}
+
+	if out.Cookies, err = expandSecrets(a.Cookies); err != nil {
+		return nil, err
+	}
+	if out.Headers, err = expandSecrets(a.Headers); err != nil {
+		return nil, err
+	}
+
+	if a.Cert != nil {
+		out.Cert = &ClientCert{}
+		if out.Cert.Cert, err = expand(a.Cert.Cert); err != nil {
+			return nil, err
+		}
+		if out.Cert.Key, err = expand(a.Cert.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// expand a value and register it as a secret, even if written as is
+func expandSecret(s string) (string, error) {
+	value, err := expand(s)
+	if err != nil {
+		return "", err
+	}
+	registerSecret(value)
+	return value, nil
+}
+
+func expandSecrets(values map[string]string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[string]string, len(values))
+	for key, value := range values {
+		var err error
+		if out[key], err = expandSecret(value); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// whether the credentials are usable
+func (a *Auth) check() error {
+	if a.Basic != nil && a.Bearer != "" {
+		return fmt.Errorf("both basic auth and a bearer token")
+	}
+	if a.Basic != nil && a.Basic.User == "" {
+		return fmt.Errorf("basic auth with no user")
+	}
+	if a.Cert != nil && (a.Cert.Cert == "" || a.Cert.Key == "") {
+		return fmt.Errorf("client certificate with no cert or key file")
+	}
+	return nil
+}
+
+// set the credentials on a request
+func (a *Auth) apply(req *http.Request) {
+	if a.Basic != nil {
+		req.SetBasicAuth(a.Basic.User, a.Basic.Password)
+	}
+	if a.Bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Bearer)
+	}
+	for name, value := range a.Cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	for key, value := range a.Headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// the transport of the requests of a feed
+// the client certificate, if any, is only presented to the host of the feed
+func (a *Auth) transport(feedHost string) (http.RoundTripper, error) {
+	if a.Cert == nil {
+		return http.DefaultTransport, nil
+	}
+
+	cert, err := a.Cert.transport()
+	if err != nil {
+		return nil, err
+	}
+	return &hostTransport{host: feedHost, transport: cert}, nil
+}
+
+// sends the requests to a host through a transport, the others through the default one
+type hostTransport struct {
+	host      string
+	transport http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.host {
+		return t.transport.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// the transports presenting a client certificate, by cert and key files
+// they're shared by the feeds and the crawls, so that their connections are reused
+var certTransports = struct {
+	sync.Mutex
+	byFiles map[string]*certTransport
+}{byFiles: map[string]*certTransport{}}
+
+type certTransport struct {
+	*http.Transport
+	stamp string // of the cert and key files, to tell when they change
+}
+
+// the transport presenting the certificate, built again when its files change
+func (c *ClientCert) transport() (*http.Transport, error) {
+	stamp := ""
+	for _, path := range []string{c.Cert, c.Key} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stamp += fmt.Sprintf("%d %d ", fi.ModTime().UnixNano(), fi.Size())
+	}
+
+	certTransports.Lock()
+	defer certTransports.Unlock()
+
+	key := c.Cert + "\x00" + c.Key
+	existing, ok := certTransports.byFiles[key]
+	if ok && existing.stamp == stamp {
+		return existing.Transport, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if ok {
+		existing.CloseIdleConnections()
+	}
+	certTransports.byFiles[key] = &certTransport{Transport: transport, stamp: stamp}
+	return transport, nil
+}
+
+// a copy of the credentials with their values masked, for display
+func (a *Auth) masked() *Auth {
+	out := &Auth{}
+	if a.Basic != nil {
+		out.Basic = &BasicAuth{User: redactedValue, Password: redactedValue}
+	}
+	if a.Bearer != "" {
+		out.Bearer = redactedValue
+	}
+	out.Cookies = maskValues(a.Cookies)
+	out.Headers = maskValues(a.Headers)
+	if a.Cert != nil {
+		out.Cert = &ClientCert{Cert: redactedValue, Key: redactedValue}
+	}
+	return out
+}
+
+func maskValues(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	out := make(map[string]string, len(values))
+	for key, _ := range values {
+		out[key] = redactedValue
+	}
+	return out
+}
+
+// a copy of the channel groups with the credentials masked, for display
+// the secrets and variables expanded in the urls, names and headers are hidden too
+func (cg ChannelGroups) Masked() ChannelGroups {
+	out := make(ChannelGroups, len(cg))
+	for idx, group := range cg {
+		out[idx] = group
+		out[idx].Channels = make(Feeds, len(group.Channels))
+		for i, feed := range group.Channels {
+			feed.Url = Redact(feed.Url)
+			feed.Name = Redact(feed.Name)
+			if feed.Headers != nil {
+				headers := make(map[string]string, len(feed.Headers))
+				for key, value := range feed.Headers {
+					headers[key] = Redact(value)
+				}
+				feed.Headers = headers
+			}
+			if feed.Auth != nil {
+				feed.Auth = feed.Auth.masked()
+			}
+			out[idx].Channels[i] = feed
+		}
+	}
+	return out
+}
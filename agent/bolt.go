@@ -0,0 +1,377 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// buckets of the bolt store
+// items are keyed by date, owner, channel then title, so that days are contiguous
+// the indexes point back to the items, by owner, channel and identity first
+var (
+	datesBucket     = []byte("dates")      // date -> nothing, days with no item included
+	channelsBucket  = []byte("channels")   // date, owner, channel -> description
+	itemsBucket     = []byte("items")      // date, owner, channel, title -> item
+	byOwnerBucket   = []byte("by_owner")   // owner, date, channel, title -> nothing
+	byChannelBucket = []byte("by_channel") // channel, date, owner, title -> nothing
+	byItemBucket    = []byte("by_item")    // identity, date, owner, channel, title -> nothing
+)
+
+// separates the parts of the keys, sorting before any other byte
+const keySep = "\x00"
+
+// a single file database, indexed
+// the file is only opened for the time of each operation, read-only to read
+// so that the processes reading and writing it take turns rather than failing
+type BoltStore struct {
+	path        string
+	LockTimeout time.Duration // how long to wait for another process writing the file, not at all if 0
+}
+
+// bolt waits for ever rather than not at all
+func (s *BoltStore) options(readOnly bool) *bolt.Options {
+	timeout := s.LockTimeout
+	if timeout <= 0 {
+		timeout = time.Nanosecond
+	}
+	return &bolt.Options{Timeout: timeout, ReadOnly: readOnly}
+}
+
+// the database, created if missing
+func OpenBoltStore(path string) (*BoltStore, error) {
+	s := &BoltStore{path: path, LockTimeout: DefaultLockTimeout}
+
+	err := s.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{datesBucket, channelsBucket, itemsBucket, byOwnerBucket, byChannelBucket, byItemBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to init '%s': %v", path, err)
+	}
+
+	return s, nil
+}
+
+// run a read-only transaction, sharing the file with the other readers
+func (s *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(s.path, 0666, s.options(true))
+	if err != nil {
+		return fmt.Errorf("Unable to open: %v", err)
+	}
+	defer db.Close()
+
+	return db.View(fn)
+}
+
+// run a read-write transaction, holding the file on its own
+func (s *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(s.path, 0666, s.options(false))
+	if err != nil {
+		return fmt.Errorf("Unable to open: %v", err)
+	}
+
+	err = db.Update(fn)
+	if closeErr := db.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Unable to close: %v", closeErr)
+	}
+	return err
+}
+
+func key(parts ...string) []byte {
+	return []byte(joinKey(parts...))
+}
+
+func joinKey(parts ...string) string {
+	out := ""
+	for idx, part := range parts {
+		if idx > 0 {
+			out += keySep
+		}
+		out += part
+	}
+	return out
+}
+
+func splitKey(k []byte) []string {
+	parts := []string{}
+	for _, part := range bytes.Split(k, []byte(keySep)) {
+		parts = append(parts, string(part))
+	}
+	return parts
+}
+
+// what tells an item apart across days and channels, its link or else its title
+func itemIdentity(item *Item) string {
+	if item.Link != "" {
+		return item.Link
+	}
+	return item.Title
+}
+
+func (s *BoltStore) LoadDay(date string) (*Day, error) {
+	var day *Day
+	err := s.view(func(tx *bolt.Tx) error {
+		if tx.Bucket(datesBucket).Get([]byte(date)) == nil {
+			return nil
+		}
+
+		day = &Day{Date: date, Owners: &Owners{}}
+		channels := map[string]*Channel{} // by owner and title
+
+		prefix := key(date, "")
+		c := tx.Bucket(channelsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			parts := splitKey(k)
+			ownerId, title := parts[1], parts[2]
+
+			owners := *day.Owners
+			if len(owners) == 0 || owners[len(owners)-1].Id != ownerId {
+				*day.Owners = append(*day.Owners, &Owner{Id: ownerId, Channels: &Channels{}})
+			}
+			owner := (*day.Owners)[len(*day.Owners)-1]
+
+			channel := &Channel{Title: title, Desc: string(v), Items: &Items{}}
+			*owner.Channels = append(*owner.Channels, channel)
+			channels[joinKey(ownerId, title)] = channel
+		}
+
+		c = tx.Bucket(itemsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			parts := splitKey(k)
+			channel, ok := channels[joinKey(parts[1], parts[2])]
+			if !ok {
+				continue
+			}
+
+			var item Item
+			if err := json.Unmarshal(v, &item); err != nil {
+				return fmt.Errorf("Unable to unmarshal '%s': %v", joinKey(parts...), err)
+			}
+			*channel.Items = append(*channel.Items, &item)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to load '%s' from '%s': %v", date, s.path, err)
+	}
+
+	return day, nil
+}
+
+func (s *BoltStore) SaveDay(day *Day) error {
+	err := s.update(func(tx *bolt.Tx) error {
+		if err := removeDay(tx, day.Date); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(datesBucket).Put([]byte(day.Date), []byte{}); err != nil {
+			return err
+		}
+		if day.Owners == nil {
+			return nil
+		}
+
+		for _, owner := range *day.Owners {
+			if owner.Channels == nil {
+				continue
+			}
+			for _, channel := range *owner.Channels {
+				err := tx.Bucket(channelsBucket).Put(key(day.Date, owner.Id, channel.Title), []byte(channel.Desc))
+				if err != nil {
+					return err
+				}
+				if channel.Items == nil {
+					continue
+				}
+
+				for _, item := range *channel.Items {
+					value, err := json.Marshal(item)
+					if err != nil {
+						return err
+					}
+
+					entries := []struct {
+						bucket []byte
+						key    []byte
+						value  []byte
+					}{
+						{itemsBucket, key(day.Date, owner.Id, channel.Title, item.Title), value},
+						{byOwnerBucket, key(owner.Id, day.Date, channel.Title, item.Title), []byte{}},
+						{byChannelBucket, key(channel.Title, day.Date, owner.Id, item.Title), []byte{}},
+						{byItemBucket, key(itemIdentity(item), day.Date, owner.Id, channel.Title, item.Title), []byte{}},
+					}
+					for _, entry := range entries {
+						if err := tx.Bucket(entry.bucket).Put(entry.key, entry.value); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to save '%s' to '%s': %v", day.Date, s.path, err)
+	}
+
+	return nil
+}
+
+// delete the entries of a day, its items and their index entries
+func removeDay(tx *bolt.Tx, date string) error {
+	prefix := key(date, "")
+
+	items := tx.Bucket(itemsBucket)
+	c := items.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Seek(prefix) {
+		parts := splitKey(k)
+		owner, channel, title := parts[1], parts[2], parts[3]
+
+		var item Item
+		if err := json.Unmarshal(v, &item); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(byOwnerBucket).Delete(key(owner, date, channel, title)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(byChannelBucket).Delete(key(channel, date, owner, title)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(byItemBucket).Delete(key(itemIdentity(&item), date, owner, channel, title)); err != nil {
+			return err
+		}
+		if err := items.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	channels := tx.Bucket(channelsBucket)
+	c = channels.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := channels.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket(datesBucket).Delete([]byte(date))
+}
+
+func (s *BoltStore) RemoveDay(date string) error {
+	err := s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(datesBucket).Get([]byte(date)) == nil {
+			return fmt.Errorf("no such day")
+		}
+		return removeDay(tx, date)
+	})
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to remove '%s' from '%s': %v", date, s.path, err)
+	}
+	return nil
+}
+
+func (s *BoltStore) Dates() ([]string, error) {
+	dates := []string{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(datesBucket).ForEach(func(k, v []byte) error {
+			dates = append(dates, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to list the days of '%s': %v", s.path, err)
+	}
+	return dates, nil
+}
+
+// the items between two dates, by date, owner, channel then title
+func (s *BoltStore) Items(since, until string, fn ItemFunc) error {
+	return s.scan(itemsBucket, "", since, until, func(parts []string) (string, string, string, string) {
+		return parts[0], parts[1], parts[2], parts[3]
+	}, fn)
+}
+
+// the items of an owner between two dates, by date, channel then title
+func (s *BoltStore) OwnerItems(owner, since, until string, fn ItemFunc) error {
+	return s.scan(byOwnerBucket, owner, since, until, func(parts []string) (string, string, string, string) {
+		return parts[1], parts[0], parts[2], parts[3]
+	}, fn)
+}
+
+// the items of the channels of a title between two dates, by date, owner then title
+func (s *BoltStore) ChannelItems(channel, since, until string, fn ItemFunc) error {
+	return s.scan(byChannelBucket, channel, since, until, func(parts []string) (string, string, string, string) {
+		return parts[1], parts[2], parts[0], parts[3]
+	}, fn)
+}
+
+// the occurrences of an item, by its link or else its title, by date, owner then channel
+func (s *BoltStore) Occurrences(identity string, fn ItemFunc) error {
+	return s.scan(byItemBucket, identity, "", "", func(parts []string) (string, string, string, string) {
+		return parts[1], parts[2], parts[3], parts[4]
+	}, fn)
+}
+
+// walk the keys of a bucket starting with a value, when given, then ranging over dates
+// past the value, keys are sorted by date, the scan starts at since and stops past until
+// locate tells the date, owner, channel and title of the item a key points to
+func (s *BoltStore) scan(bucket []byte, value, since, until string, locate func(parts []string) (string, string, string, string), fn ItemFunc) error {
+	prefix := []byte{}
+	if value != "" {
+		prefix = key(value, "")
+	}
+	start := append(append([]byte{}, prefix...), since...)
+
+	err := s.view(func(tx *bolt.Tx) error {
+		items := tx.Bucket(itemsBucket)
+		channels := tx.Bucket(channelsBucket)
+
+		var owner *Owner
+		var channel *Channel
+		lastDate := ""
+
+		c := tx.Bucket(bucket).Cursor()
+		for k, _ := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			date, ownerId, title, itemTitle := locate(splitKey(k))
+			if until != "" && date > until {
+				break // keys are sorted by date past the prefix
+			}
+
+			v := items.Get(key(date, ownerId, title, itemTitle))
+			if v == nil { // dangling index entry
+				continue
+			}
+			var item Item
+			if err := json.Unmarshal(v, &item); err != nil {
+				return fmt.Errorf("Unable to unmarshal '%s': %v", joinKey(date, ownerId, title, itemTitle), err)
+			}
+
+			// items of the same channel share it, as they do in a day
+			if owner == nil || owner.Id != ownerId || date != lastDate {
+				owner = &Owner{Id: ownerId, Channels: &Channels{}}
+				channel = nil
+			}
+			if channel == nil || channel.Title != title {
+				channel = &Channel{Title: title, Desc: string(channels.Get(key(date, ownerId, title))), Items: &Items{}}
+			}
+
+			lastDate = date
+			fn(date, owner, channel, &item)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to read the items of '%s': %v", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_BoltStore_indexes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenBoltStore(filepath.Join(dir, "items.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, day := range []Day{
+		dayOf("2016-04-24", "cnet", "iPhone", "a", "b"),
+		dayOf("2016-04-25", "wsj", "World", "a", "c"),
+		dayOf("2016-04-26", "wsj", "World", "d"),
+		dayOf("2016-04-27", "cnet", "World", "e"),
+	} {
+		store.SaveDay(&day)
+	}
+	// replaced, its index entries go with it
+	day := dayOf("2016-04-26", "wsj", "World", "f")
+	store.SaveDay(&day)
+
+	collect := func(items *[]string) ItemFunc {
+		return func(date string, owner *Owner, channel *Channel, item *Item) {
+			*items = append(*items, date+" "+owner.Id+" "+channel.Title+" "+item.Title)
+		}
+	}
+
+	testCases := []struct {
+		walk  func(fn ItemFunc) error
+		items []string
+	}{
+		{ // test case 0
+			func(fn ItemFunc) error { return store.OwnerItems("wsj", "", "", fn) },
+			[]string{"2016-04-25 wsj World a", "2016-04-25 wsj World c", "2016-04-26 wsj World f"},
+		},
+		{ // test case 1, between dates
+			func(fn ItemFunc) error { return store.OwnerItems("cnet", "2016-04-25", "2016-04-27", fn) },
+			[]string{"2016-04-27 cnet World e"},
+		},
+		{ // test case 2
+			func(fn ItemFunc) error { return store.ChannelItems("World", "", "2016-04-26", fn) },
+			[]string{"2016-04-25 wsj World a", "2016-04-25 wsj World c", "2016-04-26 wsj World f"},
+		},
+		{ // test case 3, by link
+			func(fn ItemFunc) error { return store.Occurrences("http://a", fn) },
+			[]string{"2016-04-24 cnet iPhone a", "2016-04-25 wsj World a"},
+		},
+		{ // test case 4, gone with its day
+			func(fn ItemFunc) error { return store.Occurrences("http://d", fn) },
+			[]string{},
+		},
+		{ // test case 5, unknown owner
+			func(fn ItemFunc) error { return store.OwnerItems("cne", "", "", fn) },
+			[]string{},
+		},
+	}
+
+	for idx, testCase := range testCases {
+		items := []string{}
+		if err := testCase.walk(collect(&items)); err != nil {
+			t.Errorf("[Test case %d] %v", idx, err)
+		}
+		if !reflect.DeepEqual(items, testCase.items) {
+			t.Errorf("[Test case %d] Expected %v, found %v", idx, testCase.items, items)
+		}
+	}
+
+	// queries use the indexes, with the same results as the files
+	marshaller, _ := NewStoreMarshaller(store)
+	results, err := marshaller.Query(Query{Owner: "wsj", Since: "2016-04-26"})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(results) != 1 || results[0].Item.Title != "f" || results[0].Channel != "World" {
+		t.Errorf("Expected the item 'f', found %+v", results)
+	}
+}
+
+func Test_CopyDays(t *testing.T) {
+	dir := writeDays(t,
+		dayOf("2016-04-24", "cnet", "iPhone", "a", "b"),
+		dayOf("2016-04-25", "wsj", "World", "c"),
+	)
+	defer os.RemoveAll(dir)
+
+	to := NewMemStore()
+	existing := dayOf("2016-04-25", "wsj", "World", "d")
+	to.SaveDay(&existing)
+
+	copied := map[string]int{}
+	err := CopyDays(NewDirStore(dir), to, func(date string, items int) {
+		copied[date] = items
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(copied, map[string]int{"2016-04-24": 2, "2016-04-25": 1}) {
+		t.Errorf("Expected the days copied, found %v", copied)
+	}
+
+	// merged with the days already there
+	day, _ := to.LoadDay("2016-04-25")
+	expected := dayOf("2016-04-25", "wsj", "World", "c", "d")
+	if !reflect.DeepEqual(*day, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, *day)
+	}
+}
+
+func Test_BoltStore_shared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// as opened by serve, then by crawl
+	path := filepath.Join(dir, "items.db")
+	reader, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.LockTimeout = 0
+	writer.LockTimeout = 0
+
+	day := dayOf("2016-04-24", "cnet", "iPhone", "a")
+	if err := writer.SaveDay(&day); err != nil {
+		t.Error(err)
+	}
+	loaded, err := reader.LoadDay("2016-04-24")
+	if err != nil {
+		t.Error(err)
+	}
+	if loaded == nil || !reflect.DeepEqual(*loaded, day) {
+		t.Errorf("Expected %+v, found %+v", day, loaded)
+	}
+
+	// the file is held while written only
+	err = writer.update(func(tx *bolt.Tx) error {
+		if _, err := reader.Dates(); err == nil {
+			t.Errorf("Expected the reader to wait for the writer")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// the chars of windows-1252 that differ from iso-8859-1, from 0x80 to 0x9f
+// the bytes it leaves undefined stand for the control chars of the same code
+var windows1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// the labels read as windows-1252
+// like browsers do, iso-8859-1 and us-ascii are too, they're subsets but for the control chars
+var windows1252Labels = map[string]bool{
+	"windows-1252": true,
+	"cp1252":       true,
+	"x-cp1252":     true,
+	"iso-8859-1":   true,
+	"iso8859-1":    true,
+	"iso_8859-1":   true,
+	"latin1":       true,
+	"l1":           true,
+	"us-ascii":     true,
+	"ascii":        true,
+}
+
+// decode the documents declaring a charset other than utf-8 into utf-8
+// meant as the CharsetReader of the xml decoders
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	if !windows1252Labels[strings.ToLower(strings.TrimSpace(label))] {
+		return nil, fmt.Errorf("Unsupported charset '%s'", label)
+	}
+
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(data))
+	for _, b := range data {
+		r := rune(b)
+		if b >= 0x80 && b <= 0x9f {
+			r = windows1252[b-0x80]
+		}
+		out = append(out, string(r)...)
+	}
+	return bytes.NewReader(out), nil
+}
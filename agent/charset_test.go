@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"testing"
+)
+
+func Test_parse_charset(t *testing.T) {
+	testCases := []struct {
+		body  string
+		title string
+		err   bool
+	}{
+		{"<?xml version=\"1.0\" encoding=\"UTF-8\"?><rss><channel><title>café</title></channel></rss>", "café", false},
+		{"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><rss><channel><title>caf\xe9</title></channel></rss>", "café", false},
+		{"<?xml version=\"1.0\" encoding=\"windows-1252\"?><rss><channel><title>\x93caf\xe9\x94 \x80</title></channel></rss>", "“café” €", false},
+		{"<?xml version=\"1.0\" encoding=\"us-ascii\"?><rss><channel><title>cafe</title></channel></rss>", "cafe", false},
+		{"<?xml version=\"1.0\" encoding=\"koi8-r\"?><rss><channel><title>\xc1</title></channel></rss>", "", true},
+	}
+
+	for idx, testCase := range testCases {
+		rss, err := parse("http://example.com", []byte(testCase.body))
+		if (err != nil) != testCase.err {
+			t.Errorf("[Test case %d] Expected error %v, found '%v'", idx, testCase.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if title := rss.Channels[0].Title; title != testCase.title {
+			t.Errorf("[Test case %d] Expected '%s', found '%s'", idx, testCase.title, title)
+		}
+	}
+}
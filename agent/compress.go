@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// the encodings asked for, the transport only handles gzip on its own
+const acceptEncoding = "gzip, deflate, br"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// undo the content encodings of a body, in the reverse order they were applied
+// gzip bodies served with no or the wrong encoding are decompressed too
+// decompressed bodies larger than max are rejected, no limit if 0
+func decode(encodings string, body []byte, max int64) ([]byte, error) {
+	list := strings.Split(encodings, ",")
+	for idx := len(list) - 1; idx >= 0; idx-- {
+		encoding := strings.ToLower(strings.TrimSpace(list[idx]))
+
+		decoded, err := decodeWith(encoding, body, max)
+		if err != nil {
+			// servers may claim an encoding they didn't apply, or the wrong one
+			if looksPlain(body) || bytes.HasPrefix(body, gzipMagic) {
+				break
+			}
+			return nil, fmt.Errorf("Unable to decode '%s': %v", encoding, err)
+		}
+		body = decoded
+	}
+
+	if bytes.HasPrefix(body, gzipMagic) {
+		decoded, err := decodeWith("gzip", body, max)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode gzip: %v", err)
+		}
+		body = decoded
+	}
+
+	return body, nil
+}
+
+func decodeWith(encoding string, body []byte, max int64) ([]byte, error) {
+	var reader io.Reader
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		reader = r
+	case "deflate":
+		// zlib wrapped as the spec says, or raw as some servers send it
+		r, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			reader = flate.NewReader(bytes.NewReader(body))
+		} else {
+			reader = r
+		}
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("unsupported encoding")
+	}
+
+	return readAll(reader, max)
+}
+
+// read up to max bytes, no limit if 0
+// one byte more than allowed is read, to tell a body too large
+func readAll(reader io.Reader, max int64) ([]byte, error) {
+	if max > 0 {
+		reader = io.LimitReader(reader, max+1)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && int64(len(data)) > max {
+		return nil, fmt.Errorf("more than the %d bytes allowed", max)
+	}
+	return data, nil
+}
+
+// whether a body starts like a document rather than compressed data
+func looksPlain(body []byte) bool {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), " \t\r\n")
+	return bytes.HasPrefix(trimmed, []byte("<")) || bytes.HasPrefix(trimmed, []byte("{"))
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Error(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func Test_decode(t *testing.T) {
+	doc := []byte("<rss><channel><title>" + strings.Repeat("news ", 100) + "</title></channel></rss>")
+
+	testCases := []struct {
+		encodings string
+		body      []byte
+		max       int64
+		err       bool
+	}{
+		{"", doc, 0, false},
+		{"gzip", compress(t, "gzip", doc), 0, false},
+		{"x-gzip", compress(t, "gzip", doc), 0, false},
+		{"deflate", compress(t, "deflate", doc), 0, false},
+		{"deflate", compress(t, "raw-deflate", doc), 0, false},
+		{"br", compress(t, "br", doc), 0, false},
+		{"", compress(t, "gzip", doc), 0, false},                            // gzip with no header
+		{"br", compress(t, "gzip", doc), 0, false},                          // gzip with the wrong header
+		{"gzip", doc, 0, false},                                             // claimed but not applied
+		{"gzip", compress(t, "gzip", doc), 100, true},                       // too large once decompressed
+		{"compress", compress(t, "br", doc), 0, true},                       // unsupported
+		{"gzip, br", compress(t, "br", compress(t, "gzip", doc)), 0, false}, // in the reverse order
+	}
+
+	for idx, testCase := range testCases {
+		out, err := decode(testCase.encodings, testCase.body, testCase.max)
+		if (err != nil) != testCase.err {
+			t.Errorf("[Test case %d] Expected error %v, found '%v'", idx, testCase.err, err)
+			continue
+		}
+		if !testCase.err && !bytes.Equal(out, doc) {
+			t.Errorf("[Test case %d] Expected the document, found '%q'", idx, out)
+		}
+	}
+}
+
+func Test_Crawler_fetch_traffic(t *testing.T) {
+	doc := []byte("<rss><channel><title>" + strings.Repeat("news ", 100) + "</title></channel></rss>")
+	gzipped := compress(t, "gzip", doc)
+	brotlied := compress(t, "br", doc)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gzip", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("Expected gzip to be accepted, found '%s'", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped)
+	})
+	mux.HandleFunc("/br", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Write(brotlied)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	crawler, _ := NewCrawler()
+	for _, path := range []string{"/gzip", "/br"} {
+		channels, err := crawler.fetch("owner", Feed{Url: ts.URL + path})
+		if err != nil || len(channels) != 1 {
+			t.Errorf("Expected a channel from '%s', found '%v': %v", path, channels, err)
+		}
+	}
+
+	gzipTraffic := crawler.Report.Feeds[feedKey("owner", ts.URL+"/gzip")].Traffic
+	if gzipTraffic.Compressed != int64(len(gzipped)) || gzipTraffic.Decompressed != int64(len(doc)) {
+		t.Errorf("Expected %d/%d bytes, found '%+v'", len(gzipped), len(doc), gzipTraffic)
+	}
+
+	host := crawler.Report.Hosts[strings.TrimPrefix(ts.URL, "http://")]
+	if host == nil || host.Compressed != int64(len(gzipped)+len(brotlied)) || host.Decompressed != int64(2*len(doc)) {
+		t.Errorf("Expected the bytes of both feeds for the host, found '%+v'", host)
+	}
+
+	report := fmt.Sprint(crawler.Report)
+	if !strings.Contains(report, "[TRAFFIC] "+strings.TrimPrefix(ts.URL, "http://")) {
+		t.Errorf("Expected the traffic of the host in the report, found '%s'", report)
+	}
+}
@@ -1,10 +1,11 @@
 package agent
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -31,10 +32,13 @@ func (it Items) Swap(i, j int) {
 
 // Channel models a 'channel' in an RSS feed
 type Channel struct {
-	Owner string `xml:"-"           json:"-"`
-	Title string `xml:"title"       json:"title"`
-	Desc  string `xml:"description" json:"desc"`
-	Items *Items `xml:"item"        json:"items"`
+	Owner           string `xml:"-"                                                            json:"-"`
+	Title           string `xml:"title"                                                        json:"title"`
+	Desc            string `xml:"description"                                                  json:"desc"`
+	Ttl             int    `xml:"ttl"                                                          json:"-"` // minutes
+	UpdatePeriod    string `xml:"http://purl.org/rss/1.0/modules/syndication/ updatePeriod"    json:"-"` // sy:updatePeriod
+	UpdateFrequency int    `xml:"http://purl.org/rss/1.0/modules/syndication/ updateFrequency" json:"-"` // sy:updateFrequency
+	Items           *Items `xml:"item"                                                         json:"items"`
 }
 
 type Channels []*Channel
@@ -56,8 +60,22 @@ type Rss struct {
 	Channels Channels `xml:"channel"`
 }
 
+// parsers a feed may ask for, the empty one stands for the default
+var parsers = map[string]bool{
+	"":    true,
+	"rss": true,
+}
+
+// bodies are read up to this size by default
+const DefaultMaxBodySize = 10 << 20
+
 type Crawler struct {
-	Rss Rss
+	Rss         Rss
+	Report      *Report
+	Follow      bool        // follow the feeds discovered from html pages, report them otherwise
+	MaxBodySize int64       // bodies larger than this are rejected, no limit if 0
+	Lenient     bool        // recover what can be from malformed feeds
+	Politeness  *Politeness // robots.txt and delays between requests to the same host, none if nil
 }
 
 func NewCrawler() (*Crawler, error) {
@@ -65,6 +83,9 @@ func NewCrawler() (*Crawler, error) {
 		Rss: Rss{
 			Channels: Channels{},
 		},
+		Report:      NewReport(),
+		Follow:      true,
+		MaxBodySize: DefaultMaxBodySize,
 	}, nil
 }
 
@@ -78,30 +99,20 @@ func (c *Crawler) Crawl(loader *Loader) error {
 	}
 
 	for _, group := range loader.ChannelGroups {
-		for _, url := range group.Channels {
-			resp, err := http.Get(url)
-			if err != nil {
-				fmt.Printf("[ERR] Unable to GET '%s': %v", url, err)
+		for _, feed := range group.Channels {
+			if !feed.IsEnabled() {
 				continue
 			}
-			defer resp.Body.Close()
-
-			body, err := ioutil.ReadAll(resp.Body)
 
-			var rss Rss
-			err = xml.Unmarshal(body, &rss)
+			channels, err := c.fetch(group.Owner, feed)
 			if err != nil {
-				fmt.Printf("[ERR] Unable to unmarshal '%s': %v", string(body[:]), err)
+				logf("%v\n", err)
 				continue
 			}
 
-			for _, channel := range rss.Channels {
-				channel.Owner = group.Owner
-			}
-
-			err = c.merge(rss.Channels)
+			err = c.merge(channels)
 			if err != nil {
-				fmt.Errorf("[ERR] Unable to merge channels '%v'", rss.Channels)
+				logf("[ERR] Unable to merge channels '%v'\n", channels)
 			}
 		}
 	}
@@ -111,6 +122,276 @@ func (c *Crawler) Crawl(loader *Loader) error {
 	return nil
 }
 
+// download, parse and filter the channels of a single feed, reporting the outcome
+func (c *Crawler) fetch(owner string, feed Feed) (Channels, error) {
+	rep := c.Report.start(owner, feed.Url)
+
+	channels, err := c.fetchFeed(rep, feed)
+	if err != nil {
+		rep.Error = err.Error()
+		return nil, err
+	}
+
+	for _, channel := range channels {
+		channel.Owner = owner
+		if channel.Items != nil {
+			rep.Items += len(*channel.Items)
+		}
+	}
+
+	return channels, nil
+}
+
+// when the url points to an html page, the feeds it advertises are followed or reported
+func (c *Crawler) fetchFeed(rep *FeedReport, feed Feed) (Channels, error) {
+	if !parsers[feed.Parser] {
+		return nil, fmt.Errorf("[ERR] Unknown parser '%s' for '%s'", feed.Parser, feed.Url)
+	}
+
+	resp, err := c.get(feed.Url, feed)
+	if _, ok := err.(*RobotsError); ok {
+		rep.Robots = true
+	}
+	if err != nil {
+		return nil, err // already formatted
+	}
+	rep.MovedTo = resp.movedTo
+	rep.Traffic.add(resp.traffic)
+
+	if resp.StatusCode == http.StatusGone {
+		rep.Gone = true
+		return nil, fmt.Errorf("[ERR] '%s' is gone", feed.Url)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("[ERR] Unable to GET '%s': %s", feed.Url, resp.Status)
+	}
+
+	rss, err := parse(feed.Url, resp.body)
+	if err != nil && isHtml(resp.Header.Get("Content-Type"), resp.body) {
+		rss, err = c.follow(rep, feed, resp.Request.URL, resp.body)
+	} else if err != nil && c.Lenient {
+		rss, err = recoverRss(feed.Url, resp.body, err)
+		rep.Recovered = err == nil
+	}
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	err = filterItems(feed.Filters, rss.Channels)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to filter items of '%s': %v", feed.Url, err)
+	}
+
+	return rss.Channels, nil
+}
+
+// a response, along with its whole body
+type response struct {
+	*http.Response
+	body    []byte
+	movedTo string  // where the permanent redirects of the url lead, if any
+	traffic Traffic // bytes read, before and after decompression
+}
+
+// redirects followed at most, like the default client does
+const maxRedirects = 10
+
+// GET a url, reading the whole body
+// the permanent redirects the url starts with are recorded
+// when polite, urls robots.txt disallows, redirects included, fail with a RobotsError
+// the headers, credentials and client certificate of the feed are only sent to the host of the feed
+// so that they don't reach the hosts discovered pages or redirects lead to
+func (c *Crawler) get(link string, feed Feed) (*response, error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to GET '%s': %v", link, err)
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	feedHost := ""
+	if feedUrl, err := url.Parse(feed.Url); err == nil {
+		feedHost = feedUrl.Host
+	}
+	authorize(req, feed, feedHost)
+
+	transport := http.DefaultTransport
+	if feed.Auth != nil {
+		if transport, err = feed.Auth.transport(feedHost); err != nil {
+			return nil, fmt.Errorf("[ERR] Unable to load the client certificate of '%s': %v", link, err)
+		}
+	}
+
+	movedTo := ""
+	permanent := true // so far, a temporary redirect makes the following ones irrelevant
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+
+			status := req.Response.StatusCode
+			if permanent && (status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect) {
+				movedTo = req.URL.String()
+			} else {
+				permanent = false
+			}
+
+			// the client keeps the headers, and the credentials on subdomains
+			authorize(req, feed, feedHost)
+
+			if c.Politeness != nil {
+				return c.polite(req.URL)
+			}
+			return nil
+		},
+	}
+
+	if c.Politeness != nil {
+		if err := c.polite(req.URL); err != nil {
+			return nil, err // already formatted
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			if robotsErr, ok := urlErr.Err.(*RobotsError); ok {
+				return nil, robotsErr
+			}
+		}
+		return nil, fmt.Errorf("[ERR] Unable to GET '%s': %v", link, err)
+	}
+
+	defer resp.Body.Close()
+
+	if c.MaxBodySize > 0 && resp.ContentLength > c.MaxBodySize {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %d bytes, more than the %d allowed", link, resp.ContentLength, c.MaxBodySize)
+	}
+
+	// the content length may be missing or lying
+	raw, err := readAll(resp.Body, c.MaxBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", link, err)
+	}
+
+	body, err := decode(resp.Header.Get("Content-Encoding"), raw, c.MaxBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", link, err)
+	}
+
+	traffic := Traffic{Compressed: int64(len(raw)), Decompressed: int64(len(body))}
+	c.Report.count(resp.Request.URL.Host, traffic)
+
+	return &response{Response: resp, body: body, movedTo: movedTo, traffic: traffic}, nil
+}
+
+// set the headers and credentials of a feed on a request to its host, remove them from the others
+func authorize(req *http.Request, feed Feed, feedHost string) {
+	if req.URL.Host != feedHost {
+		for key, _ := range feed.Headers {
+			req.Header.Del(key)
+		}
+		if feed.Auth != nil {
+			for key, _ := range feed.Auth.Headers {
+				req.Header.Del(key)
+			}
+			req.Header.Del("Authorization")
+			req.Header.Del("Cookie")
+		}
+		return
+	}
+
+	for key, value := range feed.Headers {
+		req.Header.Set(key, value)
+	}
+	if feed.Auth != nil {
+		if len(feed.Auth.Cookies) > 0 {
+			req.Header.Del("Cookie") // set again rather than added twice on redirects
+		}
+		feed.Auth.apply(req)
+	}
+}
+
+// parse the body of a feed
+func parse(link string, body []byte) (Rss, error) {
+	var rss Rss
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.CharsetReader = charsetReader
+	err := dec.Decode(&rss)
+	if err != nil {
+		return rss, fmt.Errorf("[ERR] Unable to unmarshal '%s': %v", link, err)
+	}
+	return rss, nil
+}
+
+// parse the first feed discovered from an html page that parses
+// page is the url the page was served from, redirects followed
+func (c *Crawler) follow(rep *FeedReport, feed Feed, page *url.URL, body []byte) (Rss, error) {
+	candidates := c.discover(rep, feed, page, body)
+	if !c.Follow || len(candidates) == 0 {
+		return Rss{}, &DiscoveryError{Url: feed.Url, Candidates: candidates}
+	}
+
+	for _, candidate := range candidates {
+		resp, err := c.get(candidate.Url, feed)
+		if err != nil {
+			continue
+		}
+		rep.Traffic.add(resp.traffic)
+		if resp.StatusCode >= 400 {
+			continue
+		}
+
+		rss, err := parse(candidate.Url, resp.body)
+		if err != nil {
+			continue
+		}
+
+		logf("[INF] '%s' is an html page, following '%s'\n", feed.Url, candidate.Url)
+		return rss, nil
+	}
+
+	return Rss{}, &DiscoveryError{Url: feed.Url, Candidates: candidates}
+}
+
+// keep the items that pass all the filters
+func filterItems(filters []Filter, channels Channels) error {
+	if len(filters) == 0 {
+		return nil
+	}
+
+	compiled, err := compileFilters(filters)
+	if err != nil {
+		return err // already formatted
+	}
+
+	for _, channel := range channels {
+		if channel.Items == nil {
+			continue
+		}
+
+		kept := Items{}
+		for _, item := range *channel.Items {
+			keep := true
+			for _, filter := range compiled {
+				if !filter.keep(item) {
+					keep = false
+					break
+				}
+			}
+
+			if keep {
+				kept = append(kept, item)
+			}
+		}
+		channel.Items = &kept
+	}
+
+	return nil
+}
+
 func (c *Crawler) merge(channels []*Channel) error {
 	if channels == nil {
 		return fmt.Errorf("[ERR] Unvalid arg 'rss>Channels', %v", channels)
@@ -121,9 +402,16 @@ func (c *Crawler) merge(channels []*Channel) error {
 }
 
 func (c *Crawler) clean() {
-	for _, channel := range c.Rss.Channels {
+	cleanChannels(c.Rss.Channels)
+}
+
+func cleanChannels(channels Channels) {
+	for _, channel := range channels {
 		channel.Title = strings.TrimSpace(channel.Title)
 		channel.Desc = strings.TrimSpace(channel.Desc)
+		if channel.Items == nil { // channel with no item
+			channel.Items = &Items{}
+		}
 		for _, item := range *channel.Items {
 			item.Title = strings.TrimSpace(item.Title)
 			item.Link = strings.TrimSpace(item.Link)
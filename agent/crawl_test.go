@@ -493,11 +493,11 @@ func Test_Crawl(t *testing.T) {
 		loader.ChannelGroups = ChannelGroups{
 			ChannelGroup{
 				Owner:    owner,
-				Channels: make([]string, len(testCase.bodies)),
+				Channels: make(Feeds, len(testCase.bodies)),
 			},
 		}
 		for idx, _ := range testCase.bodies {
-			loader.ChannelGroups[0].Channels[idx] = ts.URL
+			loader.ChannelGroups[0].Channels[idx] = Feed{Url: ts.URL}
 		}
 
 		crawler, err := NewCrawler()
@@ -513,14 +513,70 @@ func Test_Crawl(t *testing.T) {
 	}
 }
 
+func Test_filterItems(t *testing.T) {
+	items := func() *Items {
+		return &Items{
+			&Item{Title: "Opinion: Markets", Link: "http://www.wsj.com/articles/opinion-markets"},
+			&Item{Title: "Markets Rally", Link: "http://www.wsj.com/articles/markets-rally"},
+			&Item{Title: "Election Results", Link: "http://www.wsj.com/articles/election-results"},
+		}
+	}
+
+	testCases := []struct {
+		filters []Filter
+		titles  []string
+	}{
+		{ // test case 0, no filter
+			[]Filter{},
+			[]string{"Opinion: Markets", "Markets Rally", "Election Results"},
+		},
+		{ // test case 1, exclude
+			[]Filter{Filter{Field: "title", Match: "^Opinion", Exclude: true}},
+			[]string{"Markets Rally", "Election Results"},
+		},
+		{ // test case 2, include
+			[]Filter{Filter{Field: "link", Match: "markets"}},
+			[]string{"Opinion: Markets", "Markets Rally"},
+		},
+		{ // test case 3, include and exclude
+			[]Filter{Filter{Field: "link", Match: "markets"}, Filter{Field: "title", Match: "^Opinion", Exclude: true}},
+			[]string{"Markets Rally"},
+		},
+	}
+
+	for idx, testCase := range testCases {
+		channels := Channels{&Channel{Items: items()}}
+
+		err := filterItems(testCase.filters, channels)
+		if err != nil {
+			t.Error(err)
+		}
+
+		titles := []string{}
+		for _, item := range *channels[0].Items {
+			titles = append(titles, item.Title)
+		}
+
+		if !reflect.DeepEqual(titles, testCase.titles) {
+			t.Errorf("[Test case %d] expecting %v, got %v", idx, testCase.titles, titles)
+		}
+	}
+
+	// unknown field
+	err := filterItems([]Filter{Filter{Field: "author", Match: "any"}}, Channels{&Channel{Items: items()}})
+	if err == nil {
+		t.Errorf("expecting an error for an unknown field")
+	}
+}
+
 // Smoke test
 func Test_Crawl_(t *testing.T) {
 	t.Skip()
 	testCases := []struct {
-		urls []string
+		urls Feeds
 	}{
 		{ //test case 0
-			[]string{"http://www.wsj.com/xml/rss/3_7085.xml", "http://www.cnet.com/rss/iphone-update/"},
+			Feeds{Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"}, Feed{Url: "http://www.cnet.com/rss/iphone-update/"}},
 		},
 	}
 
@@ -546,3 +602,58 @@ func Test_Crawl_(t *testing.T) {
 		crawler.Crawl(loader)
 	}
 }
+
+func Test_Crawler_fetch_moved(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `<rss><channel><title>News</title><item><title>a</title></item></channel></rss>`)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved-again", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved-again", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/feed", http.StatusPermanentRedirect)
+	})
+	mux.HandleFunc("/temporary", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved", http.StatusFound)
+	})
+	mux.HandleFunc("/moved-then-temporary", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/temporary", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	testCases := []struct {
+		path    string
+		movedTo string
+		gone    bool
+		items   int
+	}{
+		{"/feed", "", false, 1},
+		{"/moved", ts.URL + "/feed", false, 1},                     // all the permanent redirects are followed
+		{"/temporary", "", false, 1},                               // a temporary redirect first, the url is fine
+		{"/moved-then-temporary", ts.URL + "/temporary", false, 1}, // up to the temporary redirect
+		{"/gone", "", true, 0},
+	}
+
+	crawler, _ := NewCrawler()
+	for idx, testCase := range testCases {
+		crawler.fetch("owner", Feed{Url: ts.URL + testCase.path})
+
+		rep := crawler.Report.Feeds[feedKey("owner", ts.URL+testCase.path)]
+		if rep.MovedTo != testCase.movedTo || rep.Gone != testCase.gone || rep.Items != testCase.items {
+			t.Errorf("[Test case %d] Expected moved to '%s', gone %v and %d item(s), found '%+v'", idx, testCase.movedTo, testCase.gone, testCase.items, rep)
+		}
+		if testCase.gone && rep.Error == "" {
+			t.Errorf("[Test case %d] Expected an error", idx)
+		}
+	}
+
+	// the gone one fails the crawl
+	if failed := crawler.Report.Failed(); failed != 1 {
+		t.Errorf("Expected 1 failed feed, found %d", failed)
+	}
+}
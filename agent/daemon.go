@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// a feed along with its owner
+type ownedFeed struct {
+	Owner string
+	Feed  Feed
+}
+
+// the agent that keeps polling the feeds, each one on its own schedule
+// new items are persisted as soon as they're downloaded
+// the channels are reloaded when their files change
+type Daemon struct {
+	Loader         *Loader
+	Crawler        *Crawler
+	Schedule       *Schedule
+	Moves          *Moves               // the feeds that moved or are gone are recorded to, if set
+	ReloadInterval time.Duration        // how often the channels files are checked, never if 0
+	Retention      *Retention           // applied to the store after the polls, at most every PruneInterval, if set
+	path           string               // channels file or dir the loader loaded
+	store          Store                // to save the items to
+	feeds          map[string]ownedFeed // by key
+	snapshot       snapshot             // of the channels files, as last loaded
+	pruned         time.Time            // when the retention was last applied
+	now            func() time.Time
+}
+
+// the loader is expected to have loaded the channels of path already
+func NewDaemon(loader *Loader, path string, schedule *Schedule, store Store) (*Daemon, error) {
+	if loader == nil {
+		return nil, fmt.Errorf("[ERR] 'loader' is nil")
+	}
+
+	if schedule == nil {
+		return nil, fmt.Errorf("[ERR] 'schedule' is nil")
+	}
+
+	if store == nil {
+		return nil, fmt.Errorf("[ERR] 'store' is nil")
+	}
+
+	crawler, err := NewCrawler()
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	return &Daemon{
+		Loader:         loader,
+		Crawler:        crawler,
+		Schedule:       schedule,
+		ReloadInterval: DefaultReloadInterval,
+		path:           path,
+		store:          store,
+		feeds:          map[string]ownedFeed{},
+		now:            time.Now,
+	}, nil
+}
+
+// poll the feeds when they're due, until stop is closed
+func (d *Daemon) Run(stop <-chan struct{}) error {
+	d.sync()
+	d.Schedule.Spread(d.now())
+	if err := d.Schedule.Save(); err != nil {
+		return err // already formatted
+	}
+
+	var reload <-chan time.Time
+	if d.ReloadInterval > 0 {
+		snapshot, err := d.Loader.snapshot(d.path)
+		if err != nil {
+			return err // already formatted
+		}
+		d.snapshot = snapshot
+
+		ticker := time.NewTicker(d.ReloadInterval)
+		defer ticker.Stop()
+		reload = ticker.C
+	}
+
+	for {
+		// with no feed to poll, just wait to be stopped or reloaded
+		wait := d.Schedule.MaxInterval
+		if next := d.Schedule.NextWake(); !next.IsZero() {
+			wait = next.Sub(d.now())
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return d.Schedule.Save()
+		case <-reload:
+			timer.Stop()
+			d.checkReload()
+		case <-timer.C:
+			d.pollDue()
+		}
+	}
+}
+
+// track the feeds of the loader
+func (d *Daemon) sync() {
+	d.feeds = map[string]ownedFeed{}
+	for _, group := range d.Loader.ChannelGroups {
+		for _, feed := range group.Channels {
+			d.feeds[scheduleKey(group.Owner, feed.Url)] = ownedFeed{group.Owner, feed}
+		}
+	}
+
+	added, removed := d.Schedule.Sync(d.Loader.ChannelGroups, d.now())
+	for _, name := range added {
+		logf("[INF] Scheduled '%s'\n", name)
+	}
+	for _, name := range removed {
+		logf("[INF] Unscheduled '%s'\n", name)
+		if d.Moves != nil {
+			delete(d.Moves.Feeds, name)
+		}
+	}
+
+	// the reports of the feeds no longer scheduled
+	for key, rep := range d.Crawler.Report.Feeds {
+		if _, ok := d.Schedule.Feeds[scheduleKey(rep.Owner, rep.Url)]; !ok {
+			delete(d.Crawler.Report.Feeds, key)
+		}
+	}
+}
+
+// poll the feeds that are due, then persist the schedule
+func (d *Daemon) pollDue() {
+	for _, state := range d.Schedule.Due(d.now()) {
+		d.poll(state)
+	}
+
+	if err := d.Schedule.Save(); err != nil {
+		logf("%v\n", err)
+	}
+
+	if d.Moves != nil {
+		d.Moves.Record(d.Crawler.Report, d.now())
+		if err := d.Moves.Save(); err != nil {
+			logf("%v\n", err)
+		}
+	}
+
+	d.prune()
+}
+
+// download a feed, persist its items and schedule its next poll
+func (d *Daemon) poll(state *FeedState) {
+	owned := d.feeds[state.key]
+
+	channels, err := d.Crawler.fetch(owned.Owner, owned.Feed)
+	if err == nil {
+		cleanChannels(channels)
+		err = d.save(channels)
+	}
+	if err != nil {
+		logf("%v\n", err)
+	}
+
+	d.Schedule.Update(state, owned.Feed, channels, err, d.now())
+	logf("[INF] Polled '%s', next poll at %s\n", state.name(), state.Next.Format(time.RFC3339))
+}
+
+// merge the items of the channels with the ones of the store
+func (d *Daemon) save(channels Channels) error {
+	marshaller, err := NewStoreMarshaller(d.store)
+	if err != nil {
+		return err // already formatted
+	}
+
+	err = marshaller.ReArrange(channels)
+	if err != nil {
+		return err // already formatted
+	}
+
+	return marshaller.Save()
+}
+
+// apply the retention, unless applied lately
+func (d *Daemon) prune() {
+	if d.Retention == nil || d.now().Sub(d.pruned) < PruneInterval {
+		return
+	}
+	d.pruned = d.now()
+
+	removals, err := d.Retention.Apply(d.store, d.now(), false)
+	for _, removal := range removals {
+		logf("[INF] Pruned %v\n", removal)
+	}
+	if err != nil {
+		logf("%v\n", err)
+	}
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Daemon_pollDue(t *testing.T) {
+	body := `
+<rss>
+    <channel>
+        <title>WSJ.com: World News</title>
+        <description>World News</description>
+        <ttl>180</ttl>
+        <item>
+            <title>Death Toll Rises Following Ecuador Earthquake</title>
+            <link>http://www.wsj.com/articles/death-toll-in-ecuador-earthquake</link>
+            <pubDate>Mon, 18 Apr 2016 16:06:29 +0000</pubDate>
+        </item>
+    </channel>
+</rss>`
+
+	// bootstrap a test http server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, body)
+	}))
+	defer ts.Close()
+
+	// create temp dir
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	loader, _ := NewLoader()
+	loader.ChannelGroups = ChannelGroups{
+		ChannelGroup{Owner: "wsj", Channels: Feeds{Feed{Url: ts.URL}}},
+	}
+
+	schedule, _ := NewSchedule(filepath.Join(dir, "schedule.json"))
+	daemon, err := NewDaemon(loader, "", schedule, NewDirStore(dir))
+	if err != nil {
+		t.Error(err)
+	}
+	now := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	daemon.now = func() time.Time { return now }
+
+	// under test
+	daemon.sync()
+	now = now.Add(startupSpread)
+	daemon.pollDue()
+
+	// assert, items are persisted
+	if _, err := os.Stat(filepath.Join(dir, "2016-04-18")); err != nil {
+		t.Errorf("expected the items to be persisted: %v", err)
+	}
+
+	// next poll follows the channel ttl
+	state := schedule.Feeds[scheduleKey("wsj", ts.URL)]
+	if state.Next != now.Add(3*time.Hour) {
+		t.Errorf("expected next poll in 3h, got %v", state.Next.Sub(now))
+	}
+
+	// schedule is persisted
+	if _, err := os.Stat(filepath.Join(dir, "schedule.json")); err != nil {
+		t.Errorf("expected the schedule to be persisted: %v", err)
+	}
+}
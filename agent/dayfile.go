@@ -0,0 +1,387 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/marouenj/rss/util"
+)
+
+// the suffixes of the compressed day files and archives, by compression
+var compressions = map[string]string{
+	"gzip": ".json.gz",
+	"zstd": ".json.zst",
+}
+
+// the suffix of the uncompressed day files, when they have one
+const jsonSuffix = ".json"
+
+// the suffixes a day file may have
+var daySuffixes = []string{"", jsonSuffix, ".json.gz", ".json.zst"}
+
+// layout of the months naming the archives
+const monthLayout = "2006-01"
+
+// whether a compression is known, none if empty
+func ValidCompression(name string) bool {
+	_, ok := compressions[name]
+	return ok || name == ""
+}
+
+func compressDay(compression string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case "":
+		return data, nil
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		enc, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = enc
+	default:
+		return nil, fmt.Errorf("unknown compression '%s'", compression)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress a file, as told by its suffix
+func decompressDay(path string, data []byte) ([]byte, error) {
+	switch {
+	case strings.HasSuffix(path, compressions["gzip"]):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case strings.HasSuffix(path, compressions["zstd"]):
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(data, nil)
+	}
+	return data, nil
+}
+
+// the date of a day file, empty if the name isn't one
+func dayFileDate(name string) string {
+	for _, suffix := range daySuffixes {
+		date := strings.TrimSuffix(name, suffix)
+		if suffix != "" && date == name {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, date); err == nil {
+			return date
+		}
+	}
+	return ""
+}
+
+// the month of an archive, empty if the name isn't one
+func archiveMonth(name string) string {
+	for _, suffix := range compressions {
+		month := strings.TrimSuffix(name, suffix)
+		if month == name {
+			continue
+		}
+		if _, err := time.Parse(monthLayout, month); err == nil {
+			return month
+		}
+	}
+	return ""
+}
+
+// the file of a day, whatever its compression, empty if none
+func (s *DirStore) dayFile(date string) string {
+	for _, suffix := range daySuffixes {
+		path := filepath.Join(s.dir, date+suffix)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// the archive of a month, whatever its compression, empty if none
+func (s *DirStore) archiveFile(month string) string {
+	for _, name := range []string{"gzip", "zstd"} {
+		path := filepath.Join(s.dir, month+compressions[name])
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// the days of an archive as read last, told apart from the ones since by mtime and size
+type archived struct {
+	modTime time.Time
+	size    int64
+	days    map[string][]byte // json, by date
+}
+
+// the days of an archive, json by date
+// archives are read once, until they change
+func (s *DirStore) readArchive(path string) (map[string][]byte, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+	}
+
+	s.archivesLock.Lock()
+	defer s.archivesLock.Unlock()
+
+	if cached, ok := s.archives[path]; ok && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.days, nil
+	}
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+	}
+	data, err := decompressDay(path, file)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to decompress '%s': %v", path, err)
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to unmarshal '%s': %v", path, err)
+	}
+
+	days := map[string][]byte{}
+	for _, raw := range list {
+		var day struct {
+			Date string `json:"date"`
+		}
+		if err := json.Unmarshal(raw, &day); err != nil {
+			return nil, fmt.Errorf("[ERR] Unable to unmarshal '%s': %v", path, err)
+		}
+		days[day.Date] = raw
+	}
+
+	if s.archives == nil {
+		s.archives = map[string]archived{}
+	}
+	s.archives[path] = archived{modTime: stat.ModTime(), size: stat.Size(), days: days}
+	return days, nil
+}
+
+// write the days of an archive, removed if none is left
+// the archive is written with the compression of the store, else the one it had, else gzip
+func (s *DirStore) writeArchive(month string, days map[string][]byte) error {
+	previous := s.archiveFile(month)
+	if len(days) == 0 {
+		if previous == "" {
+			return nil
+		}
+		if err := os.Remove(previous); err != nil {
+			return fmt.Errorf("[ERR] Unable to remove '%s': %v", previous, err)
+		}
+		return nil
+	}
+
+	dates := []string{}
+	for date, _ := range days {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	list := []json.RawMessage{}
+	for _, date := range dates {
+		list = append(list, days[date])
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to marshal: %v", err)
+	}
+
+	compression := s.Compression
+	if compression == "" && previous != "" {
+		compression = compressionOf(previous)
+	}
+	if compression == "" {
+		compression = "gzip"
+	}
+	path := filepath.Join(s.dir, month+compressions[compression])
+	data, err = compressDay(compression, data)
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to compress '%s': %v", path, err)
+	}
+	if err := util.WriteFileAtomic(path, data, 0666); err != nil {
+		return err // already formatted
+	}
+
+	if previous != "" && previous != path {
+		if err := os.Remove(previous); err != nil {
+			return fmt.Errorf("[ERR] Unable to remove '%s': %v", previous, err)
+		}
+	}
+	return nil
+}
+
+// the day of a date kept in the archive of its month, nil if none
+func (s *DirStore) archivedDay(date string) (*Day, error) {
+	path := s.archiveFile(date[:len(monthLayout)])
+	if path == "" {
+		return nil, nil
+	}
+
+	days, err := s.readArchive(path)
+	if err != nil {
+		return nil, err // already formatted
+	}
+	data, ok := days[date]
+	if !ok {
+		return nil, nil
+	}
+
+	day, problem := parseDay(data)
+	if problem != "" {
+		return nil, fmt.Errorf("[ERR] Unable to load '%s' from '%s': %s", date, path, problem)
+	}
+	return day, nil
+}
+
+// remove a day from the archive of its month
+// returns whether it was archived
+func (s *DirStore) unarchiveDay(date string) (bool, error) {
+	month := date[:len(monthLayout)]
+	path := s.archiveFile(month)
+	if path == "" {
+		return false, nil
+	}
+
+	days, err := s.readArchive(path)
+	if err != nil {
+		return false, err // already formatted
+	}
+	if _, ok := days[date]; !ok {
+		return false, nil
+	}
+
+	left := map[string][]byte{}
+	for d, data := range days {
+		if d != date {
+			left[d] = data
+		}
+	}
+	return true, s.writeArchive(month, left)
+}
+
+// roll the day files before a date into the archives of their months
+// days archived already are merged with their day file
+// fn, if set, is called once each month is archived, with its dates
+func (s *DirStore) Archive(before string, fn func(path string, dates []string)) error {
+	unlock, err := s.Lock()
+	if err != nil {
+		return err // already formatted
+	}
+	defer unlock()
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to list '%s': %v", s.dir, err)
+	}
+
+	months := map[string][]string{} // dates, by month
+	for _, entry := range entries {
+		date := dayFileDate(entry.Name())
+		if entry.IsDir() || date == "" || date >= before {
+			continue
+		}
+		month := date[:len(monthLayout)]
+		months[month] = append(months[month], date)
+	}
+
+	keys := []string{}
+	for month, _ := range months {
+		keys = append(keys, month)
+	}
+	sort.Strings(keys)
+
+	for _, month := range keys {
+		if err := s.rollMonth(month, months[month]); err != nil {
+			return err // already formatted
+		}
+		if fn != nil {
+			fn(s.archiveFile(month), months[month])
+		}
+	}
+
+	return nil
+}
+
+// move day files into the archive of their month
+func (s *DirStore) rollMonth(month string, dates []string) error {
+	days := map[string][]byte{}
+	if path := s.archiveFile(month); path != "" {
+		archivedDays, err := s.readArchive(path)
+		if err != nil {
+			return err // already formatted
+		}
+		for date, data := range archivedDays {
+			days[date] = data
+		}
+	}
+
+	paths := []string{}
+	for _, date := range dates {
+		path := s.dayFile(date)
+		day, err := s.LoadDay(date)
+		if err != nil {
+			return err // already formatted
+		}
+
+		// merged with the version archived before, if any
+		if data, ok := days[date]; ok {
+			dest, problem := parseDay(data)
+			if problem == "" && dest.Owners != nil && day.Owners != nil {
+				merge(*day, *dest)
+				clean(*dest)
+				day = dest
+			}
+		}
+
+		data, err := encodeDay(day)
+		if err != nil {
+			return fmt.Errorf("[ERR] Unable to marshal: %v", err)
+		}
+		days[date] = data
+		paths = append(paths, path)
+	}
+
+	if err := s.writeArchive(month, days); err != nil {
+		return err // already formatted
+	}
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("[ERR] Unable to remove '%s': %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// types of the feeds html pages advertise
+var feedTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+}
+
+// paths probed when a page advertises no feed
+var commonPaths = []string{"/feed", "/rss.xml", "/atom.xml"}
+
+var (
+	linkTag   = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+	baseTag   = regexp.MustCompile(`(?is)<base\b[^>]*>`)
+	attribute = regexp.MustCompile(`(?s)([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+)
+
+// a feed discovered from an html page
+type Candidate struct {
+	Url   string `json:"url"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// the error of a feed url pointing to an html page
+type DiscoveryError struct {
+	Url        string
+	Candidates []Candidate
+}
+
+func (e *DiscoveryError) Error() string {
+	if len(e.Candidates) == 0 {
+		return fmt.Sprintf("[ERR] '%s' is an html page advertising no feed", e.Url)
+	}
+
+	urls := []string{}
+	for _, candidate := range e.Candidates {
+		urls = append(urls, candidate.Url)
+	}
+	return fmt.Sprintf("[ERR] '%s' is an html page, feeds found: %s", e.Url, strings.Join(urls, ", "))
+}
+
+// whether a response is an html page, by its content type or its content
+// servers often send html with no or a wrong content type
+func isHtml(contentType string, body []byte) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+			return true
+		}
+	}
+
+	return strings.HasPrefix(http.DetectContentType(body), "text/html")
+}
+
+// the feeds advertised by the 'link' tags of an html page, in page order
+// relative urls are resolved against the 'base' tag of the page if any, else against the url of the page
+func discoverLinks(page *url.URL, body []byte) []Candidate {
+	base := page
+	if tag := baseTag.Find(body); tag != nil {
+		if ref, err := url.Parse(strings.TrimSpace(tagAttributes(tag)["href"])); err == nil {
+			base = page.ResolveReference(ref)
+		}
+	}
+
+	candidates := []Candidate{}
+	seen := map[string]bool{}
+	for _, tag := range linkTag.FindAll(body, -1) {
+		attrs := tagAttributes(tag)
+
+		if !hasToken(attrs["rel"], "alternate") {
+			continue
+		}
+		mediaType, _, err := mime.ParseMediaType(attrs["type"])
+		if err != nil || !feedTypes[mediaType] {
+			continue
+		}
+
+		ref, err := url.Parse(strings.TrimSpace(attrs["href"]))
+		if err != nil || attrs["href"] == "" {
+			continue
+		}
+		link := base.ResolveReference(ref).String()
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+
+		candidates = append(candidates, Candidate{Url: link, Type: mediaType, Title: strings.TrimSpace(attrs["title"])})
+	}
+
+	return candidates
+}
+
+// the attributes of an html tag, by lower case name
+func tagAttributes(tag []byte) map[string]string {
+	attrs := map[string]string{}
+	for _, match := range attribute.FindAllSubmatch(tag, -1) {
+		value := strings.Trim(string(match[2]), `"'`)
+		attrs[strings.ToLower(string(match[1]))] = html.UnescapeString(value)
+	}
+	return attrs
+}
+
+// whether a space separated list of tokens, like the 'rel' attribute, holds a token
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// the feeds an html page points to
+// those it advertises, or else the common paths of its site that don't answer with html
+// page is the url the page was served from, redirects followed
+// the bytes read are accounted to the report of the feed
+func (c *Crawler) discover(rep *FeedReport, feed Feed, page *url.URL, body []byte) []Candidate {
+	candidates := discoverLinks(page, body)
+	if len(candidates) > 0 {
+		return candidates
+	}
+
+	for _, p := range commonPaths {
+		link := page.ResolveReference(&url.URL{Path: p}).String()
+
+		resp, err := c.get(link, feed)
+		if err != nil {
+			continue
+		}
+		rep.Traffic.add(resp.traffic)
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		contentType := resp.Header.Get("Content-Type")
+		if isHtml(contentType, resp.body) {
+			continue
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		candidates = append(candidates, Candidate{Url: link, Type: mediaType})
+	}
+
+	return candidates
+}
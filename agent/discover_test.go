@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func Test_discoverLinks(t *testing.T) {
+	testCases := []struct {
+		page       string
+		body       string
+		candidates []Candidate
+	}{
+		{ // relative and absolute urls, in page order
+			"http://www.wsj.com/news/world",
+			`<html><head>
+<link rel="stylesheet" href="/style.css">
+<link rel="alternate" type="application/rss+xml" title="World News" href="/xml/rss/3_7085.xml">
+<LINK REL='Alternate' TYPE='application/atom+xml' HREF='http://feeds.wsj.com/atom'>
+</head></html>`,
+			[]Candidate{
+				{Url: "http://www.wsj.com/xml/rss/3_7085.xml", Type: "application/rss+xml", Title: "World News"},
+				{Url: "http://feeds.wsj.com/atom", Type: "application/atom+xml"},
+			},
+		},
+		{ // entities, parameters of the type, many rel tokens, duplicates
+			"https://example.com/blog/",
+			`<link href="feed?a=1&amp;b=2" type="application/feed+json; charset=utf-8" rel="home alternate">
+<link href="feed?a=1&amp;b=2" type="application/feed+json" rel="alternate">`,
+			[]Candidate{
+				{Url: "https://example.com/blog/feed?a=1&b=2", Type: "application/feed+json"},
+			},
+		},
+		{ // resolved against the base of the page
+			"http://example.com/blog/post",
+			`<head><base href="https://cdn.example.com/news/"><link rel="alternate" type="application/rss+xml" href="rss.xml"></head>`,
+			[]Candidate{
+				{Url: "https://cdn.example.com/news/rss.xml", Type: "application/rss+xml"},
+			},
+		},
+		{ // other alternates
+			"http://example.com",
+			`<link rel="alternate" hreflang="fr" href="/fr"><link rel="alternate" type="text/html" href="/m">`,
+			[]Candidate{},
+		},
+	}
+
+	for idx, testCase := range testCases {
+		page, _ := url.Parse(testCase.page)
+		candidates := discoverLinks(page, []byte(testCase.body))
+		if !reflect.DeepEqual(candidates, testCase.candidates) {
+			t.Errorf("[Test case %d] Expected '%v', found '%v'", idx, testCase.candidates, candidates)
+		}
+	}
+}
+
+func Test_isHtml(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		body        string
+		html        bool
+	}{
+		{"text/html; charset=utf-8", "<p>hi</p>", true},
+		{"", "<!DOCTYPE html><html></html>", true},
+		{"application/xml", "\n<html><head></head></html>", true}, // wrong content type
+		{"application/rss+xml", "<?xml version=\"1.0\"?><rss></rss>", false},
+		{"", "<rss></rss>", false},
+	}
+
+	for idx, testCase := range testCases {
+		if html := isHtml(testCase.contentType, []byte(testCase.body)); html != testCase.html {
+			t.Errorf("[Test case %d] Expected %v, found %v", idx, testCase.html, html)
+		}
+	}
+}
+
+func Test_Crawler_fetch_html(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/advertised", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `<html><head><link rel="alternate" type="application/rss+xml" href="/news.xml"></head></html>`)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/section/page", http.StatusFound)
+	})
+	mux.HandleFunc("/section/page", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `<html><head><link rel="alternate" type="application/rss+xml" href="news.xml"></head></html>`)
+	})
+	mux.HandleFunc("/section/news.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `<rss><channel><title>Section</title></channel></rss>`)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `<html><body>no link</body></html>`)
+	})
+	mux.HandleFunc("/news.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `<rss><channel><title>News</title></channel></rss>`)
+	})
+	mux.HandleFunc("/rss.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintln(w, `<rss><channel><title>Common</title></channel></rss>`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	testCases := []struct {
+		url        string
+		follow     bool
+		title      string   // of the channel followed
+		candidates []string // reported
+	}{
+		{ts.URL + "/advertised", true, "News", nil},
+		{ts.URL + "/plain", true, "Common", nil}, // common paths
+		{ts.URL + "/advertised", false, "", []string{ts.URL + "/news.xml"}},
+		{ts.URL + "/plain", false, "", []string{ts.URL + "/rss.xml"}},
+		{ts.URL + "/moved", true, "Section", nil}, // resolved against the page redirected to
+		{ts.URL + "/moved", false, "", []string{ts.URL + "/section/news.xml"}},
+	}
+
+	for idx, testCase := range testCases {
+		crawler, _ := NewCrawler()
+		crawler.Follow = testCase.follow
+
+		channels, err := crawler.fetch("owner", Feed{Url: testCase.url})
+		if testCase.candidates == nil {
+			if err != nil || len(channels) != 1 || channels[0].Title != testCase.title {
+				t.Errorf("[Test case %d] Expected channel '%s', found '%v': %v", idx, testCase.title, channels, err)
+			}
+			continue
+		}
+
+		discovery, ok := err.(*DiscoveryError)
+		if !ok {
+			t.Errorf("[Test case %d] Expected a discovery error, found '%v'", idx, err)
+			continue
+		}
+		urls := []string{}
+		for _, candidate := range discovery.Candidates {
+			urls = append(urls, candidate.Url)
+		}
+		if !reflect.DeepEqual(urls, testCase.candidates) {
+			t.Errorf("[Test case %d] Expected '%v', found '%v'", idx, testCase.candidates, urls)
+		}
+	}
+}
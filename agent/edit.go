@@ -0,0 +1,338 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/marouenj/rss/util"
+)
+
+// the first file of the last load declaring an owner, empty if none
+// files are read as written, with no expansion
+func (l *Loader) OwnerFile(owner string) (string, error) {
+	for _, file := range l.Files {
+		groups, _, err := readChannelsFile(file)
+		if err != nil {
+			return "", err // already formatted
+		}
+		for _, group := range groups {
+			if group.Owner == owner {
+				return file, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// the first file of the last load holding the url for an owner, as written, empty if none
+func (l *Loader) FeedFile(owner, link string) (string, error) {
+	for _, file := range l.Files {
+		groups, _, err := readChannelsFile(file)
+		if err != nil {
+			return "", err // already formatted
+		}
+		for _, group := range groups {
+			if group.Owner != owner {
+				continue
+			}
+			for _, feed := range group.Channels {
+				if feed.Url == link {
+					return file, nil
+				}
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// add a feed to an owner in a channels file, the file is created if need be
+// if the owner already has the url, the options are merged into the existing entry
+func AddFeed(file, owner string, feed Feed) error {
+	if owner == "" {
+		return fmt.Errorf("[ERR] Missing owner for '%s'", feed.Url)
+	}
+	if err := checkUrl(feed.Url); err != nil {
+		return err // already formatted
+	}
+
+	groups, format := ChannelGroups{}, formatOrJson(file)
+	if _, err := os.Stat(file); err == nil {
+		groups, format, err = readEditedFile(file)
+		if err != nil {
+			return err // already formatted
+		}
+	}
+
+	groups = append(groups, ChannelGroup{Owner: owner, Channels: Feeds{feed}})
+
+	return writeChannelsFile(file, groups, format)
+}
+
+// remove the url of an owner from a channels file
+// returns whether the url was found, the file is left as is otherwise
+func RemoveFeed(file, owner, link string) (bool, error) {
+	groups, format, err := readEditedFile(file)
+	if err != nil {
+		return false, err // already formatted
+	}
+
+	feed, kept := takeFeed(groups, owner, link)
+	if feed == nil {
+		return false, nil
+	}
+
+	return true, writeChannelsFile(file, kept, format)
+}
+
+// move the url of an owner to another owner, possibly in another file
+// the options of the feed are carried over
+// returns whether the url was found, the files are left as is otherwise
+func MoveFeed(from, fromOwner, to, toOwner, link string) (bool, error) {
+	if toOwner == "" {
+		return false, fmt.Errorf("[ERR] Missing owner to move '%s' to", link)
+	}
+
+	groups, format, err := readEditedFile(from)
+	if err != nil {
+		return false, err // already formatted
+	}
+
+	feed, kept := takeFeed(groups, fromOwner, link)
+	if feed == nil {
+		return false, nil
+	}
+
+	if sameFile(from, to) {
+		kept = append(kept, ChannelGroup{Owner: toOwner, Channels: Feeds{*feed}})
+		return true, writeChannelsFile(from, kept, format)
+	}
+
+	// add first, a failure leaves the feed where it was
+	if err := AddFeed(to, toOwner, *feed); err != nil {
+		return false, err // already formatted
+	}
+
+	return true, writeChannelsFile(from, kept, format)
+}
+
+// change the url of a feed of an owner, keeping its options
+// returns whether the url was found, the file is left as is otherwise
+func RewriteFeed(file, owner, link, to string) (bool, error) {
+	if err := checkUrl(to); err != nil {
+		return false, err // already formatted
+	}
+
+	return editFeed(file, owner, link, func(feed *Feed) {
+		feed.Url = to
+	})
+}
+
+// disable a feed of an owner, keeping it in the channels file
+// returns whether the url was found, the file is left as is otherwise
+func DisableFeed(file, owner, link string) (bool, error) {
+	return editFeed(file, owner, link, func(feed *Feed) {
+		disabled := false
+		feed.Enabled = &disabled
+	})
+}
+
+// apply a change to a feed of an owner and write the file back
+func editFeed(file, owner, link string, change func(feed *Feed)) (bool, error) {
+	groups, format, err := readEditedFile(file)
+	if err != nil {
+		return false, err // already formatted
+	}
+
+	feed, kept := takeFeed(groups, owner, link)
+	if feed == nil {
+		return false, nil
+	}
+
+	change(feed)
+	kept = append(kept, ChannelGroup{Owner: owner, Channels: Feeds{*feed}})
+
+	return true, writeChannelsFile(file, kept, format)
+}
+
+// take the url of an owner out of the groups
+// returns the feed, nil if not found, and the groups left
+// an owner with no url left is dropped
+func takeFeed(groups ChannelGroups, owner, link string) (*Feed, ChannelGroups) {
+	var taken *Feed
+	kept := ChannelGroups{}
+	for _, group := range groups {
+		if group.Owner == owner {
+			feeds := Feeds{}
+			for idx, feed := range group.Channels {
+				if feed.Url == link {
+					if taken == nil {
+						taken = &group.Channels[idx]
+					} else { // duplicate entry, its options are merged
+						taken.merge(feed)
+					}
+					continue
+				}
+				feeds = append(feeds, feed)
+			}
+			group.Channels = feeds
+		}
+
+		if group.Include == "" && len(group.Channels) == 0 {
+			continue
+		}
+		kept = append(kept, group)
+	}
+
+	return taken, kept
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// check a feed can be polled, by fetching and parsing it once
+// urls of html pages fail with a *DiscoveryError, reporting the feeds they advertise
+func CheckFeed(feed Feed) error {
+	if err := checkUrl(feed.Url); err != nil {
+		return err // already formatted
+	}
+
+	crawler, err := NewCrawler()
+	if err != nil {
+		return err // already formatted
+	}
+	crawler.Follow = false
+
+	channels, err := crawler.fetch("", feed)
+	if err != nil {
+		return err // already formatted
+	}
+	if len(channels) == 0 {
+		return fmt.Errorf("[ERR] No channel in '%s'", feed.Url)
+	}
+
+	return nil
+}
+
+// read the groups of a channels file, as written
+func readChannelsFile(file string) (ChannelGroups, string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("[ERR] Unable to read '%s': %v", file, err)
+	}
+
+	format := formatOrJson(file)
+	groups, err := UnmarshalChannelGroups(data, format)
+	if err != nil {
+		return nil, "", fmt.Errorf("[ERR] Unable to unmarshal '%s': %v", file, err)
+	}
+
+	return groups, format, nil
+}
+
+// read the groups of a channels file to be written back
+// yaml and toml files are written from the groups, their comments would be lost, so they're refused
+func readEditedFile(file string) (ChannelGroups, string, error) {
+	groups, format, err := readChannelsFile(file)
+	if err != nil || format == FormatJson {
+		return groups, format, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("[ERR] Unable to read '%s': %v", file, err)
+	}
+	if line := commentLine(data); line > 0 {
+		return nil, "", fmt.Errorf("[ERR] Unable to edit '%s': its comments, from line %d, would be lost, remove them or edit it by hand", file, line)
+	}
+	return groups, format, nil
+}
+
+// the line of the first comment of a yaml or toml document, 0 if none
+// comments start with a '#' out of quotes, at the start of a line or after a space
+func commentLine(data []byte) int {
+	for idx, line := range strings.Split(string(data), "\n") {
+		quote := rune(0)
+		escaped := false
+		previous := ' '
+		for _, c := range line {
+			switch {
+			case escaped:
+				escaped = false
+			case quote == '"' && c == '\\':
+				escaped = true
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '#' && (previous == ' ' || previous == '\t'):
+				return idx + 1
+			}
+			previous = c
+		}
+	}
+	return 0
+}
+
+// write the groups back to a channels file, sorted and with no duplicate
+// include entries come last, in their original order
+func writeChannelsFile(file string, groups ChannelGroups, format string) error {
+	owned := ChannelGroups{}
+	includes := ChannelGroups{}
+	for _, group := range groups {
+		if group.Include != "" {
+			includes = append(includes, group)
+		} else {
+			owned = append(owned, group)
+		}
+	}
+
+	sort.Stable(owned)
+	if err := owned.mergeOwners(); err != nil {
+		return fmt.Errorf("[ERR] Unable to merge owners of '%s': %v", file, err)
+	}
+	for _, group := range owned {
+		sort.Stable(group.Channels)
+	}
+	if err := owned.cleanLinks(); err != nil {
+		return fmt.Errorf("[ERR] Unable to clean links of '%s': %v", file, err)
+	}
+
+	bytes, err := MarshalChannelGroups(append(owned, includes...), format)
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to marshal '%s': %v", file, err)
+	}
+
+	return util.WriteFileAtomic(file, bytes, 0666)
+}
+
+// files with no known extension are assumed to be json
+func formatOrJson(file string) string {
+	if format := FormatOf(file); format != "" {
+		return format
+	}
+	return FormatJson
+}
+
+// only absolute http(s) urls can be polled
+func checkUrl(link string) error {
+	u, err := url.Parse(link)
+	if err != nil {
+		return fmt.Errorf("[ERR] Invalid url '%s': %v", link, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("[ERR] Invalid url '%s', expected an absolute http or https url", link)
+	}
+	return nil
+}
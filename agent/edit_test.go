@@ -0,0 +1,297 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_AddFeed(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"news.json": `[{"owner": "wsj", "channels": ["http://b.com/rss", "http://${HOST}/rss"]}, {"include": "teams/*.json"}]`,
+	})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "news.json")
+
+	testCases := []struct {
+		owner string
+		feed  Feed
+		err   bool
+	}{
+		{"wsj", Feed{Url: "http://a.com/rss"}, false},
+		{"wsj", Feed{Url: "http://b.com/rss", Tags: []string{"world"}}, false}, // merged into the existing entry
+		{"cnet", Feed{Url: "https://c.com/rss"}, false},
+		{"cnet", Feed{Url: "ftp://c.com/rss"}, true},
+		{"", Feed{Url: "http://d.com/rss"}, true},
+	}
+
+	for idx, testCase := range testCases {
+		err := AddFeed(file, testCase.owner, testCase.feed)
+		if (err != nil) != testCase.err {
+			t.Errorf("[Test case %d] Expected error %v, found '%v'", idx, testCase.err, err)
+		}
+	}
+
+	// sorted, with no duplicate, references and includes kept as written
+	expected := `[
+  {
+    "owner": "cnet",
+    "channels": [
+      "https://c.com/rss"
+    ]
+  },
+  {
+    "owner": "wsj",
+    "channels": [
+      "http://${HOST}/rss",
+      "http://a.com/rss",
+      {
+        "url": "http://b.com/rss",
+        "tags": [
+          "world"
+        ]
+      }
+    ]
+  },
+  {
+    "include": "teams/*.json"
+  }
+]
+`
+	bytes, _ := ioutil.ReadFile(file)
+	if string(bytes) != expected {
+		t.Errorf("Expected '%s', found '%s'", expected, bytes)
+	}
+
+	// files are created if need be
+	created := filepath.Join(dir, "new.yaml")
+	if err := AddFeed(created, "bbc", Feed{Url: "http://bbc.com/rss"}); err != nil {
+		t.Fatal(err)
+	}
+	groups, format, err := readChannelsFile(created)
+	if err != nil || format != FormatYaml || len(groups) != 1 || groups[0].Channels[0].Url != "http://bbc.com/rss" {
+		t.Errorf("Expected a yaml file with the feed, found '%v' (%s): %v", groups, format, err)
+	}
+}
+
+func Test_RemoveFeed(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"news.json": `[{"owner": "wsj", "channels": ["http://a.com/rss", "http://b.com/rss"]}, {"owner": "cnet", "channels": ["http://c.com/rss"]}]`,
+	})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "news.json")
+
+	testCases := []struct {
+		owner string
+		url   string
+		found bool
+	}{
+		{"wsj", "http://a.com/rss", true},
+		{"wsj", "http://a.com/rss", false},
+		{"wsj", "http://c.com/rss", false}, // other owner
+		{"cnet", "http://c.com/rss", true}, // last url, the owner is dropped
+	}
+
+	for idx, testCase := range testCases {
+		found, err := RemoveFeed(file, testCase.owner, testCase.url)
+		if err != nil {
+			t.Errorf("[Test case %d] Unexpected error: %v", idx, err)
+		}
+		if found != testCase.found {
+			t.Errorf("[Test case %d] Expected found %v, found %v", idx, testCase.found, found)
+		}
+	}
+
+	groups, _, _ := readChannelsFile(file)
+	if len(groups) != 1 || groups[0].Owner != "wsj" || len(groups[0].Channels) != 1 || groups[0].Channels[0].Url != "http://b.com/rss" {
+		t.Errorf("Expected wsj with a single url left, found '%v'", groups)
+	}
+}
+
+func Test_Loader_OwnerFile(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"a.json": `[{"owner": "wsj", "channels": ["http://a.com/rss"]}]`,
+		"b.json": `[{"owner": "cnet", "channels": ["http://b.com/rss"]}, {"owner": "wsj", "channels": ["http://c.com/rss"]}]`,
+	})
+	defer os.RemoveAll(dir)
+
+	loader, _ := NewLoader()
+	if err := loader.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		owner string
+		file  string
+	}{
+		{"wsj", filepath.Join(dir, "a.json")}, // first file declaring the owner
+		{"cnet", filepath.Join(dir, "b.json")},
+		{"bbc", ""},
+	}
+
+	for idx, testCase := range testCases {
+		file, err := loader.OwnerFile(testCase.owner)
+		if err != nil {
+			t.Errorf("[Test case %d] Unexpected error: %v", idx, err)
+		}
+		if file != testCase.file {
+			t.Errorf("[Test case %d] Expected '%s', found '%s'", idx, testCase.file, file)
+		}
+	}
+}
+
+func Test_MoveFeed(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"a.json": `[{"owner": "wsj", "channels": [{"url": "http://a.com/rss", "tags": ["world"]}, "http://b.com/rss"]}]`,
+		"b.json": `[{"owner": "cnet", "channels": ["http://c.com/rss"]}]`,
+	})
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.json")
+	b := filepath.Join(dir, "b.json")
+
+	// within the same file
+	found, err := MoveFeed(a, "wsj", a, "bbc", "http://b.com/rss")
+	if err != nil || !found {
+		t.Fatalf("Expected the feed to be moved: %v", err)
+	}
+
+	// to another file, the options are carried over
+	found, err = MoveFeed(a, "wsj", b, "cnet", "http://a.com/rss")
+	if err != nil || !found {
+		t.Fatalf("Expected the feed to be moved: %v", err)
+	}
+
+	// not found, nothing changes
+	found, err = MoveFeed(a, "wsj", b, "cnet", "http://a.com/rss")
+	if err != nil || found {
+		t.Errorf("Expected the feed not to be found: %v", err)
+	}
+
+	groups, _, _ := readChannelsFile(a)
+	if len(groups) != 1 || groups[0].Owner != "bbc" || groups[0].Channels[0].Url != "http://b.com/rss" {
+		t.Errorf("Expected bbc only in '%s', found '%v'", a, groups)
+	}
+
+	groups, _, _ = readChannelsFile(b)
+	if len(groups) != 1 || len(groups[0].Channels) != 2 {
+		t.Fatalf("Expected cnet with two urls in '%s', found '%v'", b, groups)
+	}
+	moved := groups[0].Channels[0]
+	if moved.Url != "http://a.com/rss" || len(moved.Tags) != 1 || moved.Tags[0] != "world" {
+		t.Errorf("Expected the options to be carried over, found '%v'", moved)
+	}
+}
+
+func Test_CheckFeed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rss":
+			fmt.Fprintln(w, `<rss><channel><title>WSJ.com: World News</title></channel></rss>`)
+		case "/empty":
+			fmt.Fprintln(w, `<rss></rss>`)
+		default:
+			fmt.Fprintln(w, `not a feed`)
+		}
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		url string
+		err bool
+	}{
+		{ts.URL + "/rss", false},
+		{ts.URL + "/empty", true},
+		{ts.URL + "/text", true},
+		{"ftp://a.com/rss", true},
+	}
+
+	for idx, testCase := range testCases {
+		err := CheckFeed(Feed{Url: testCase.url})
+		if (err != nil) != testCase.err {
+			t.Errorf("[Test case %d] Expected error %v, found '%v'", idx, testCase.err, err)
+		}
+	}
+}
+
+func Test_RewriteFeed_DisableFeed(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"news.json": `[{"owner": "wsj", "channels": [{"url": "http://a.com/rss", "tags": ["world"]}, "http://c.com/rss"]}]`,
+	})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "news.json")
+
+	found, err := RewriteFeed(file, "wsj", "http://a.com/rss", "https://b.com/rss")
+	if err != nil || !found {
+		t.Fatalf("Expected the feed to be rewritten: %v", err)
+	}
+	found, err = DisableFeed(file, "wsj", "http://c.com/rss")
+	if err != nil || !found {
+		t.Fatalf("Expected the feed to be disabled: %v", err)
+	}
+	found, err = DisableFeed(file, "wsj", "http://a.com/rss")
+	if err != nil || found {
+		t.Errorf("Expected the old url not to be found: %v", err)
+	}
+
+	groups, _, _ := readChannelsFile(file)
+	feeds := groups[0].Channels
+	if len(feeds) != 2 || feeds[1].Url != "https://b.com/rss" || len(feeds[1].Tags) != 1 {
+		t.Errorf("Expected the url to be rewritten with its options, found '%v'", feeds)
+	}
+	if feeds[0].Url != "http://c.com/rss" || feeds[0].IsEnabled() {
+		t.Errorf("Expected the feed to be disabled, found '%v'", feeds[0])
+	}
+}
+
+func Test_commentLine(t *testing.T) {
+	testCases := []struct {
+		data string
+		line int
+	}{
+		{"- owner: wsj\n  channels:\n    - http://a.com/rss#top\n", 0},
+		{"# news\n- owner: wsj\n", 1},
+		{"- owner: wsj # the journal\n", 1},
+		{"- owner: wsj\n  channels:\n    - \"http://a.com/rss #1\"\n    - 'it''s # not'\n", 0},
+		{"[[groups]]\nowner = \"a \\\" # b\"\n\n  # disabled\n", 4},
+	}
+
+	for idx, testCase := range testCases {
+		if line := commentLine([]byte(testCase.data)); line != testCase.line {
+			t.Errorf("[Test case %d] Expected line %d, found %d", idx, testCase.line, line)
+		}
+	}
+}
+
+func Test_AddFeed_comments(t *testing.T) {
+	commented := "# the journal\n- owner: wsj\n  channels:\n    - http://a.com/rss\n"
+	dir := writeTree(t, map[string]string{
+		"commented.yaml": commented,
+		"plain.toml":     "[[groups]]\nowner = \"wsj\"\nchannels = [\"http://a.com/rss\"]\n",
+	})
+	defer os.RemoveAll(dir)
+
+	// refused, left as is
+	file := filepath.Join(dir, "commented.yaml")
+	if err := AddFeed(file, "wsj", Feed{Url: "http://b.com/rss"}); err == nil {
+		t.Errorf("Expected the edit of a commented file refused")
+	}
+	if data, _ := ioutil.ReadFile(file); string(data) != commented {
+		t.Errorf("Expected '%s' left as is, found '%s'", commented, data)
+	}
+	if _, err := MoveFeed(file, "wsj", filepath.Join(dir, "plain.toml"), "wsj", "http://a.com/rss"); err == nil {
+		t.Errorf("Expected the move from a commented file refused")
+	}
+
+	file = filepath.Join(dir, "plain.toml")
+	if err := AddFeed(file, "wsj", Feed{Url: "http://b.com/rss"}); err != nil {
+		t.Error(err)
+	}
+	groups, _, _ := readChannelsFile(file)
+	if len(groups) != 1 || len(groups[0].Channels) != 2 {
+		t.Errorf("Expected the feed added, found '%v'", groups)
+	}
+}
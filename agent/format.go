@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// formats of the channels files
+const (
+	FormatJson = "json"
+	FormatYaml = "yaml"
+	FormatToml = "toml"
+)
+
+// the channel groups of a toml file are listed under this key
+// as toml documents can't be an array at their top level
+const tomlGroupsKey = "groups"
+
+// guess the format of a channels file from its extension
+// empty if it's not a channels file
+func FormatOf(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJson
+	case ".yaml", ".yml":
+		return FormatYaml
+	case ".toml":
+		return FormatToml
+	}
+	return ""
+}
+
+// all the formats share the json schema
+// yaml and toml documents are converted to json before being decoded
+func toJson(data []byte, format string) ([]byte, error) {
+	var tree interface{}
+
+	switch format {
+	case FormatJson:
+		return data, nil
+	case FormatYaml:
+		err := yaml.Unmarshal(data, &tree)
+		if err != nil {
+			return nil, fmt.Errorf("invalid yaml: %v", err)
+		}
+		tree, err = stringKeys(tree)
+		if err != nil {
+			return nil, fmt.Errorf("invalid yaml: %v", err)
+		}
+	case FormatToml:
+		var doc map[string]interface{}
+		_, err := toml.Decode(string(data), &doc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid toml: %v", err)
+		}
+		for key, _ := range doc {
+			if key != tomlGroupsKey {
+				return nil, fmt.Errorf("invalid toml: unknown field '%s', expecting '%s'", key, tomlGroupsKey)
+			}
+		}
+		tree = doc[tomlGroupsKey]
+		if tree == nil {
+			tree = []interface{}{}
+		}
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+
+	return json.Marshal(tree)
+}
+
+// yaml maps are keyed by interface{}, json needs strings
+func stringKeys(tree interface{}) (interface{}, error) {
+	switch node := tree.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(node))
+		for key, value := range node {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key '%v' is not a string", key)
+			}
+			v, err := stringKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case []interface{}:
+		for idx, value := range node {
+			v, err := stringKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			node[idx] = v
+		}
+		return node, nil
+	}
+	return tree, nil
+}
+
+// decode channel groups from any of the formats
+func UnmarshalChannelGroups(data []byte, format string) (ChannelGroups, error) {
+	converted, err := toJson(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	var channelGroups ChannelGroups
+	err = json.Unmarshal(converted, &channelGroups)
+	if err != nil {
+		if offset, ok := errorOffset(err); ok && format == FormatJson {
+			line, column := position(converted, offset)
+			return nil, fmt.Errorf("line %d, column %d: %v", line, column, err)
+		}
+		return nil, err
+	}
+
+	return channelGroups, nil
+}
+
+// encode channel groups to any of the formats
+func MarshalChannelGroups(channelGroups ChannelGroups, format string) ([]byte, error) {
+	// groups with no channels are written as an empty list
+	groups := make(ChannelGroups, len(channelGroups))
+	for idx, group := range channelGroups {
+		if group.Channels == nil && group.Include == "" {
+			group.Channels = Feeds{}
+		}
+		groups[idx] = group
+	}
+
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJson:
+		return append(data, '\n'), nil
+	case FormatYaml:
+		// keep the keys in the order of the json schema
+		tree, err := orderedTree(json.NewDecoder(bytes.NewReader(data)))
+		if err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(tree)
+	case FormatToml:
+		var tree interface{}
+		err := json.Unmarshal(data, &tree)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		err = toml.NewEncoder(&buf).Encode(map[string]interface{}{tomlGroupsKey: tree})
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown format '%s'", format)
+}
+
+// decode the next json value, objects keep the order of their keys
+func orderedTree(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+
+	switch delim {
+	case '{':
+		m := yaml.MapSlice{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := orderedTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, yaml.MapItem{Key: key, Value: value})
+		}
+		_, err = dec.Token() // }
+		return m, err
+	default: // [
+		l := []interface{}{}
+		for dec.More() {
+			value, err := orderedTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, value)
+		}
+		_, err = dec.Token() // ]
+		return l, err
+	}
+}
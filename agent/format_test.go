@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_UnmarshalChannelGroups(t *testing.T) {
+	disabled := false
+
+	groups := ChannelGroups{
+		ChannelGroup{
+			Owner: "wsj",
+			Channels: Feeds{
+				Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
+				Feed{
+					Url:     "http://www.wsj.com/xml/rss/3_7014.xml",
+					Name:    "WSJ Business",
+					Tags:    []string{"business"},
+					Enabled: &disabled,
+					Headers: map[string]string{"Accept-Language": "en"},
+					Filters: []Filter{Filter{Field: "title", Match: "^Opinion", Exclude: true}},
+				},
+			},
+		},
+		ChannelGroup{
+			Owner:    "cnet",
+			Channels: Feeds{},
+		},
+	}
+
+	testCases := []struct {
+		format string
+		in     string
+	}{
+		{ // test case 0, json
+			FormatJson,
+			`
+			[
+				{
+					"owner": "wsj",
+					"channels": [
+						"http://www.wsj.com/xml/rss/3_7085.xml",
+						{
+							"url": "http://www.wsj.com/xml/rss/3_7014.xml",
+							"name": "WSJ Business",
+							"tags": ["business"],
+							"enabled": false,
+							"headers": {"Accept-Language": "en"},
+							"filters": [{"field": "title", "match": "^Opinion", "exclude": true}]
+						}
+					]
+				},
+				{
+					"owner": "cnet",
+					"channels": []
+				}
+			]
+			`,
+		},
+		{ // test case 1, yaml
+			FormatYaml,
+			`
+# news
+- owner: wsj
+  channels:
+  - http://www.wsj.com/xml/rss/3_7085.xml
+  - url: http://www.wsj.com/xml/rss/3_7014.xml
+    name: WSJ Business
+    tags: [business]
+    enabled: false
+    headers:
+      Accept-Language: en
+    filters:
+    - field: title
+      match: ^Opinion
+      exclude: true
+- owner: cnet
+  channels: []
+`,
+		},
+		{ // test case 2, toml
+			FormatToml,
+			`
+# news
+[[groups]]
+owner = "wsj"
+channels = [
+  "http://www.wsj.com/xml/rss/3_7085.xml",
+  { url = "http://www.wsj.com/xml/rss/3_7014.xml", name = "WSJ Business", tags = ["business"], enabled = false, headers = { Accept-Language = "en" }, filters = [{ field = "title", match = "^Opinion", exclude = true }] },
+]
+
+[[groups]]
+owner = "cnet"
+channels = []
+`,
+		},
+	}
+
+	for idx, testCase := range testCases {
+		// under test
+		actual, err := UnmarshalChannelGroups([]byte(testCase.in), testCase.format)
+		if err != nil {
+			t.Error(err)
+		}
+
+		// assert
+		if !reflect.DeepEqual(actual, groups) {
+			t.Errorf("[Test case %d], expected %+v, got %+v", idx, groups, actual)
+		}
+
+		// round trip
+		for _, format := range []string{FormatJson, FormatYaml, FormatToml} {
+			data, err := MarshalChannelGroups(actual, format)
+			if err != nil {
+				t.Error(err)
+			}
+
+			back, err := UnmarshalChannelGroups(data, format)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if !reflect.DeepEqual(back, groups) {
+				t.Errorf("[Test case %d, %s], expected %+v, got %+v", idx, format, groups, back)
+			}
+		}
+	}
+}
+
+func Test_FormatOf(t *testing.T) {
+	testCases := []struct {
+		name   string
+		format string
+	}{
+		{"news.json", FormatJson},
+		{"news.yaml", FormatYaml},
+		{"news.YML", FormatYaml},
+		{"news.toml", FormatToml},
+		{"news.txt", ""},
+		{"news", ""},
+	}
+
+	for idx, testCase := range testCases {
+		if format := FormatOf(testCase.name); format != testCase.format {
+			t.Errorf("[Test case %d], expected '%s', got '%s'", idx, testCase.format, format)
+		}
+	}
+}
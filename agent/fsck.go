@@ -0,0 +1,238 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// what checking the items dir found, and repaired
+type FsckReport struct {
+	Files    int          `json:"files"`
+	Problems []DayProblem `json:"problems"`
+	Repaired []string     `json:"repaired"` // paths
+}
+
+// check the day files and the archives hold the days as merge and clean leave them
+// sorted, with no duplicate, no null list and dated as named
+// with repair, the days are rewritten right, the day files that can't be parsed are set aside
+func (s *DirStore) Fsck(repair bool) (*FsckReport, error) {
+	if repair {
+		unlock, err := s.Lock()
+		if err != nil {
+			return nil, err // already formatted
+		}
+		defer unlock()
+	}
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) { // nothing persisted yet
+		entries, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to list '%s': %v", s.dir, err)
+	}
+
+	report := &FsckReport{Problems: []DayProblem{}, Repaired: []string{}}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+
+		var repaired bool
+		var err error
+		if date := dayFileDate(entry.Name()); date != "" {
+			repaired, err = s.fsckDayFile(path, date, repair, report)
+		} else if month := archiveMonth(entry.Name()); month != "" {
+			repaired, err = s.fsckArchive(path, month, repair, report)
+		} else {
+			continue
+		}
+		if err != nil {
+			return report, err // already formatted
+		}
+
+		report.Files++
+		if repaired {
+			report.Repaired = append(report.Repaired, path)
+		}
+	}
+
+	return report, nil
+}
+
+func (s *DirStore) fsckDayFile(path, date string, repair bool, report *FsckReport) (bool, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+	}
+
+	var day *Day
+	var problems []string
+	var fatal string
+	if data, err := decompressDay(path, file); err != nil {
+		_, fatal = parseDayFile(path, file)
+		problems = []string{fatal}
+	} else {
+		day, problems, fatal = fsckDay(date, data)
+	}
+	for _, problem := range problems {
+		report.Problems = append(report.Problems, DayProblem{Date: date, Path: path, Problem: problem})
+	}
+	if !repair || len(problems) == 0 || strings.HasPrefix(fatal, newerSchema) {
+		return false, nil
+	}
+
+	if fatal != "" {
+		if _, err := s.Quarantine(date); err != nil {
+			return false, err // already formatted
+		}
+		return true, nil
+	}
+	return true, s.saveDayAs(normalizeDay(day, date), compressionOf(path))
+}
+
+func (s *DirStore) fsckArchive(path, month string, repair bool, report *FsckReport) (bool, error) {
+	archivedDays, err := s.readArchive(path)
+	if err != nil {
+		return false, err // already formatted
+	}
+
+	dates := []string{}
+	for date, _ := range archivedDays {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	days := map[string][]byte{}
+	changed := false
+	for _, date := range dates {
+		data := archivedDays[date]
+		days[date] = data
+
+		day, problems, fatal := fsckDay(date, data)
+		if !strings.HasPrefix(date, month) {
+			problems = append(problems, fmt.Sprintf("'%s' isn't of the month of the archive", date))
+		}
+		for _, problem := range problems {
+			report.Problems = append(report.Problems, DayProblem{Date: date, Path: path, Problem: problem})
+		}
+		if !repair || len(problems) == 0 || fatal != "" {
+			continue // days of an archive can't be set aside
+		}
+
+		if days[date], err = encodeDay(normalizeDay(day, date)); err != nil {
+			return false, fmt.Errorf("[ERR] Unable to marshal: %v", err)
+		}
+		changed = true
+	}
+
+	if !changed {
+		return false, nil
+	}
+	return true, s.writeArchive(month, days)
+}
+
+// the day of some json and what's wrong with it
+// fatal tells why there's no day, e.g. the json can't be parsed
+func fsckDay(date string, data []byte) (*Day, []string, string) {
+	version, err := dayVersion(data)
+	if err == nil && version > SchemaVersion {
+		fatal := fmt.Sprintf("%s, version %d", newerSchema, version)
+		return nil, []string{fatal}, fatal
+	}
+
+	day, fatal := parseDay(data) // migrated if older, so that the day checked is the current one
+	if fatal != "" {
+		return nil, []string{fatal}, fatal
+	}
+
+	problems := []string{}
+	if version < SchemaVersion {
+		problems = append(problems, fmt.Sprintf("of version %d, older than %d", version, SchemaVersion))
+	}
+	return day, append(problems, dayViolations(day, date)...), ""
+}
+
+// what breaks the invariants of a day
+func dayViolations(day *Day, date string) []string {
+	problems := []string{}
+	if day.Date != date {
+		problems = append(problems, fmt.Sprintf("dated '%s'", day.Date))
+	}
+	if day.Owners == nil {
+		return append(problems, "null owners")
+	}
+
+	owners := map[string]bool{}
+	for idx, owner := range *day.Owners {
+		if owners[owner.Id] {
+			problems = append(problems, fmt.Sprintf("duplicate owner '%s'", owner.Id))
+		} else if idx > 0 && strings.Compare((*day.Owners)[idx-1].Id, owner.Id) > 0 {
+			problems = append(problems, fmt.Sprintf("owner '%s' out of order", owner.Id))
+		}
+		owners[owner.Id] = true
+
+		if owner.Channels == nil {
+			problems = append(problems, fmt.Sprintf("null channels of '%s'", owner.Id))
+			continue
+		}
+
+		channels := map[string]bool{}
+		for idx, channel := range *owner.Channels {
+			if channels[channel.Title] {
+				problems = append(problems, fmt.Sprintf("duplicate channel '%s' of '%s'", channel.Title, owner.Id))
+			} else if idx > 0 && strings.Compare((*owner.Channels)[idx-1].Title, channel.Title) > 0 {
+				problems = append(problems, fmt.Sprintf("channel '%s' of '%s' out of order", channel.Title, owner.Id))
+			}
+			channels[channel.Title] = true
+
+			if channel.Items == nil {
+				problems = append(problems, fmt.Sprintf("null items of '%s' of '%s'", channel.Title, owner.Id))
+				continue
+			}
+
+			items := map[string]bool{}
+			for idx, item := range *channel.Items {
+				if items[item.Title] {
+					problems = append(problems, fmt.Sprintf("duplicate item '%s' of '%s' of '%s'", item.Title, channel.Title, owner.Id))
+				} else if idx > 0 && strings.Compare((*channel.Items)[idx-1].Title, item.Title) > 0 {
+					problems = append(problems, fmt.Sprintf("item '%s' of '%s' of '%s' out of order", item.Title, channel.Title, owner.Id))
+				}
+				items[item.Title] = true
+			}
+		}
+	}
+
+	return problems
+}
+
+// a day as merge and clean would leave it, dated as named
+// duplicates are merged, the first item of a title is kept
+func normalizeDay(day *Day, date string) *Day {
+	normal := &Day{Date: date, Owners: &Owners{}}
+	if day.Owners == nil {
+		return normal
+	}
+
+	for _, owner := range *day.Owners {
+		channels := &Channels{}
+		if owner.Channels != nil {
+			for _, channel := range *owner.Channels {
+				items := &Items{}
+				if channel.Items != nil {
+					mergeItems(channel.Items, items)
+				}
+				mergeChannels(&Channels{&Channel{Title: channel.Title, Desc: channel.Desc, Items: items}}, channels)
+			}
+		}
+		mergeOwners(&Owners{&Owner{Id: owner.Id, Channels: channels}}, normal.Owners)
+	}
+
+	clean(*normal)
+	return normal
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_dayViolations(t *testing.T) {
+	testCases := []struct {
+		data     string
+		problems []string
+	}{
+		{
+			`{"version":1,"date":"2016-04-24","owners":[{"id":"cnet","channels":[{"title":"a","desc":"","items":[{"title":"x"},{"title":"y"}]}]},{"id":"wsj","channels":[]}]}`,
+			[]string{},
+		},
+		{
+			`{"version":1,"date":"2016-04-25","owners":null}`,
+			[]string{"dated '2016-04-25'", "null owners"},
+		},
+		{
+			`{"version":1,"date":"2016-04-24","owners":[{"id":"wsj","channels":null},{"id":"cnet","channels":[]},{"id":"wsj","channels":[]}]}`,
+			[]string{"null channels of 'wsj'", "owner 'cnet' out of order", "duplicate owner 'wsj'"},
+		},
+		{
+			`{"version":1,"date":"2016-04-24","owners":[{"id":"wsj","channels":[{"title":"b","items":null},{"title":"a","items":[{"title":"y"},{"title":"x"},{"title":"y"}]},{"title":"a","items":[]}]}]}`,
+			[]string{
+				"null items of 'b' of 'wsj'",
+				"channel 'a' of 'wsj' out of order",
+				"item 'x' of 'a' of 'wsj' out of order",
+				"duplicate item 'y' of 'a' of 'wsj'",
+				"duplicate channel 'a' of 'wsj'",
+			},
+		},
+	}
+
+	for idx, testCase := range testCases {
+		day, problem := parseDay([]byte(testCase.data))
+		if problem != "" {
+			t.Errorf("[Test case %d] %s", idx, problem)
+			continue
+		}
+		if problems := dayViolations(day, "2016-04-24"); !reflect.DeepEqual(problems, testCase.problems) {
+			t.Errorf("[Test case %d] Expected %v, found %v", idx, testCase.problems, problems)
+		}
+	}
+}
+
+func Test_DirStore_Fsck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"2016-04-24": `{"version":1,"date":"2016-04-24","owners":[{"id":"wsj","channels":[{"title":"World","desc":"","items":[{"title":"a","link":"http://a","desc":""}]}]}]}`,
+		"2016-04-25": `{"version":1,"date":"2016-04-20","owners":[{"id":"wsj","channels":[{"title":"World","items":[{"title":"c","link":"http://c"},{"title":"b","link":"http://b"}]},{"title":"World","items":[{"title":"c","link":"http://c2"},{"title":"d","link":"http://d"}]}]},{"id":"cnet","channels":[{"title":"iPhone","items":null}]}]}`,
+		"2016-04-26": `{"version":1,"date":"2016-04-26","owners":[{"id":"wsj","chan`,
+	}
+	for name, content := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666)
+	}
+	archive, _ := compressDay("gzip", []byte(`[{"version":1,"date":"2016-03-01","owners":[{"id":"wsj","channels":[{"title":"World","items":[{"title":"b"},{"title":"a"}]}]}]}]`))
+	ioutil.WriteFile(filepath.Join(dir, "2016-03.json.gz"), archive, 0666)
+	store := NewDirStore(dir)
+
+	expected := []DayProblem{
+		{"2016-03-01", filepath.Join(dir, "2016-03.json.gz"), "item 'a' of 'World' of 'wsj' out of order"},
+		{"2016-04-25", filepath.Join(dir, "2016-04-25"), "dated '2016-04-20'"},
+		{"2016-04-25", filepath.Join(dir, "2016-04-25"), "item 'b' of 'World' of 'wsj' out of order"},
+		{"2016-04-25", filepath.Join(dir, "2016-04-25"), "duplicate channel 'World' of 'wsj'"},
+		{"2016-04-25", filepath.Join(dir, "2016-04-25"), "owner 'cnet' out of order"},
+		{"2016-04-25", filepath.Join(dir, "2016-04-25"), "null items of 'iPhone' of 'cnet'"},
+		{"2016-04-26", filepath.Join(dir, "2016-04-26"), "truncated"},
+	}
+
+	// reported only
+	report, err := store.Fsck(false)
+	if err != nil {
+		t.Error(err)
+	}
+	if report.Files != 4 || !reflect.DeepEqual(report.Problems, expected) || len(report.Repaired) != 0 {
+		t.Errorf("Expected %+v, found %+v", expected, report)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "2016-04-25")); string(data) != files["2016-04-25"] {
+		t.Errorf("Expected nothing written without repair, found '%s'", data)
+	}
+
+	report, err = store.Fsck(true)
+	if err != nil {
+		t.Error(err)
+	}
+	repaired := []string{filepath.Join(dir, "2016-03.json.gz"), filepath.Join(dir, "2016-04-25"), filepath.Join(dir, "2016-04-26")}
+	if !reflect.DeepEqual(report.Repaired, repaired) {
+		t.Errorf("Expected %v repaired, found %v", repaired, report.Repaired)
+	}
+
+	// merged then sorted, the first item of a title kept
+	day, _ := store.LoadDay("2016-04-25")
+	expectedDay := &Day{Date: "2016-04-25", Owners: &Owners{
+		&Owner{Id: "cnet", Channels: &Channels{&Channel{Title: "iPhone", Items: &Items{}}}},
+		&Owner{Id: "wsj", Channels: &Channels{&Channel{Title: "World", Items: &Items{
+			&Item{Title: "b", Link: "http://b"},
+			&Item{Title: "c", Link: "http://c"},
+			&Item{Title: "d", Link: "http://d"},
+		}}}},
+	}}
+	if !reflect.DeepEqual(day, expectedDay) {
+		t.Errorf("Expected %+v, found %+v", expectedDay, day)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2016-04-26"+corruptSuffix)); err != nil {
+		t.Errorf("Expected the truncated file set aside, found %v", err)
+	}
+
+	// nothing left to repair
+	report, _ = store.Fsck(false)
+	if len(report.Problems) != 0 {
+		t.Errorf("Expected no problem left, found %+v", report.Problems)
+	}
+}
@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// reads channels files, following their include entries
+// a file is read once, even if it's included many times
+type includer struct {
+	seen  map[string]bool
+	files []string // read so far, in order
+	raw   bool     // whether the references are left as written, e.g. to check the includes only
+}
+
+func newIncluder() *includer {
+	return &includer{
+		seen:  map[string]bool{},
+		files: []string{},
+	}
+}
+
+// read the files in order, included files are read in place of their include entry
+func (in *includer) read(paths []string) (ChannelGroups, error) {
+	groups := ChannelGroups{}
+	for _, p := range paths {
+		sub, err := in.readFile(p, []string{})
+		if err != nil {
+			return nil, err // already formatted
+		}
+		groups = append(groups, sub...)
+	}
+
+	return groups, nil
+}
+
+// stack holds the files including this one, to detect cycles
+func (in *includer) readFile(file string, stack []string) (ChannelGroups, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to resolve '%s': %v", file, err)
+	}
+
+	for idx, p := range stack {
+		if p == abs {
+			return nil, fmt.Errorf("[ERR] Include cycle: %s", strings.Join(append(stack[idx:], abs), " -> "))
+		}
+	}
+
+	if in.seen[abs] {
+		return ChannelGroups{}, nil
+	}
+	in.seen[abs] = true
+	in.files = append(in.files, file)
+
+	var groups *ChannelGroups
+	if in.raw {
+		groups, err = readChannelGroups(file)
+	} else {
+		groups, err = NewChannelGroups("", file)
+	}
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	next := append(append([]string{}, stack...), abs)
+
+	result := ChannelGroups{}
+	for _, group := range *groups {
+		if group.Include == "" {
+			result = append(result, group)
+			continue
+		}
+
+		if group.Owner != "" || len(group.Channels) > 0 {
+			return nil, fmt.Errorf("[ERR] Invalid include '%s' in '%s': an include can't have an owner or channels", group.Include, file)
+		}
+
+		matches, err := resolveInclude(file, group.Include)
+		if err != nil {
+			return nil, err // already formatted
+		}
+
+		for _, match := range matches {
+			sub, err := in.readFile(match, next)
+			if err != nil {
+				return nil, err // already formatted
+			}
+			result = append(result, sub...)
+		}
+	}
+
+	return result, nil
+}
+
+// list the files an include entry points to, in lexical order
+// relative patterns are resolved against the dir of the including file
+func resolveInclude(file, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(file), pattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Invalid include '%s' in '%s': %v", pattern, file, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("[ERR] Include '%s' in '%s' matches no file", pattern, file)
+	}
+
+	files := []string{}
+	for _, match := range matches {
+		fi, err := os.Stat(match)
+		if err != nil {
+			return nil, fmt.Errorf("[ERR] Unable to read stats of '%s': %v", match, err)
+		}
+		if !fi.IsDir() {
+			files = append(files, match)
+		}
+	}
+
+	return files, nil
+}
+
+// match a slash separated path against a glob pattern
+// '**' matches any number of dirs, patterns with no '/' are matched against the file name
+func matchGlob(pattern, rel string) (bool, error) {
+	if !strings.Contains(pattern, "/") {
+		matched, err := path.Match(pattern, path.Base(rel))
+		if err != nil {
+			return false, fmt.Errorf("[ERR] Invalid pattern '%s': %v", pattern, err)
+		}
+		return matched, nil
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, segments []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(segments) == 0, nil
+	}
+
+	if pattern[0] == "**" {
+		// try to match the rest of the pattern at every depth
+		for idx := 0; idx <= len(segments); idx++ {
+			matched, err := matchSegments(pattern[1:], segments[idx:])
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	}
+
+	if len(segments) == 0 {
+		return false, nil
+	}
+
+	matched, err := path.Match(pattern[0], segments[0])
+	if err != nil {
+		return false, fmt.Errorf("[ERR] Invalid pattern '%s': %v", strings.Join(pattern, "/"), err)
+	}
+	if !matched {
+		return false, nil
+	}
+
+	return matchSegments(pattern[1:], segments[1:])
+}
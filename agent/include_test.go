@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_matchGlob(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		rel     string
+		matched bool
+	}{
+		{"*.json", "news.json", true},
+		{"*.json", "team/news.json", true}, // no '/', matched against the file name
+		{"*.json", "news.yaml", false},
+		{"team/*.json", "team/news.json", true},
+		{"team/*.json", "team/sub/news.json", false},
+		{"team/**/*.json", "team/news.json", true},
+		{"team/**/*.json", "team/sub/deeper/news.json", true},
+		{"**/draft-*", "team/sub/draft-news.json", true},
+		{"other/**", "team/news.json", false},
+	}
+
+	for idx, testCase := range testCases {
+		matched, err := matchGlob(testCase.pattern, testCase.rel)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if matched != testCase.matched {
+			t.Errorf("[Test case %d], expected %v, got %v", idx, testCase.matched, matched)
+		}
+	}
+}
+
+// write files to a temp dir, returns the dir
+func writeTree(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm)
+		if err != nil {
+			t.Error(err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0666)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	return dir
+}
+
+func owners(groups ChannelGroups) []string {
+	ids := []string{}
+	for _, group := range groups {
+		ids = append(ids, group.Owner)
+	}
+	return ids
+}
+
+func Test_Load_recursive(t *testing.T) {
+	files := map[string]string{
+		"a.json":               `[{"owner": "a", "channels": []}]`,
+		"team1/b.json":         `[{"owner": "b", "channels": []}]`,
+		"team1/sub/c.yaml":     "- owner: c\n  channels: []\n",
+		"team2/d.json":         `[{"owner": "d", "channels": []}]`,
+		"team2/draft-e.json":   `[{"owner": "e", "channels": []}]`,
+		"team2/sub/f.toml":     "[[groups]]\nowner = \"f\"\nchannels = []\n",
+		"team2/sub/readme.txt": "not a channels file",
+	}
+
+	testCases := []struct {
+		recursive bool
+		include   []string
+		exclude   []string
+		owners    []string
+	}{
+		{ // test case 0, not recursive
+			false, nil, nil,
+			[]string{"a"},
+		},
+		{ // test case 1, recursive
+			true, nil, nil,
+			[]string{"a", "b", "c", "d", "e", "f"},
+		},
+		{ // test case 2, recursive, exclude
+			true, nil, []string{"draft-*"},
+			[]string{"a", "b", "c", "d", "f"},
+		},
+		{ // test case 3, recursive, include and exclude
+			true, []string{"team2/**"}, []string{"draft-*"},
+			[]string{"d", "f"},
+		},
+	}
+
+	dir := writeTree(t, files)
+	defer os.RemoveAll(dir)
+
+	for idx, testCase := range testCases {
+		loader, _ := NewLoader()
+		loader.Recursive = testCase.recursive
+		loader.Include = testCase.include
+		loader.Exclude = testCase.exclude
+
+		// under test
+		err := loader.Load(dir)
+		if err != nil {
+			t.Error(err)
+		}
+
+		// assert
+		if actual := owners(loader.ChannelGroups); !reflect.DeepEqual(actual, testCase.owners) {
+			t.Errorf("[Test case %d], expected %v, got %v", idx, testCase.owners, actual)
+		}
+	}
+}
+
+func Test_Load_include(t *testing.T) {
+	testCases := []struct {
+		files  map[string]string
+		owners []string
+		err    string
+	}{
+		{ // test case 0, include by path and glob, included files read once
+			map[string]string{
+				"main.json":       `[{"owner": "a", "channels": ["http://a.com/1"]}, {"include": "teams/*.json"}, {"include": "shared/x.yaml"}]`,
+				"teams/b.json":    `[{"owner": "b", "channels": []}, {"include": "../shared/x.yaml"}]`,
+				"teams/c.json":    `[{"owner": "a", "channels": ["http://a.com/2"]}]`,
+				"shared/x.yaml":   "- owner: x\n  channels: []\n",
+				"shared/y.ignore": "",
+			},
+			[]string{"a", "b", "x"},
+			"",
+		},
+		{ // test case 1, cycle
+			map[string]string{
+				"main.json":    `[{"include": "teams/b.json"}]`,
+				"teams/b.json": `[{"include": "c.json"}]`,
+				"teams/c.json": `[{"include": "../main.json"}]`,
+			},
+			nil,
+			"Include cycle",
+		},
+		{ // test case 2, include matching nothing
+			map[string]string{
+				"main.json": `[{"include": "teams/*.json"}]`,
+			},
+			nil,
+			"matches no file",
+		},
+		{ // test case 3, include with an owner
+			map[string]string{
+				"main.json":    `[{"include": "teams/b.json", "owner": "a", "channels": ["http://a.com/1"]}]`,
+				"teams/b.json": `[{"owner": "b", "channels": []}]`,
+			},
+			nil,
+			"can't have an owner or channels",
+		},
+	}
+
+	for idx, testCase := range testCases {
+		dir := writeTree(t, testCase.files)
+
+		loader, _ := NewLoader()
+
+		// under test
+		err := loader.Load(dir)
+
+		// assert
+		if testCase.err != "" {
+			if err == nil || !strings.Contains(err.Error(), testCase.err) {
+				t.Errorf("[Test case %d], expected error '%s', got %v", idx, testCase.err, err)
+			}
+		} else if err != nil {
+			t.Error(err)
+		} else if actual := owners(loader.ChannelGroups); !reflect.DeepEqual(actual, testCase.owners) {
+			t.Errorf("[Test case %d], expected %v, got %v", idx, testCase.owners, actual)
+		}
+
+		// validation reports the same problems
+		diags, err := loader.Validate(dir)
+		if err != nil {
+			t.Error(err)
+		}
+		if (testCase.err != "") != (len(diags) > 0) {
+			t.Errorf("[Test case %d], unexpected diagnostics %v", idx, diags)
+		}
+
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"unicode/utf8"
+)
+
+var (
+	entityRef   = regexp.MustCompile(`^&(#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`)
+	itemElement = regexp.MustCompile(`(?s)<item\b[^>]*>.*?</item\s*>`)
+	channelHead = regexp.MustCompile(`(?s)<channel\b[^>]*>(.*?)(<item\b|</channel\s*>)`)
+)
+
+// recover what can be from a malformed feed
+// the document is cleaned up and parsed again, if it still fails
+// the well-formed items are salvaged one by one
+// err is the error of the strict parse, returned if nothing can be recovered
+func recoverRss(link string, body []byte, err error) (Rss, error) {
+	body = fixEntities(stripInvalidChars(body))
+
+	var rss Rss
+	if lenientDecoder(body).Decode(&rss) == nil {
+		return rss, nil
+	}
+
+	channel := &Channel{Items: &Items{}}
+
+	// the title and description of the channel, ahead of its items
+	if head := channelHead.FindSubmatch(body); head != nil {
+		header := append(append([]byte("<channel>"), head[1]...), "</channel>"...)
+		lenientDecoder(header).Decode(channel)
+		channel.Items = &Items{}
+	}
+
+	for _, element := range itemElement.FindAll(body, -1) {
+		var item Item
+		if lenientDecoder(element).Decode(&item) == nil {
+			*channel.Items = append(*channel.Items, &item)
+		}
+	}
+
+	if len(*channel.Items) == 0 {
+		return Rss{}, err
+	}
+
+	logf("[INF] Recovered %d item(s) of '%s'\n", len(*channel.Items), link)
+	return Rss{Channels: Channels{channel}}, nil
+}
+
+// a decoder knowing about the html entities, e.g. '&nbsp;'
+func lenientDecoder(data []byte) *xml.Decoder {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Entity = xml.HTMLEntity
+	dec.CharsetReader = charsetReader
+	return dec
+}
+
+// drop the characters xml doesn't allow
+// bytes that aren't utf-8 are kept, they're read along the charset the document declares
+func stripInvalidChars(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 || isXmlChar(r) {
+			out = append(out, data[:size]...)
+		}
+		data = data[size:]
+	}
+	return out
+}
+
+// the chars of the xml 1.0 spec
+func isXmlChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// escape the ampersands that don't start an entity reference, e.g. in 'Q&A'
+func fixEntities(data []byte) []byte {
+	var out bytes.Buffer
+	for idx := 0; idx < len(data); idx++ {
+		if data[idx] == '&' && !entityRef.Match(data[idx:]) {
+			out.WriteString("&amp;")
+			continue
+		}
+		out.WriteByte(data[idx])
+	}
+	return out.Bytes()
+}
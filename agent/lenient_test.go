@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_fixEntities(t *testing.T) {
+	testCases := []struct {
+		in  string
+		out string
+	}{
+		{"Q&A", "Q&amp;A"},
+		{"a &amp; b &lt; &#38; &#x26; &nbsp;", "a &amp; b &lt; &#38; &#x26; &nbsp;"},
+		{"?a=1&b=2", "?a=1&amp;b=2"},
+		{"&", "&amp;"},
+		{"& #38;", "&amp; #38;"},
+	}
+
+	for idx, testCase := range testCases {
+		if out := string(fixEntities([]byte(testCase.in))); out != testCase.out {
+			t.Errorf("[Test case %d] Expected '%s', found '%s'", idx, testCase.out, out)
+		}
+	}
+}
+
+func Test_stripInvalidChars(t *testing.T) {
+	in := "a\x00b\x0bc\td\xffé\U0001F600"
+	expected := "abc\td\xffé\U0001F600"
+	if out := string(stripInvalidChars([]byte(in))); out != expected {
+		t.Errorf("Expected '%q', found '%q'", expected, out)
+	}
+}
+
+func Test_recoverRss(t *testing.T) {
+	strict := errors.New("strict")
+
+	testCases := []struct {
+		in     string
+		title  string
+		titles []string
+		err    bool
+	}{
+		{ // stray control char, bare ampersand, html entity
+			"<rss><channel><title>Q&A\x0c</title><item><title>caf&eacute; &nbsp;</title></item></channel></rss>",
+			"Q&A",
+			[]string{"café \u00a0"},
+			false,
+		},
+		{ // a broken item amid well-formed ones
+			`<rss><channel><title>News</title><description>d</description>
+<item><title>a</title></item>
+<item><title>b</title><link></item>
+<item><title>c</title></item>
+</channel>`,
+			"News",
+			[]string{"a", "c"},
+			false,
+		},
+		{ // declared latin-1, its accented letters are kept
+			"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><rss><channel><title>Q&A</title><item><title>caf\xe9</title></item></channel></rss>",
+			"Q&A",
+			[]string{"café"},
+			false,
+		},
+		{ // nothing to salvage
+			"<html><body><p>oops</body>",
+			"",
+			nil,
+			true,
+		},
+	}
+
+	for idx, testCase := range testCases {
+		rss, err := recoverRss("http://example.com", []byte(testCase.in), strict)
+		if testCase.err {
+			if err != strict {
+				t.Errorf("[Test case %d] Expected the strict error, found '%v'", idx, err)
+			}
+			continue
+		}
+		if err != nil || len(rss.Channels) != 1 {
+			t.Errorf("[Test case %d] Expected a channel, found '%v': %v", idx, rss.Channels, err)
+			continue
+		}
+
+		channel := rss.Channels[0]
+		titles := []string{}
+		for _, item := range *channel.Items {
+			titles = append(titles, item.Title)
+		}
+		if channel.Title != testCase.title || !reflect.DeepEqual(titles, testCase.titles) {
+			t.Errorf("[Test case %d] Expected '%s' with '%v', found '%s' with '%v'", idx, testCase.title, testCase.titles, channel.Title, titles)
+		}
+	}
+}
+
+func Test_Crawler_fetch_lenient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<rss><channel><title>News</title><item><title>Q&A</title></item></channel></rss>")
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<rss><channel><title>%s</title></channel></rss>", strings.Repeat("a", 1000))
+	})
+	mux.HandleFunc("/large-chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<rss><channel><title>"))
+		w.(http.Flusher).Flush() // no content length
+		w.Write([]byte(strings.Repeat("a", 1000) + "</title></channel></rss>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	testCases := []struct {
+		path        string
+		lenient     bool
+		maxBodySize int64
+		recovered   bool
+		err         bool
+	}{
+		{"/broken", false, DefaultMaxBodySize, false, true},
+		{"/broken", true, DefaultMaxBodySize, true, false},
+		{"/large", false, 100, false, true},
+		{"/large-chunked", false, 100, false, true},
+		{"/large", false, 0, false, false}, // no limit
+	}
+
+	for idx, testCase := range testCases {
+		crawler, _ := NewCrawler()
+		crawler.Lenient = testCase.lenient
+		crawler.MaxBodySize = testCase.maxBodySize
+
+		_, err := crawler.fetch("owner", Feed{Url: ts.URL + testCase.path})
+		if (err != nil) != testCase.err {
+			t.Errorf("[Test case %d] Expected error %v, found '%v'", idx, testCase.err, err)
+		}
+
+		rep := crawler.Report.Feeds[feedKey("owner", ts.URL+testCase.path)]
+		if rep.Recovered != testCase.recovered {
+			t.Errorf("[Test case %d] Expected recovered %v, found %v", idx, testCase.recovered, rep.Recovered)
+		}
+	}
+}
@@ -1,19 +1,234 @@
 package agent
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
 )
 
+// filter the items of a feed based on one of their fields
+type Filter struct {
+	Field   string `json:"field"`             // 'title', 'link' or 'desc'
+	Match   string `json:"match"`             // regular expression
+	Exclude bool   `json:"exclude,omitempty"` // drop matching items instead of keeping them
+}
+
+// a filter with its pattern compiled
+type itemFilter struct {
+	Filter
+	re *regexp.Regexp
+}
+
+// compile the pattern of the filter
+func (f Filter) compile() (*itemFilter, error) {
+	switch f.Field {
+	case "title", "link", "desc":
+	default:
+		return nil, fmt.Errorf("[ERR] Unknown filter field '%s'", f.Field)
+	}
+
+	re, err := regexp.Compile(f.Match)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Invalid filter '%s': %v", f.Match, err)
+	}
+
+	return &itemFilter{Filter: f, re: re}, nil
+}
+
+// compile the filters of a feed, once for all of its items
+func compileFilters(filters []Filter) ([]*itemFilter, error) {
+	compiled := []*itemFilter{}
+	for _, filter := range filters {
+		f, err := filter.compile()
+		if err != nil {
+			return nil, err // already formatted
+		}
+		compiled = append(compiled, f)
+	}
+	return compiled, nil
+}
+
+// check whether an item passes the filter
+func (f *itemFilter) keep(item *Item) bool {
+	var value string
+	switch f.Field {
+	case "title":
+		value = item.Title
+	case "link":
+		value = item.Link
+	case "desc":
+		value = item.Desc
+	}
+
+	return f.re.MatchString(value) != f.Exclude
+}
+
+// represent a single channel of a ChannelGroup along with its options
+// in a config file, it's either a plain url or an object
+type Feed struct {
+	Url      string            `json:"url"`
+	Name     string            `json:"name,omitempty"` // display name
+	Tags     []string          `json:"tags,omitempty"`
+	Enabled  *bool             `json:"enabled,omitempty"`  // enabled unless explicitly set to false
+	Interval string            `json:"interval,omitempty"` // poll interval, e.g. '30m'
+	Headers  map[string]string `json:"headers,omitempty"`  // custom request headers
+	Auth     *Auth             `json:"auth,omitempty"`     // credentials, hidden from the logs
+	Parser   string            `json:"parser,omitempty"`   // parser to use instead of the default one
+	Filters  []Filter          `json:"filters,omitempty"`
+}
+
+func (f Feed) IsEnabled() bool {
+	return f.Enabled == nil || *f.Enabled
+}
+
+// parse the poll interval, 0 if not set
+func (f Feed) PollInterval() (time.Duration, error) {
+	if f.Interval == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(f.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("[ERR] Invalid interval '%s' for '%s': %v", f.Interval, f.Url, err)
+	}
+
+	return d, nil
+}
+
+// a feed with no option is a plain url
+func (f Feed) isPlain() bool {
+	return reflect.DeepEqual(f, Feed{Url: f.Url})
+}
+
+// accept both a plain url and an object
+func (f *Feed) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		var url string
+		if err := json.Unmarshal(data, &url); err != nil {
+			return err
+		}
+		*f = Feed{Url: url}
+		return nil
+	}
+
+	type feed Feed // drop the methods to avoid recursing
+	var tmp feed
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*f = Feed(tmp)
+	return nil
+}
+
+// write back a feed with no option as a plain url
+func (f Feed) MarshalJSON() ([]byte, error) {
+	if f.isPlain() {
+		return json.Marshal(f.Url)
+	}
+
+	type feed Feed // drop the methods to avoid recursing
+	return json.Marshal(feed(f))
+}
+
+// merge the options of a duplicate entry of the same url
+// options already set take precedence, lists and maps are united
+func (f *Feed) merge(other Feed) {
+	if f.Name == "" {
+		f.Name = other.Name
+	}
+	if f.Enabled == nil {
+		f.Enabled = other.Enabled
+	}
+	if f.Interval == "" {
+		f.Interval = other.Interval
+	}
+	if f.Parser == "" {
+		f.Parser = other.Parser
+	}
+	if f.Auth == nil { // credentials go together, they aren't united
+		f.Auth = other.Auth
+	}
+
+	if len(other.Tags) > 0 {
+		tags := append(append([]string{}, f.Tags...), other.Tags...)
+		sort.Strings(tags)
+		curr := 0
+		for idx := 1; idx < len(tags); idx++ {
+			if tags[curr] != tags[idx] {
+				curr++
+				tags[curr] = tags[idx]
+			}
+		}
+		f.Tags = tags[:curr+1]
+	}
+
+	if len(other.Headers) > 0 {
+		// written to a copy, the map may be shared with another entry
+		headers := map[string]string{}
+		for key, value := range f.Headers {
+			headers[key] = value
+		}
+		for key, value := range other.Headers {
+			if _, ok := headers[key]; !ok {
+				headers[key] = value
+			}
+		}
+		f.Headers = headers
+	}
+
+	for _, filter := range other.Filters {
+		exists := false
+		for _, existing := range f.Filters {
+			if existing == filter {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			f.Filters = append(f.Filters, filter)
+		}
+	}
+}
+
+type Feeds []Feed
+
+// implement the sort interface for Feeds
+func (fe Feeds) Len() int {
+	return len(fe)
+}
+func (fe Feeds) Less(i, j int) bool {
+	return strings.Compare(fe[i].Url, fe[j].Url) < 0
+}
+func (fe Feeds) Swap(i, j int) {
+	fe[i], fe[j] = fe[j], fe[i]
+}
+
 // represent a group of channels grouped by their common owner
+// a group may instead include other channels files, by path or glob pattern
 type ChannelGroup struct {
-	Owner    string   `json:"owner"`
-	Channels []string `json:"channels"`
+	Owner    string `json:"owner"`
+	Channels Feeds  `json:"channels"`
+	Include  string `json:"include,omitempty"`
+}
+
+// write back an include entry on its own
+func (g ChannelGroup) MarshalJSON() ([]byte, error) {
+	if g.Include != "" {
+		return json.Marshal(struct {
+			Include string `json:"include"`
+		}{g.Include})
+	}
+
+	type group ChannelGroup // drop the methods to avoid recursing
+	return json.Marshal(group(g))
 }
 
 type ChannelGroups []ChannelGroup
@@ -29,17 +244,39 @@ func (cg ChannelGroups) Swap(i, j int) {
 	cg[i], cg[j] = cg[j], cg[i]
 }
 
-// init a ChannelGroups from a json file
+// init a ChannelGroups from a json, yaml or toml file
 func NewChannelGroups(dir, fileName string) (*ChannelGroups, error) {
 	path := filepath.Join(dir, fileName)
 
+	channelGroups, err := readChannelGroups(path)
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	// expand the environment variables and secrets
+	err = channelGroups.expand()
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to expand '%s': %v", path, err)
+	}
+
+	return channelGroups, nil
+}
+
+// read a channels file, its references left as written
+func readChannelGroups(path string) (*ChannelGroups, error) {
+
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
 	}
 
-	var channelGroups ChannelGroups
-	err = json.Unmarshal(file, &channelGroups)
+	// files with no known extension are assumed to be json
+	format := FormatOf(path)
+	if format == "" {
+		format = FormatJson
+	}
+
+	channelGroups, err := UnmarshalChannelGroups(file, format)
 	if err != nil {
 		return nil, fmt.Errorf("[ERR] Unable to unmarshal '%s': %v", path, err)
 	}
@@ -57,7 +294,7 @@ func (cg *ChannelGroups) mergeOwners() error {
 	curr := 0
 	for idx, _ := range (*cg)[1:] {
 		if strings.Compare((*cg)[idx].Owner, (*cg)[idx+1].Owner) > 0 { // check is sorted
-			return fmt.Errorf("Array not sorted: %v", cg)
+			return fmt.Errorf("Owners not sorted, '%s' comes before '%s'", (*cg)[idx].Owner, (*cg)[idx+1].Owner)
 		}
 
 		if strings.Compare((*cg)[curr].Owner, (*cg)[idx+1].Owner) == 0 { // merge
@@ -77,24 +314,31 @@ func (cg *ChannelGroups) mergeOwners() error {
 }
 
 // remove duplicate links (scope is within same owner)
+// the options of the duplicates are merged into the first occurrence
 // links are assumed to be sorted
 func (cg *ChannelGroups) cleanLinks() error {
 	for idx, _ := range *cg {
+		if len((*cg)[idx].Channels) < 2 {
+			continue
+		}
+
 		curr := 0
 		for idx2, _ := range (*cg)[idx].Channels[1:] {
-			if strings.Compare((*cg)[idx].Channels[idx2], (*cg)[idx].Channels[idx2+1]) > 0 { // check is sorted
-				return fmt.Errorf("Array not sorted: %v", (*cg)[idx].Channels)
+			if strings.Compare((*cg)[idx].Channels[idx2].Url, (*cg)[idx].Channels[idx2+1].Url) > 0 { // check is sorted
+				return fmt.Errorf("Links of '%s' not sorted, '%s' comes before '%s'", (*cg)[idx].Owner, (*cg)[idx].Channels[idx2].Url, (*cg)[idx].Channels[idx2+1].Url)
 			}
 
-			if strings.Compare((*cg)[idx].Channels[curr], (*cg)[idx].Channels[idx2+1]) != 0 {
+			if strings.Compare((*cg)[idx].Channels[curr].Url, (*cg)[idx].Channels[idx2+1].Url) != 0 {
 				curr++
 				(*cg)[idx].Channels[curr] = (*cg)[idx].Channels[idx2+1]
+			} else { // merge
+				(*cg)[idx].Channels[curr].merge((*cg)[idx].Channels[idx2+1])
 			}
 		}
 
 		// resize
 		t := (*cg)[idx].Channels
-		(*cg)[idx].Channels = make([]string, curr+1)
+		(*cg)[idx].Channels = make(Feeds, curr+1)
 		copy((*cg)[idx].Channels, t)
 	}
 
@@ -104,81 +348,176 @@ func (cg *ChannelGroups) cleanLinks() error {
 // the agent responsible for loading and managing the links to the rss resources
 type Loader struct {
 	ChannelGroups ChannelGroups
+	Files         []string // files read by the last load, included ones too
+	Recursive     bool     // read the entries of sub dirs too
+	Include       []string // glob patterns of the files to read, all of them if empty
+	Exclude       []string // glob patterns of the files to skip
 }
 
 func NewLoader() (*Loader, error) {
 	return &Loader{
 		ChannelGroups: ChannelGroups{},
+		Files:         []string{},
 	}, nil
 }
 
+// a loader with no channels, sharing the options of this one
+func (l *Loader) clone() *Loader {
+	return &Loader{
+		ChannelGroups: ChannelGroups{},
+		Files:         []string{},
+		Recursive:     l.Recursive,
+		Include:       l.Include,
+		Exclude:       l.Exclude,
+	}
+}
+
 func (l *Loader) Load(file string) error {
+	paths, err := l.channelFiles(file)
+	if err != nil {
+		return err // already formatted
+	}
+
+	includer := newIncluder()
+	groups, err := includer.read(paths)
+	if err != nil {
+		return err // already formatted
+	}
+	l.ChannelGroups = append(l.ChannelGroups, groups...)
+	l.Files = includer.files
+
+	// sort owners, stable so the entries of the first files come first
+	sort.Stable(l.ChannelGroups)
+
+	// similar entries (entries of the same owner) are merged into one entry
+	if err := l.ChannelGroups.mergeOwners(); err != nil {
+		return fmt.Errorf("[ERR] Unable to merge owners: %v", err)
+	}
+
+	// sort channels, stable so the options of the first occurrence take precedence
+	for _, ChannelGroup := range l.ChannelGroups {
+		sort.Stable(ChannelGroup.Channels)
+	}
+
+	// clean links
+	if err := l.ChannelGroups.cleanLinks(); err != nil {
+		return fmt.Errorf("[ERR] Unable to clean links: %v", err)
+	}
+
+	// bad filters are reported now rather than at each crawl
+	for _, group := range l.ChannelGroups {
+		for _, feed := range group.Channels {
+			if _, err := compileFilters(feed.Filters); err != nil {
+				return fmt.Errorf("[ERR] Unable to load the filters of '%s': %v", feed.Url, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// list the channels files to read, a single file is read as is
+func (l *Loader) channelFiles(file string) ([]string, error) {
 	// open file
 	f, err := os.Open(file)
 	if err != nil {
-		return fmt.Errorf("[ERR] Unable to open '%s': %v", file, err)
+		return nil, fmt.Errorf("[ERR] Unable to open '%s': %v", file, err)
 	}
 	defer f.Close()
 
 	// get file info
 	fi, err := f.Stat()
 	if err != nil {
-		return fmt.Errorf("[ERR] Unable to read stats of '%s': %v", file, err)
+		return nil, fmt.Errorf("[ERR] Unable to read stats of '%s': %v", file, err)
 	}
 
-	if !fi.IsDir() { // is a file
-		groups, err := NewChannelGroups("", file)
-		if err != nil {
-			return err // already formatted
-		}
-		l.ChannelGroups = *groups
-	} else { // is a dir
-		entries, err := f.Readdir(-1)
-		if err != nil {
-			return fmt.Errorf("[ERR] Unable to list dir entries of '%s': %v", file, err)
-		}
+	if !fi.IsDir() {
+		return []string{file}, nil
+	}
 
-		// sort the entries, ensures lexical order
-		sort.Sort(dirEntries(entries))
+	return l.listFiles(file, "")
+}
 
-		for _, entry := range entries {
-			// don't recursively read entries
-			if entry.IsDir() {
-				continue
-			}
+// list the channels files of a dir, in lexical order of their path
+// rel is the path of the dir relative to the loaded one
+func (l *Loader) listFiles(root, rel string) ([]string, error) {
+	dir := filepath.Join(root, rel)
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to open '%s': %v", dir, err)
+	}
+	entries, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to list dir entries of '%s': %v", dir, err)
+	}
+
+	// sort the entries, ensures lexical order
+	sort.Sort(dirEntries(entries))
+
+	paths := []string{}
+	for _, entry := range entries {
+		path := filepath.Join(rel, entry.Name())
 
-			// if it's not a json file, ignore it
-			if !strings.HasSuffix(entry.Name(), ".json") {
+		if entry.IsDir() {
+			// only read the entries of sub dirs if asked to
+			if !l.Recursive {
 				continue
 			}
 
-			groups, err := NewChannelGroups(file, entry.Name())
+			sub, err := l.listFiles(root, path)
 			if err != nil {
-				return err // already formatted
+				return nil, err // already formatted
 			}
-			l.ChannelGroups = append(l.ChannelGroups, *groups...)
+			paths = append(paths, sub...)
+			continue
+		}
+
+		// if it's not a json, yaml or toml file, ignore it
+		if FormatOf(entry.Name()) == "" {
+			continue
+		}
+
+		selected, err := l.selects(filepath.ToSlash(path))
+		if err != nil {
+			return nil, err // already formatted
+		}
+		if selected {
+			paths = append(paths, filepath.Join(root, path))
 		}
 	}
 
-	// sort owners
-	sort.Sort(l.ChannelGroups)
+	return paths, nil
+}
 
-	// similar entries (entries of the same owner) are merged into one entry
-	if err := l.ChannelGroups.mergeOwners(); err != nil {
-		return fmt.Errorf("[ERR] Unable to merge owners: %v", err)
+// check a file against the include and exclude patterns
+func (l *Loader) selects(rel string) (bool, error) {
+	for _, pattern := range l.Exclude {
+		matched, err := matchGlob(pattern, rel)
+		if err != nil {
+			return false, err // already formatted
+		}
+		if matched {
+			return false, nil
+		}
 	}
 
-	// sort channels
-	for _, ChannelGroup := range l.ChannelGroups {
-		sort.Strings(ChannelGroup.Channels)
+	if len(l.Include) == 0 {
+		return true, nil
 	}
 
-	// clean links
-	if err := l.ChannelGroups.cleanLinks(); err != nil {
-		return fmt.Errorf("[ERR] Unable to clean links: %v", err)
+	for _, pattern := range l.Include {
+		matched, err := matchGlob(pattern, rel)
+		if err != nil {
+			return false, err // already formatted
+		}
+		if matched {
+			return true, nil
+		}
 	}
 
-	return nil
+	return false, nil
 }
 
 type dirEntries []os.FileInfo
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -11,6 +12,8 @@ import (
 )
 
 func Test_NewChannelGroups(t *testing.T) {
+	disabled := false
+
 	testCases := []struct {
 		json   string
 		groups ChannelGroups
@@ -27,9 +30,9 @@ func Test_NewChannelGroups(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7085.xml",
-						"http://www.wsj.com/xml/rss/3_7014.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
 					},
 				},
 			},
@@ -50,16 +53,16 @@ func Test_NewChannelGroups(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7085.xml",
-						"http://www.wsj.com/xml/rss/3_7014.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
 					},
 				},
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/iphone-update/",
-						"http://www.cnet.com/rss/android-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
+						Feed{Url: "http://www.cnet.com/rss/android-update/"},
 					},
 				},
 			},
@@ -84,21 +87,21 @@ func Test_NewChannelGroups(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7085.xml",
-						"http://www.wsj.com/xml/rss/3_7014.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
 					},
 				},
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/iphone-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
 					},
 				},
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/android-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/android-update/"},
 					},
 				},
 			},
@@ -127,26 +130,66 @@ func Test_NewChannelGroups(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7085.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
 					},
 				},
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/iphone-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
 					},
 				},
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7014.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
 					},
 				},
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/android-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/android-update/"},
+					},
+				},
+			},
+		},
+		{ // test case 4, channels with options, mixed with plain urls
+			`
+			[
+				{
+					"owner": "wsj",
+					"channels": [
+						"http://www.wsj.com/xml/rss/3_7085.xml",
+						{
+							"url": "http://www.wsj.com/xml/rss/3_7014.xml",
+							"name": "WSJ Business",
+							"tags": ["business"],
+							"enabled": false,
+							"interval": "1h",
+							"headers": {"Accept-Language": "en"},
+							"parser": "rss",
+							"filters": [{"field": "title", "match": "^Opinion", "exclude": true}]
+						}
+					]
+				}
+			]
+			`,
+			ChannelGroups{
+				ChannelGroup{
+					Owner: "wsj",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
+						Feed{
+							Url:      "http://www.wsj.com/xml/rss/3_7014.xml",
+							Name:     "WSJ Business",
+							Tags:     []string{"business"},
+							Enabled:  &disabled,
+							Interval: "1h",
+							Headers:  map[string]string{"Accept-Language": "en"},
+							Parser:   "rss",
+							Filters:  []Filter{Filter{Field: "title", Match: "^Opinion", Exclude: true}},
+						},
 					},
 				},
 			},
@@ -181,6 +224,8 @@ func Test_NewChannelGroups(t *testing.T) {
 }
 
 func Test_Load(t *testing.T) {
+	disabled := false
+
 	testCases := []struct {
 		json   []string
 		groups ChannelGroups
@@ -199,9 +244,9 @@ func Test_Load(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7014.xml",
-						"http://www.wsj.com/xml/rss/3_7085.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
 					},
 				},
 			},
@@ -224,16 +269,16 @@ func Test_Load(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/android-update/",
-						"http://www.cnet.com/rss/iphone-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/android-update/"},
+						Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
 					},
 				},
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7014.xml",
-						"http://www.wsj.com/xml/rss/3_7085.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
 					},
 				},
 			},
@@ -260,16 +305,16 @@ func Test_Load(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/android-update/",
-						"http://www.cnet.com/rss/iphone-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/android-update/"},
+						Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
 					},
 				},
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7014.xml",
-						"http://www.wsj.com/xml/rss/3_7085.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
 					},
 				},
 			},
@@ -300,16 +345,16 @@ func Test_Load(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/android-update/",
-						"http://www.cnet.com/rss/iphone-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/android-update/"},
+						Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
 					},
 				},
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7014.xml",
-						"http://www.wsj.com/xml/rss/3_7085.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
 					},
 				},
 			},
@@ -344,16 +389,16 @@ func Test_Load(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/android-update/",
-						"http://www.cnet.com/rss/iphone-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/android-update/"},
+						Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
 					},
 				},
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7014.xml",
-						"http://www.wsj.com/xml/rss/3_7085.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
 					},
 				},
 			},
@@ -372,9 +417,9 @@ func Test_Load(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7014.xml",
-						"http://www.wsj.com/xml/rss/3_7085.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
 					},
 				},
 			},
@@ -401,16 +446,16 @@ func Test_Load(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/android-update/",
-						"http://www.cnet.com/rss/iphone-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/android-update/"},
+						Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
 					},
 				},
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7014.xml",
-						"http://www.wsj.com/xml/rss/3_7085.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
 					},
 				},
 			},
@@ -445,20 +490,79 @@ func Test_Load(t *testing.T) {
 			ChannelGroups{
 				ChannelGroup{
 					Owner: "cnet",
-					Channels: []string{
-						"http://www.cnet.com/rss/android-update/",
-						"http://www.cnet.com/rss/iphone-update/",
+					Channels: Feeds{
+						Feed{Url: "http://www.cnet.com/rss/android-update/"},
+						Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
 					},
 				},
 				ChannelGroup{
 					Owner: "wsj",
-					Channels: []string{
-						"http://www.wsj.com/xml/rss/3_7014.xml",
-						"http://www.wsj.com/xml/rss/3_7085.xml",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
+					},
+				},
+			},
+		},
+		{ // test case 8, multiple files, same link with options, first occurrence takes precedence
+			[]string{
+				`
+				[
+					{
+						"owner": "wsj",
+						"channels": [
+							{"url": "http://www.wsj.com/xml/rss/3_7085.xml", "name": "World", "tags": ["news"], "headers": {"X-A": "1"}},
+							"http://www.wsj.com/xml/rss/3_7014.xml"
+						]
+					}
+				]
+				`,
+				`
+				[
+					{
+						"owner": "wsj",
+						"channels": [
+							{"url": "http://www.wsj.com/xml/rss/3_7085.xml", "name": "World News", "interval": "2h", "tags": ["world", "news"], "headers": {"X-A": "2", "X-B": "3"}},
+							{"url": "http://www.wsj.com/xml/rss/3_7014.xml", "enabled": false}
+						]
+					}
+				]
+				`,
+			},
+			ChannelGroups{
+				ChannelGroup{
+					Owner: "wsj",
+					Channels: Feeds{
+						Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml", Enabled: &disabled},
+						Feed{
+							Url:      "http://www.wsj.com/xml/rss/3_7085.xml",
+							Name:     "World",
+							Tags:     []string{"news", "world"},
+							Interval: "2h",
+							Headers:  map[string]string{"X-A": "1", "X-B": "3"},
+						},
 					},
 				},
 			},
 		},
+		{ // test case 9, one file, owner without channels
+			[]string{
+				`
+				[
+					{
+						"owner": "wsj",
+						"channels": []
+					}
+				]
+				`,
+			},
+			ChannelGroups{
+				ChannelGroup{
+					Owner:    "wsj",
+					Channels: Feeds{},
+				},
+			},
+		},
 	}
 
 	for idx, testCase := range testCases {
@@ -497,3 +601,140 @@ func Test_Load(t *testing.T) {
 		os.RemoveAll(dir)
 	}
 }
+
+func Test_FeedMarshalJSON(t *testing.T) {
+	testCases := []struct {
+		feed Feed
+		json string
+	}{
+		{ // test case 0, plain url
+			Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
+			`"http://www.wsj.com/xml/rss/3_7085.xml"`,
+		},
+		{ // test case 1, url with options
+			Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml", Name: "World", Tags: []string{"news"}},
+			`{"url":"http://www.wsj.com/xml/rss/3_7085.xml","name":"World","tags":["news"]}`,
+		},
+	}
+
+	for idx, testCase := range testCases {
+		bytes, err := json.Marshal(testCase.feed)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if string(bytes) != testCase.json {
+			t.Errorf("[Test case %d], expected %s, got %s", idx, testCase.json, string(bytes))
+		}
+
+		var feed Feed
+		err = json.Unmarshal(bytes, &feed)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !reflect.DeepEqual(feed, testCase.feed) {
+			t.Errorf("[Test case %d], expected %+v, got %+v", idx, testCase.feed, feed)
+		}
+	}
+}
+
+func Test_Load_formats(t *testing.T) {
+	files := map[string]string{
+		"a.json": `[{"owner": "wsj", "channels": ["http://www.wsj.com/xml/rss/3_7085.xml"]}]`,
+		"b.yaml": "- owner: wsj\n  channels:\n  - http://www.wsj.com/xml/rss/3_7014.xml\n",
+		"c.yml":  "- owner: cnet\n  channels: [http://www.cnet.com/rss/iphone-update/]\n",
+		"d.toml": "[[groups]]\nowner = \"cnet\"\nchannels = [\"http://www.cnet.com/rss/android-update/\"]\n",
+		"e.txt":  "not a channels file",
+	}
+
+	expected := ChannelGroups{
+		ChannelGroup{
+			Owner: "cnet",
+			Channels: Feeds{
+				Feed{Url: "http://www.cnet.com/rss/android-update/"},
+				Feed{Url: "http://www.cnet.com/rss/iphone-update/"},
+			},
+		},
+		ChannelGroup{
+			Owner: "wsj",
+			Channels: Feeds{
+				Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+				Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml"},
+			},
+		},
+	}
+
+	// create temp dir
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	loader, err := NewLoader()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// under test
+	err = loader.Load(dir)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// assert
+	if !reflect.DeepEqual(loader.ChannelGroups, expected) {
+		t.Errorf("expected %+v, got %+v", expected, loader.ChannelGroups)
+	}
+}
+
+func Test_Feed_merge_headers(t *testing.T) {
+	shared := map[string]string{"X-A": "1"}
+	feed := Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml", Headers: shared}
+	feed.merge(Feed{Url: feed.Url, Headers: map[string]string{"X-A": "2", "X-B": "3"}})
+
+	if !reflect.DeepEqual(feed.Headers, map[string]string{"X-A": "1", "X-B": "3"}) {
+		t.Errorf("Expected the headers united, found %v", feed.Headers)
+	}
+	if !reflect.DeepEqual(shared, map[string]string{"X-A": "1"}) {
+		t.Errorf("Expected the shared headers left as is, found %v", shared)
+	}
+}
+
+func Test_Load_filters(t *testing.T) {
+	testCases := []struct {
+		filter string
+		err    bool
+	}{
+		{`{"field": "title", "match": "^Opinion"}`, false},
+		{`{"field": "title", "match": "(Opinion"}`, true},
+		{`{"field": "author", "match": "any"}`, true},
+	}
+
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for idx, testCase := range testCases {
+		path := filepath.Join(dir, "channels.json")
+		content := `[{"owner": "wsj", "channels": [{"url": "http://www.wsj.com/xml/rss/3_7085.xml", "filters": [` + testCase.filter + `]}]}]`
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Error(err)
+		}
+
+		loader, _ := NewLoader()
+		if err := loader.Load(path); (err != nil) != testCase.err {
+			t.Errorf("[Test case %d] Expected an error %v, found %v", idx, testCase.err, err)
+		}
+	}
+}
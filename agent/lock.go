@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// how long to wait for a lock by default, fail right away if 0
+const DefaultLockTimeout = 30 * time.Second
+
+// locks of other hosts older than this are taken over, their holder is assumed to be stuck
+// the holders on this host are taken over once gone only
+const DefaultStaleAfter = 10 * time.Minute
+
+// how often a held lock is checked while waiting
+const lockPoll = 100 * time.Millisecond
+
+// who holds a lock, as written in the lock file
+type lockInfo struct {
+	Pid   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Since time.Time `json:"since"`
+}
+
+// the error of a lock held by another process
+type LockError struct {
+	Path   string
+	Holder lockInfo
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("[ERR] '%s' is locked by pid %d on '%s' since %s", e.Path, e.Holder.Pid, e.Holder.Host, e.Holder.Since.Format(time.RFC3339))
+}
+
+// an advisory lock, a file created exclusively and removed on release
+// locks whose process is gone are taken over, as are the ones of other hosts older than staleAfter
+// the lock is waited for up to timeout
+// returns the function releasing the lock, which leaves alone a lock taken over meanwhile
+func acquireLock(path string, timeout, staleAfter time.Duration) (func(), error) {
+	host, _ := os.Hostname()
+	deadline := time.Now().Add(timeout)
+
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+		if err == nil {
+			info := lockInfo{Pid: os.Getpid(), Host: host, Since: time.Now()}
+			err = json.NewEncoder(file).Encode(info)
+			file.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("[ERR] Unable to write to '%s': %v", path, err)
+			}
+			return func() { releaseLock(path, info) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("[ERR] Unable to lock '%s': %v", path, err)
+		}
+
+		holder, stale := staleLock(path, host, staleAfter)
+		if stale && takeOver(path, holder) {
+			logf("[INF] Took over the stale lock '%s' of pid %d on '%s'\n", path, holder.Pid, holder.Host)
+			continue
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, &LockError{Path: path, Holder: holder}
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// move a stale lock aside, so that it's gone for a single taker
+// the lock moved is checked to be the one judged stale, a lock created meanwhile is put back
+func takeOver(path string, stale lockInfo) bool {
+	aside := fmt.Sprintf("%s.stale.%d.%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); err != nil {
+		return false // released, or taken over by another
+	}
+
+	moved, err := readLock(aside)
+	if (stale.Pid == 0 && err != nil) || // judged by its age, still unreadable
+		(err == nil && moved.Pid == stale.Pid && moved.Host == stale.Host && moved.Since.Equal(stale.Since)) {
+		os.Remove(aside)
+		return true
+	}
+	putBack(path, aside)
+	return false
+}
+
+// put back a lock moved aside by mistake
+// fails if yet another lock was created meanwhile, which wins
+// the lock moved is then left aside, its holder finds out on release that it lost it
+func putBack(path, aside string) bool {
+	if err := os.Link(aside, path); err != nil {
+		logf("[ERR] Unable to put back the lock '%s', left as '%s': %v\n", path, aside, err)
+		return false
+	}
+	os.Remove(aside)
+	return true
+}
+
+// remove a lock, if it's still the one acquired
+func releaseLock(path string, info lockInfo) {
+	holder, err := readLock(path)
+	if err != nil || holder.Pid != info.Pid || holder.Host != info.Host || !holder.Since.Equal(info.Since) {
+		logf("[ERR] Lost the lock '%s', left as is\n", path)
+		return
+	}
+	os.Remove(path)
+}
+
+// who holds a lock
+func readLock(path string) (lockInfo, error) {
+	var info lockInfo
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &info)
+	}
+	return info, err
+}
+
+// whether the holder of a lock is gone, or has held it for too long
+// the holders alive on this host are never stale, the ones of other hosts are judged by the age of the lock
+// lock files that can't be read, e.g. still being written, are judged by their age
+func staleLock(path, host string, staleAfter time.Duration) (lockInfo, bool) {
+	info, err := readLock(path)
+	if err != nil {
+		stat, statErr := os.Stat(path)
+		if statErr != nil {
+			return info, os.IsNotExist(statErr) // released meanwhile
+		}
+		info.Since = stat.ModTime()
+		return info, time.Since(info.Since) > staleAfter
+	}
+
+	if info.Host == host {
+		return info, !processAlive(info.Pid)
+	}
+	return info, time.Since(info.Since) > staleAfter
+}
+
+// whether a process runs on this host
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// stores whose writers must not overlap, e.g. processes started by cron
+// writers of a single day take its lock, writers of many days take the lock of the store first
+type LockingStore interface {
+	Store
+	Lock() (func(), error)
+	LockDay(date string) (func(), error)
+}
+
+// lock a store, if it's a locking one
+func lockStore(s Store) (func(), error) {
+	if locking, ok := s.(LockingStore); ok {
+		return locking.Lock()
+	}
+	return func() {}, nil
+}
+
+// lock a day of a store, if it's a locking one
+func lockDay(s Store, date string) (func(), error) {
+	if locking, ok := s.(LockingStore); ok {
+		return locking.LockDay(date)
+	}
+	return func() {}, nil
+}
+
+// the lock of the whole items dir
+func (s *DirStore) Lock() (func(), error) {
+	return acquireLock(filepath.Join(s.lockDir, ".lock"), s.LockTimeout, s.StaleAfter)
+}
+
+// the lock of a day file
+func (s *DirStore) LockDay(date string) (func(), error) {
+	return acquireLock(filepath.Join(s.lockDir, "."+date+".lock"), s.LockTimeout, s.StaleAfter)
+}
@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_acquireLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host, _ := os.Hostname()
+	path := filepath.Join(dir, ".lock")
+
+	testCases := []struct {
+		holder  *lockInfo // nil if no lock file
+		content string    // written when no holder, e.g. a lock file being written
+		age     time.Duration
+		locked  bool
+	}{
+		{nil, "", 0, false},
+		{&lockInfo{Pid: os.Getpid(), Host: host, Since: time.Now()}, "", 0, true},
+		{&lockInfo{Pid: 1 << 30, Host: host, Since: time.Now()}, "", 0, false},                        // process gone
+		{&lockInfo{Pid: 1 << 30, Host: "elsewhere", Since: time.Now()}, "", 0, true},                  // can't tell from here
+		{&lockInfo{Pid: os.Getpid(), Host: host, Since: time.Now().Add(-time.Hour)}, "", 0, true},     // alive, e.g. a long save
+		{&lockInfo{Pid: 1 << 30, Host: "elsewhere", Since: time.Now().Add(-time.Hour)}, "", 0, false}, // stuck
+		{nil, `{"pid":`, 0, true},
+		{nil, `{"pid":`, time.Hour, false},
+	}
+
+	for idx, testCase := range testCases {
+		os.Remove(path)
+		if testCase.holder != nil {
+			data, _ := json.Marshal(testCase.holder)
+			ioutil.WriteFile(path, data, 0666)
+		} else if testCase.content != "" {
+			ioutil.WriteFile(path, []byte(testCase.content), 0666)
+			then := time.Now().Add(-testCase.age)
+			os.Chtimes(path, then, then)
+		}
+
+		unlock, err := acquireLock(path, 0, 10*time.Minute)
+		if testCase.locked {
+			if _, ok := err.(*LockError); !ok {
+				t.Errorf("[Test case %d] Expected a lock error, found %v", idx, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[Test case %d] %v", idx, err)
+			continue
+		}
+
+		var info lockInfo
+		data, _ := ioutil.ReadFile(path)
+		json.Unmarshal(data, &info)
+		if info.Pid != os.Getpid() || info.Host != host {
+			t.Errorf("[Test case %d] Expected the lock held by this process, found %+v", idx, info)
+		}
+
+		unlock()
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("[Test case %d] Expected the lock file removed", idx)
+		}
+	}
+}
+
+func Test_acquireLock_takenOver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".lock")
+	stale := lockInfo{Pid: 1 << 30, Host: "elsewhere", Since: time.Now().Add(-time.Hour)}
+	fresh := lockInfo{Pid: 1 << 30, Host: "elsewhere", Since: time.Now()}
+	write := func(info lockInfo) {
+		data, _ := json.Marshal(info)
+		ioutil.WriteFile(path, data, 0666)
+	}
+
+	// the lock judged stale was replaced meanwhile, e.g. by another taker
+	write(fresh)
+	if takeOver(path, stale) {
+		t.Errorf("Expected a lock created meanwhile not taken over")
+	}
+	if holder, _ := readLock(path); !holder.Since.Equal(fresh.Since) {
+		t.Errorf("Expected the lock created meanwhile put back, found %+v", holder)
+	}
+
+	// a lock created while the one moved aside is put back wins, the one moved is left aside
+	aside := path + ".aside"
+	os.Rename(path, aside)
+	write(stale)
+	if putBack(path, aside) {
+		t.Errorf("Expected the lock moved aside not put back over another")
+	}
+	if holder, _ := readLock(path); !holder.Since.Equal(stale.Since) {
+		t.Errorf("Expected the lock created meanwhile kept, found %+v", holder)
+	}
+	if holder, _ := readLock(aside); !holder.Since.Equal(fresh.Since) {
+		t.Errorf("Expected the lock moved left aside, found %+v", holder)
+	}
+	os.Remove(aside)
+	write(fresh)
+
+	// the holder taken over doesn't release the lock of the taker
+	unlock, err := acquireLock(path, 0, time.Minute)
+	if _, ok := err.(*LockError); !ok {
+		t.Fatalf("Expected a lock error, found %v", err)
+	}
+	os.Remove(path)
+	unlock, err = acquireLock(path, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(fresh)
+	unlock()
+	if holder, _ := readLock(path); !holder.Since.Equal(fresh.Since) {
+		t.Errorf("Expected the lock of the taker left, found %+v", holder)
+	}
+
+	// only a taker of the stale lock gets it
+	write(stale)
+	takers := 8
+	taken := make(chan bool, takers)
+	var wg sync.WaitGroup
+	for idx := 0; idx < takers; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := acquireLock(path, 0, time.Minute)
+			taken <- err == nil
+			if err == nil {
+				time.Sleep(2 * lockPoll) // held while the others try
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(taken)
+	count := 0
+	for ok := range taken {
+		if ok {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected a single taker, found %d", count)
+	}
+}
+
+func Test_acquireLock_wait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".lock")
+	unlock, err := acquireLock(path, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// given up on
+	start := time.Now()
+	_, err = acquireLock(path, 3*lockPoll, time.Minute)
+	if _, ok := err.(*LockError); !ok || time.Since(start) < 3*lockPoll {
+		t.Errorf("Expected a lock error once waited for, found %v after %v", err, time.Since(start))
+	}
+
+	// released meanwhile
+	go func(release func()) {
+		time.Sleep(2 * lockPoll)
+		release()
+	}(unlock)
+	unlock, err = acquireLock(path, time.Minute, time.Minute)
+	if err != nil {
+		t.Errorf("Expected the lock once released, found %v", err)
+	} else {
+		unlock()
+	}
+}
+
+func Test_Marshaller_Save_overlapping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// runs saving the same day at once, each its own items
+	runs := 8
+	var wg sync.WaitGroup
+	for idx := 0; idx < runs; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+
+			marshaller, _ := NewMarshaller(dir)
+			marshaller.ReArrange(Channels{
+				&Channel{Owner: "wsj", Title: "World", Items: &Items{
+					&Item{Title: fmt.Sprint(idx), Date: "Mon, 25 Apr 2016 10:00:00 +0000"},
+				}},
+			})
+			if err := marshaller.Save(); err != nil {
+				t.Error(err)
+			}
+		}(idx)
+	}
+	wg.Wait()
+
+	day, err := NewDirStore(dir).LoadDay("2016-04-25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, item := range *(*(*day.Owners)[0].Channels)[0].Items {
+		titles = append(titles, item.Title)
+	}
+	expected := []string{"0", "1", "2", "3", "4", "5", "6", "7"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected the items of every run, found %v", titles)
+	}
+
+	// nothing left locked
+	if names, _ := filepath.Glob(filepath.Join(dir, "*.lock")); len(names) != 0 {
+		t.Errorf("Expected no lock left, found %v", names)
+	}
+}
@@ -0,0 +1,269 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marouenj/rss/util"
+)
+
+// the version of the schema of the days, stamped into each day saved
+// bump it along with a migration from the previous version
+const SchemaVersion = 1
+
+// a migration of a day, as decoded json, from a version of the schema to the next
+type Migration struct {
+	From  int
+	Desc  string
+	Apply func(day map[string]interface{}) error
+}
+
+// the migrations, by version migrated from
+// the days with no version were written before versions were stamped, version 0
+var migrations = []Migration{
+	{0, "lists left null are empty", nullsToEmpty},
+}
+
+// the problem of the days written by a newer version of the schema
+const newerSchema = "written by a newer schema"
+
+// a day along with the version of its schema, as saved
+type stampedDay struct {
+	Version int `json:"version"`
+	*Day
+}
+
+// marshal a day, stamped with the current version of the schema
+func encodeDay(day *Day) ([]byte, error) {
+	return json.Marshal(stampedDay{SchemaVersion, day})
+}
+
+// a day as indented json, ending with a newline, so that day files kept in git diff item by item
+// keys are in the order of the fields, as with encodeDay
+func indentDay(day *Day) ([]byte, error) {
+	data, err := json.MarshalIndent(stampedDay{SchemaVersion, day}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// the version of the schema of a day, 0 if not stamped
+func dayVersion(data []byte) (int, error) {
+	var stamp struct {
+		Version int `json:"version"`
+	}
+	err := json.Unmarshal(data, &stamp)
+	return stamp.Version, err
+}
+
+// upgrade a day from a version of the schema to the current one
+func migrateDay(data []byte, version int) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var day map[string]interface{}
+	if err := dec.Decode(&day); err != nil {
+		return nil, err
+	}
+
+	for v := version; v < SchemaVersion; v++ {
+		if err := migrations[v].Apply(day); err != nil {
+			return nil, fmt.Errorf("Unable to migrate from version %d, %s: %v", v, migrations[v].Desc, err)
+		}
+	}
+
+	day["version"] = SchemaVersion
+	return json.Marshal(day)
+}
+
+// version 0 to 1
+// owners, channels and items left null by older versions are empty lists
+func nullsToEmpty(day map[string]interface{}) error {
+	empty := func(object interface{}, key string) []interface{} {
+		fields, ok := object.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if value, ok := fields[key]; ok && value == nil {
+			fields[key] = []interface{}{}
+		}
+		list, _ := fields[key].([]interface{})
+		return list
+	}
+
+	for _, owner := range empty(day, "owners") {
+		for _, channel := range empty(owner, "channels") {
+			empty(channel, "items")
+		}
+	}
+	return nil
+}
+
+// a file upgraded to the current version of the schema
+type MigratedFile struct {
+	Path string `json:"path"`
+	From int    `json:"from"` // the oldest version of its days
+}
+
+// what migrating the items dir did, or would do
+type MigrationReport struct {
+	Migrated []MigratedFile `json:"migrated"`
+	UpToDate int            `json:"up_to_date"`
+	Newer    []string       `json:"newer"` // files written by a newer schema, left as is
+}
+
+// upgrade the day files and the archives to the current version of the schema
+// the previous versions are backed up, as when saving
+// with dryRun, nothing is written
+func (s *DirStore) Migrate(dryRun bool) (*MigrationReport, error) {
+	if !dryRun {
+		unlock, err := s.Lock()
+		if err != nil {
+			return nil, err // already formatted
+		}
+		defer unlock()
+	}
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) { // nothing persisted yet
+		entries, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to list '%s': %v", s.dir, err)
+	}
+
+	report := &MigrationReport{Migrated: []MigratedFile{}, Newer: []string{}}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+
+		var versions []int
+		var err error
+		if date := dayFileDate(entry.Name()); date != "" {
+			versions, err = s.migrateDayFile(path, dryRun)
+		} else if month := archiveMonth(entry.Name()); month != "" {
+			versions, err = s.migrateArchive(path, month, dryRun)
+		} else {
+			continue
+		}
+		if err != nil {
+			return report, err // already formatted
+		}
+
+		oldest, newer := SchemaVersion, false
+		for _, version := range versions {
+			if version < oldest {
+				oldest = version
+			}
+			newer = newer || version > SchemaVersion
+		}
+		switch {
+		case newer:
+			report.Newer = append(report.Newer, path)
+		case oldest < SchemaVersion:
+			report.Migrated = append(report.Migrated, MigratedFile{Path: path, From: oldest})
+		default:
+			report.UpToDate++
+		}
+	}
+
+	return report, nil
+}
+
+// read a file, decompressed
+func readDayFile(path string) ([]byte, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+	}
+	data, err := decompressDay(path, file)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to decompress '%s': %v", path, err)
+	}
+	return data, nil
+}
+
+// upgrade a day file, keeping its compression
+// returns the version it had
+func (s *DirStore) migrateDayFile(path string, dryRun bool) ([]int, error) {
+	data, err := readDayFile(path)
+	if err != nil {
+		return nil, err // already formatted
+	}
+	version, err := dayVersion(data)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to migrate '%s': %v", path, err)
+	}
+	if version >= SchemaVersion || dryRun {
+		return []int{version}, nil
+	}
+
+	day, problem := parseDay(data)
+	if problem != "" {
+		return nil, fmt.Errorf("[ERR] Unable to migrate '%s': %s", path, problem)
+	}
+	return []int{version}, s.saveDayAs(day, compressionOf(path))
+}
+
+// upgrade the days of an archive, backed up first
+// returns the versions they had
+func (s *DirStore) migrateArchive(path, month string, dryRun bool) ([]int, error) {
+	archivedDays, err := s.readArchive(path)
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	versions := []int{}
+	days := map[string][]byte{}
+	changed := false
+	for date, data := range archivedDays {
+		days[date] = data
+
+		version, err := dayVersion(data)
+		if err != nil {
+			return nil, fmt.Errorf("[ERR] Unable to migrate '%s' of '%s': %v", date, path, err)
+		}
+		versions = append(versions, version)
+		if version >= SchemaVersion {
+			continue
+		}
+
+		day, problem := parseDay(data)
+		if problem != "" {
+			return nil, fmt.Errorf("[ERR] Unable to migrate '%s' of '%s': %s", date, path, problem)
+		}
+		if days[date], err = encodeDay(day); err != nil {
+			return nil, fmt.Errorf("[ERR] Unable to marshal: %v", err)
+		}
+		changed = true
+	}
+	if !changed || dryRun {
+		return versions, nil
+	}
+
+	previous, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+	}
+	if err := util.WriteFileAtomic(path+backupSuffix, previous, 0666); err != nil {
+		return nil, err // already formatted
+	}
+	return versions, s.writeArchive(month, days)
+}
+
+// the compression of a file, as told by its suffix, none if empty
+func compressionOf(path string) string {
+	for name, suffix := range compressions {
+		if strings.HasSuffix(path, suffix) {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_parseDay_migrate(t *testing.T) {
+	testCases := []struct {
+		data    string
+		day     *Day
+		problem string
+	}{
+		{
+			`{"version":1,"date":"2016-04-24","owners":[]}`,
+			&Day{Date: "2016-04-24", Owners: &Owners{}},
+			"",
+		},
+		{ // written before versions were stamped, with null lists
+			`{"date":"2016-04-24","owners":[{"id":"wsj","channels":[{"title":"World","desc":"","items":null}]}]}`,
+			&Day{Date: "2016-04-24", Owners: &Owners{&Owner{Id: "wsj", Channels: &Channels{&Channel{Title: "World", Items: &Items{}}}}}},
+			"",
+		},
+		{
+			`{"date":"2016-04-24","owners":null}`,
+			&Day{Date: "2016-04-24", Owners: &Owners{}},
+			"",
+		},
+		{
+			`{"version":2,"date":"2016-04-24","owners":[]}`,
+			nil,
+			"written by a newer schema, version 2",
+		},
+		{
+			`{"version":"1","date":"2016-04-24","owners":[]}`,
+			nil,
+			"unparsable, json: cannot unmarshal string into Go struct field .version of type int",
+		},
+	}
+
+	for idx, testCase := range testCases {
+		day, problem := parseDay([]byte(testCase.data))
+		if problem != testCase.problem {
+			t.Errorf("[Test case %d] Expected the problem '%s', found '%s'", idx, testCase.problem, problem)
+		}
+		if !reflect.DeepEqual(day, testCase.day) {
+			t.Errorf("[Test case %d] Expected %+v, found %+v", idx, testCase.day, day)
+		}
+	}
+}
+
+func Test_DirStore_Migrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := `{"date":"%s","owners":[{"id":"wsj","channels":[{"title":"World","desc":"","items":null}]}]}`
+	store := NewDirStore(dir)
+	for _, day := range []Day{dayOf("2016-03-01", "wsj", "World", "a"), dayOf("2016-04-25", "wsj", "World", "b")} {
+		store.SaveDay(&day)
+	}
+	store.Archive("2016-04-01", nil) // up to date, archived
+	ioutil.WriteFile(filepath.Join(dir, "2016-04-24"), []byte(`{"date":"2016-04-24","owners":null}`), 0666)
+	ioutil.WriteFile(filepath.Join(dir, "2016-04-26"), []byte(`{"version":2,"date":"2016-04-26","owners":[]}`), 0666)
+	gzipped, _ := compressDay("gzip", []byte(`{"date":"2016-04-23","owners":[]}`))
+	ioutil.WriteFile(filepath.Join(dir, "2016-04-23.json.gz"), gzipped, 0666)
+	archive, _ := compressDay("gzip", []byte("["+fmt.Sprintf(old, "2016-02-01")+"]"))
+	ioutil.WriteFile(filepath.Join(dir, "2016-02.json.gz"), archive, 0666)
+
+	expected := &MigrationReport{
+		Migrated: []MigratedFile{
+			{filepath.Join(dir, "2016-02.json.gz"), 0},
+			{filepath.Join(dir, "2016-04-23.json.gz"), 0},
+			{filepath.Join(dir, "2016-04-24"), 0},
+		},
+		UpToDate: 2,
+		Newer:    []string{filepath.Join(dir, "2016-04-26")},
+	}
+
+	// dry run, nothing is written
+	report, err := store.Migrate(true)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, report)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*"+backupSuffix)); len(names) != 0 {
+		t.Errorf("Expected nothing written on a dry run, found %v", names)
+	}
+
+	report, err = store.Migrate(false)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, report)
+	}
+
+	// stamped in place, the previous versions backed up
+	for _, name := range []string{"2016-04-23.json.gz", "2016-04-24"} {
+		data, err := readDayFile(filepath.Join(dir, name))
+		if version, _ := dayVersion(data); err != nil || version != SchemaVersion {
+			t.Errorf("Expected '%s' stamped, found version %d, %v", name, version, err)
+		}
+	}
+	for _, name := range []string{"2016-02.json.gz", "2016-04-23", "2016-04-24"} {
+		if _, err := os.Stat(filepath.Join(dir, name+backupSuffix)); err != nil {
+			t.Errorf("Expected '%s' backed up, found %v", name, err)
+		}
+	}
+	day, err := store.LoadDay("2016-02-01")
+	if err != nil || day == nil || *(*(*day.Owners)[0].Channels)[0].Items == nil {
+		t.Errorf("Expected the archived day migrated, found %+v, %v", day, err)
+	}
+
+	// nothing left to migrate
+	report, _ = store.Migrate(false)
+	if len(report.Migrated) != 0 || report.UpToDate != 5 {
+		t.Errorf("Expected every file up to date, found %+v", report)
+	}
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/marouenj/rss/util"
+)
+
+// a feed that moved for good, or is gone
+type Move struct {
+	Owner string    `json:"owner"`
+	Url   string    `json:"url"`
+	To    string    `json:"to,omitempty"` // where the permanent redirects of the url lead
+	Gone  bool      `json:"gone,omitempty"`
+	Since time.Time `json:"since"` // first crawl it was seen
+}
+
+// the feeds that moved or are gone, as recorded by the crawls, persisted between runs
+type Moves struct {
+	Feeds map[string]*Move `json:"feeds"` // by key
+	path  string           // file to load from/save to
+}
+
+// init the moves, restoring the ones saved to path if any
+func NewMoves(path string) (*Moves, error) {
+	m := &Moves{
+		Feeds: map[string]*Move{},
+		path:  path,
+	}
+
+	file, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) { // nothing recorded yet
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+	}
+
+	err = json.Unmarshal(file, m)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to unmarshal '%s': %v", path, err)
+	}
+	if m.Feeds == nil {
+		m.Feeds = map[string]*Move{}
+	}
+
+	return m, nil
+}
+
+// persist the moves, the previous state is replaced at once
+func (m *Moves) Save() error {
+	bytes, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to marshal: %v", err)
+	}
+
+	return util.WriteFileAtomic(m.path, bytes, 0666)
+}
+
+// record the feeds of a report that moved or are gone
+// feeds fetched fine with no redirect are forgotten, failures are not telling either way
+func (m *Moves) Record(report *Report, now time.Time) {
+	for _, rep := range report.Feeds {
+		key := Redact(feedKey(rep.Owner, rep.Url))
+		if rep.MovedTo == "" && !rep.Gone {
+			if rep.Error == "" {
+				delete(m.Feeds, key)
+			}
+			continue
+		}
+
+		if move, ok := m.Feeds[key]; ok && move.To == Redact(rep.MovedTo) && move.Gone == rep.Gone {
+			continue // already known
+		}
+		// urls holding secrets are persisted redacted
+		m.Feeds[key] = &Move{
+			Owner: rep.Owner,
+			Url:   Redact(rep.Url),
+			To:    Redact(rep.MovedTo),
+			Gone:  rep.Gone,
+			Since: now,
+		}
+	}
+}
+
+// the moves, by owner then url
+func (m *Moves) List() []*Move {
+	keys := []string{}
+	for key, _ := range m.Feeds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := []*Move{}
+	for _, key := range keys {
+		list = append(list, m.Feeds[key])
+	}
+	return list
+}
+
+// forget about a move, once the channels are fixed
+func (m *Moves) Forget(owner, url string) {
+	delete(m.Feeds, feedKey(owner, url))
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Moves_Record(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "moves.json")
+
+	first := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	moves, _ := NewMoves(path)
+	moves.Record(&Report{Feeds: map[string]*FeedReport{
+		"a http://a": {Owner: "a", Url: "http://a", MovedTo: "http://b"},
+		"a http://c": {Owner: "a", Url: "http://c", Gone: true, Error: "gone"},
+		"a http://d": {Owner: "a", Url: "http://d", Items: 1},
+	}}, first)
+	if err := moves.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// restored, then updated by a later crawl
+	moves, err = NewMoves(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves.Record(&Report{Feeds: map[string]*FeedReport{
+		"a http://a": {Owner: "a", Url: "http://a", MovedTo: "http://b"}, // still moved
+		"a http://c": {Owner: "a", Url: "http://c", Error: "timeout"},    // failing, not telling
+	}}, second)
+
+	if len(moves.Feeds) != 2 {
+		t.Fatalf("Expected 2 moves, found '%v'", moves.Feeds)
+	}
+	if move := moves.Feeds["a http://a"]; move.To != "http://b" || !move.Since.Equal(first) {
+		t.Errorf("Expected the move to be kept since the first crawl, found '%+v'", move)
+	}
+	if move := moves.Feeds["a http://c"]; !move.Gone {
+		t.Errorf("Expected the gone feed to be kept, found '%+v'", move)
+	}
+
+	// fetched fine, forgotten
+	moves.Record(&Report{Feeds: map[string]*FeedReport{
+		"a http://c": {Owner: "a", Url: "http://c", Items: 3},
+	}}, second)
+	if _, ok := moves.Feeds["a http://c"]; ok {
+		t.Errorf("Expected the feed to be forgotten")
+	}
+}
@@ -0,0 +1,319 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the days split per owner, a dir of <date>.json files per owner
+// so that an owner can be given access to its items only
+// the locks are shared by the owners, in the top dir
+type OwnerStore struct {
+	dir         string
+	Compression string        // of the day files written, gzip or zstd, none if empty
+	Pretty      bool          // whether the day files are indented, one item per block, for diffs
+	LockTimeout time.Duration // how long to wait for a lock held by another process
+	StaleAfter  time.Duration // locks held longer are taken over
+}
+
+func NewOwnerStore(dir string) *OwnerStore {
+	return &OwnerStore{
+		dir:         dir,
+		LockTimeout: DefaultLockTimeout,
+		StaleAfter:  DefaultStaleAfter,
+	}
+}
+
+// the dir of the owner with an empty id, a name that no other id escapes to
+const emptyOwnerDir = "%"
+
+// the name of the dir of an owner, escaped so that any id makes a single, visible dir of its own
+// a leading dot is escaped, which the escaping of the rest never gives
+func ownerDirName(owner string) string {
+	if owner == "" {
+		return emptyOwnerDir
+	}
+	name := url.PathEscape(owner)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// the store of the days of an owner
+func (s *OwnerStore) Owner(owner string) *DirStore {
+	store := NewDirStore(filepath.Join(s.dir, ownerDirName(owner)))
+	store.lockDir = s.dir
+	store.JsonSuffix = true
+	store.Compression = s.Compression
+	store.Pretty = s.Pretty
+	store.LockTimeout = s.LockTimeout
+	store.StaleAfter = s.StaleAfter
+	return store
+}
+
+// the store of an owner, its dir created if missing
+func (s *OwnerStore) create(owner string) (*DirStore, error) {
+	store := s.Owner(owner)
+	if err := os.MkdirAll(store.dir, os.ModeDir|os.ModePerm); err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to create dir '%s': %v", store.dir, err)
+	}
+	return store, nil
+}
+
+// the owners having a dir, in order
+func (s *OwnerStore) Owners() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) { // nothing persisted yet
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to list '%s': %v", s.dir, err)
+	}
+
+	owners := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if entry.Name() == emptyOwnerDir {
+			owners = append(owners, "")
+			continue
+		}
+		owner, err := url.PathUnescape(entry.Name())
+		if err != nil || ownerDirName(owner) != entry.Name() { // not the dir of an owner
+			continue
+		}
+		owners = append(owners, owner)
+	}
+
+	sort.Strings(owners)
+	return owners, nil
+}
+
+// the days of the owners, put together
+func (s *OwnerStore) LoadDay(date string) (*Day, error) {
+	owners, err := s.Owners()
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	var day *Day
+	for _, owner := range owners {
+		ownerDay, err := s.Owner(owner).LoadDay(date)
+		if err != nil {
+			return nil, err // already formatted
+		}
+		if ownerDay == nil || ownerDay.Owners == nil {
+			continue
+		}
+
+		if day == nil {
+			day = &Day{Date: date, Owners: &Owners{}}
+		}
+		*day.Owners = append(*day.Owners, *ownerDay.Owners...)
+	}
+
+	if day != nil {
+		sort.Sort(*day.Owners)
+	}
+	return day, nil
+}
+
+// each owner of the day is saved to its dir
+// the owners left out of the day lose their day
+func (s *OwnerStore) SaveDay(day *Day) error {
+	kept := map[string]bool{}
+	if day.Owners != nil {
+		for _, owner := range *day.Owners {
+			store, err := s.create(owner.Id)
+			if err != nil {
+				return err // already formatted
+			}
+			if err := store.SaveDay(&Day{Date: day.Date, Owners: &Owners{owner}}); err != nil {
+				return err // already formatted
+			}
+			kept[owner.Id] = true
+		}
+	}
+
+	owners, err := s.Owners()
+	if err != nil {
+		return err // already formatted
+	}
+	for _, owner := range owners {
+		store := s.Owner(owner)
+		if kept[owner] || !hasDay(store, day.Date) {
+			continue
+		}
+		if err := store.RemoveDay(day.Date); err != nil {
+			return err // already formatted
+		}
+	}
+
+	return nil
+}
+
+func (s *OwnerStore) RemoveDay(date string) error {
+	owners, err := s.Owners()
+	if err != nil {
+		return err // already formatted
+	}
+
+	removed := false
+	for _, owner := range owners {
+		store := s.Owner(owner)
+		if !hasDay(store, date) {
+			continue
+		}
+		if err := store.RemoveDay(date); err != nil {
+			return err // already formatted
+		}
+		removed = true
+	}
+
+	if !removed {
+		return fmt.Errorf("[ERR] Unable to remove '%s' from '%s': no such day", date, s.dir)
+	}
+	return nil
+}
+
+// whether an owner has a day, as a day file or archived
+// only the files of the day and of its month are looked at
+func hasDay(store *DirStore, date string) bool {
+	if store.dayFile(date) != "" {
+		return true
+	}
+	if len(date) < len(monthLayout) {
+		return false
+	}
+	path := store.archiveFile(date[:len(monthLayout)])
+	if path == "" {
+		return false
+	}
+	days, err := store.readArchive(path)
+	return err == nil && days[date] != nil
+}
+
+// the dates of the days of any owner
+func (s *OwnerStore) Dates() ([]string, error) {
+	stores, err := s.Stores()
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	found := map[string]bool{}
+	for _, store := range stores {
+		dates, err := store.Dates()
+		if err != nil {
+			return nil, err // already formatted
+		}
+		for _, date := range dates {
+			found[date] = true
+		}
+	}
+
+	dates := []string{}
+	for date, _ := range found {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	return dates, nil
+}
+
+func (s *OwnerStore) Items(since, until string, fn ItemFunc) error {
+	return eachItem(s, since, until, fn)
+}
+
+// only the dir of the owner is read
+func (s *OwnerStore) OwnerItems(owner, since, until string, fn ItemFunc) error {
+	return s.Owner(owner).Items(since, until, fn)
+}
+
+func (s *OwnerStore) ChannelItems(channel, since, until string, fn ItemFunc) error {
+	return s.Items(since, until, fn)
+}
+
+// the stores of the owners, in order
+func (s *OwnerStore) Stores() ([]*DirStore, error) {
+	owners, err := s.Owners()
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	stores := []*DirStore{}
+	for _, owner := range owners {
+		stores = append(stores, s.Owner(owner))
+	}
+	return stores, nil
+}
+
+func (s *OwnerStore) Lock() (func(), error) {
+	return acquireLock(filepath.Join(s.dir, ".lock"), s.LockTimeout, s.StaleAfter)
+}
+
+func (s *OwnerStore) LockDay(date string) (func(), error) {
+	return acquireLock(filepath.Join(s.dir, "."+date+".lock"), s.LockTimeout, s.StaleAfter)
+}
+
+// the day files of the owners that can't be loaded
+func (s *OwnerStore) Check() ([]DayProblem, error) {
+	stores, err := s.Stores()
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	problems := []DayProblem{}
+	for _, store := range stores {
+		found, err := store.Check()
+		if err != nil {
+			return nil, err // already formatted
+		}
+		problems = append(problems, found...)
+	}
+	return problems, nil
+}
+
+// set aside the day files of a date that can't be loaded
+func (s *OwnerStore) Quarantine(date string) (string, error) {
+	stores, err := s.Stores()
+	if err != nil {
+		return "", err // already formatted
+	}
+
+	aside := []string{}
+	for _, store := range stores {
+		if _, err := store.LoadDay(date); err == nil {
+			continue
+		}
+		path, err := store.Quarantine(date)
+		if err != nil {
+			return "", err // already formatted
+		}
+		aside = append(aside, path)
+	}
+	if len(aside) == 0 {
+		return "", fmt.Errorf("[ERR] Unable to set '%s' aside: no day file that can't be loaded", date)
+	}
+	return strings.Join(aside, "', '"), nil
+}
+
+// split the days of a store per owner
+// fn, if set, is called once each day is split
+func SplitOwners(from Store, to *OwnerStore, fn func(date string, owners int)) error {
+	return CopyDays(from, to, func(date string, items int) {
+		if fn == nil {
+			return
+		}
+		day, err := to.LoadDay(date)
+		if err == nil && day != nil {
+			fn(date, len(*day.Owners))
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_ownerDirName(t *testing.T) {
+	testCases := []struct {
+		owner string
+		name  string
+	}{
+		{"wsj", "wsj"},
+		{"a/b", "a%2Fb"},
+		{"..", "%2E."},
+		{".", "%2E"},
+		{".hidden", "%2Ehidden"},
+		{"%2E", "%252E"},
+		{"", "%"},
+	}
+
+	store := NewOwnerStore("")
+	names := map[string]string{}
+	for idx, testCase := range testCases {
+		if owner, found := names[ownerDirName(testCase.owner)]; found {
+			t.Errorf("[Test case %d] Expected a dir of its own, found the one of '%s'", idx, owner)
+		}
+		names[ownerDirName(testCase.owner)] = testCase.owner
+		if name := ownerDirName(testCase.owner); name != testCase.name {
+			t.Errorf("[Test case %d] Expected '%s', found '%s'", idx, testCase.name, name)
+		}
+		if dir := store.Owner(testCase.owner).dir; filepath.Dir(dir) != "." {
+			t.Errorf("[Test case %d] Expected a single dir, found '%s'", idx, dir)
+		}
+	}
+}
+
+func Test_OwnerStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewOwnerStore(dir)
+	day := dayOf("2016-04-24", "wsj", "World", "a")
+	*day.Owners = append(*day.Owners, (*dayOf("2016-04-24", "a/b", "iPhone", "b").Owners)[0])
+	if err := store.SaveDay(&day); err != nil {
+		t.Error(err)
+	}
+
+	for _, name := range []string{"wsj/2016-04-24.json", "a%2Fb/2016-04-24.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected '%s', found %v", name, err)
+		}
+	}
+	owners, _ := store.Owners()
+	if !reflect.DeepEqual(owners, []string{"a/b", "wsj"}) {
+		t.Errorf("Expected the owners, found %v", owners)
+	}
+
+	// only the files of the owners saved are written
+	wsj := filepath.Join(dir, "wsj", "2016-04-24.json")
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(wsj, old, old)
+	marshaller, _ := NewStoreMarshaller(store)
+	marshaller.Days = &Days{}
+	marshaller.Days.AddItem(Item{Title: "c", Link: "http://c"}, "2016-04-24", "a/b", "iPhone", "")
+	if err := marshaller.Save(); err != nil {
+		t.Error(err)
+	}
+	if stat, _ := os.Stat(wsj); !stat.ModTime().Equal(old) {
+		t.Errorf("Expected '%s' left as is, found modified at %v", wsj, stat.ModTime())
+	}
+
+	titles := []string{}
+	store.OwnerItems("a/b", "", "", func(date string, owner *Owner, channel *Channel, item *Item) {
+		titles = append(titles, item.Title)
+	})
+	if !reflect.DeepEqual(titles, []string{"b", "c"}) {
+		t.Errorf("Expected the items of the owner merged, found %v", titles)
+	}
+
+	// owners whose ids escape alike but for their dots keep dirs of their own
+	dotted := dayOf("2016-04-25", "", "World", "d")
+	*dotted.Owners = append(*dotted.Owners, (*dayOf("2016-04-25", ".", "World", "e").Owners)[0])
+	store.SaveDay(&dotted)
+	owners, _ = store.Owners()
+	if !reflect.DeepEqual(owners, []string{"", ".", "a/b", "wsj"}) {
+		t.Errorf("Expected the owners, found %v", owners)
+	}
+	if loaded, _ := store.LoadDay("2016-04-25"); loaded == nil || !reflect.DeepEqual(*loaded, dotted) {
+		t.Errorf("Expected %+v, found %+v", dotted, loaded)
+	}
+
+	// owners left out of a day lose it
+	replaced := dayOf("2016-04-24", "wsj", "World", "a")
+	store.SaveDay(&replaced)
+	loaded, _ := store.LoadDay("2016-04-24")
+	if !reflect.DeepEqual(*loaded, replaced) {
+		t.Errorf("Expected %+v, found %+v", replaced, *loaded)
+	}
+}
+
+func Test_SplitOwners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	shared := NewDirStore(dir)
+	for _, day := range []Day{dayOf("2016-04-24", "cnet", "iPhone", "a"), dayOf("2016-04-25", "wsj", "World", "b")} {
+		shared.SaveDay(&day)
+	}
+	existing := dayOf("2016-04-25", "wsj", "World", "c")
+	owned := NewOwnerStore(dir) // side by side with the shared day files
+	owned.SaveDay(&existing)
+
+	split := map[string]int{}
+	err = SplitOwners(shared, owned, func(date string, owners int) {
+		split[date] = owners
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(split, map[string]int{"2016-04-24": 1, "2016-04-25": 1}) {
+		t.Errorf("Expected every day split, found %v", split)
+	}
+
+	testCases := []struct {
+		owner string
+		items []string // date title
+	}{
+		{"cnet", []string{"2016-04-24 a"}},
+		{"wsj", []string{"2016-04-25 b", "2016-04-25 c"}},
+	}
+
+	for idx, testCase := range testCases {
+		items := []string{}
+		owned.Owner(testCase.owner).Items("", "", func(date string, owner *Owner, channel *Channel, item *Item) {
+			items = append(items, date+" "+item.Title)
+		})
+		if !reflect.DeepEqual(items, testCase.items) {
+			t.Errorf("[Test case %d] Expected %v, found %v", idx, testCase.items, items)
+		}
+	}
+}
+
+func Test_OwnerStore_RemoveDay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewOwnerStore(dir)
+	day := dayOf("2016-04-24", "wsj", "World", "a")
+	*day.Owners = append(*day.Owners, (*dayOf("2016-04-24", "cnet", "iPhone", "b").Owners)[0])
+	store.SaveDay(&day)
+	if err := store.Owner("wsj").Archive("2016-05-01", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the archived day is found as well as the day file
+	if err := store.RemoveDay("2016-04-24"); err != nil {
+		t.Error(err)
+	}
+	if dates, _ := store.Dates(); len(dates) != 0 {
+		t.Errorf("Expected the day removed from every owner, found %v", dates)
+	}
+	if err := store.RemoveDay("2016-04-24"); err == nil {
+		t.Errorf("Expected an error removing a day no owner has")
+	}
+
+	// the day files of several owners are set aside at once
+	store.SaveDay(&day)
+	for _, owner := range []string{"wsj", "cnet"} {
+		ioutil.WriteFile(filepath.Join(dir, owner, "2016-04-24.json"), []byte("{"), 0666)
+	}
+	problems, _ := store.Check()
+	if len(problems) != 2 {
+		t.Fatalf("Expected a problem per owner, found %v", problems)
+	}
+	if aside, err := store.Quarantine("2016-04-24"); err != nil || aside == "" {
+		t.Errorf("Expected the day files set aside, found '%s', %v", aside, err)
+	}
+	if _, err := store.Quarantine("2016-04-24"); err == nil {
+		t.Errorf("Expected an error once the day files are set aside")
+	}
+}
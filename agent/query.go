@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// layout of the dates naming the day files
+const dateLayout = "2006-01-02"
+
+// the dates of the days persisted so far, in order
+func (m *Marshaller) Dates() ([]string, error) {
+	return m.Store.Dates()
+}
+
+// the persisted day of a given date, empty if none
+func (m *Marshaller) Load(date string) (*Day, error) {
+	return m.load(date)
+}
+
+// criteria on the persisted items, empty ones match everything
+type Query struct {
+	Owner   string
+	Channel string         // title of the channel
+	Since   string         // first date, inclusive
+	Until   string         // last date, inclusive
+	Match   *regexp.Regexp // on the title of the items
+}
+
+func (q Query) keepsDate(date string) bool {
+	return (q.Since == "" || strings.Compare(date, q.Since) >= 0) &&
+		(q.Until == "" || strings.Compare(date, q.Until) <= 0)
+}
+
+// an item, along with where it's persisted
+type Result struct {
+	Date    string `json:"date"`
+	Owner   string `json:"owner"`
+	Channel string `json:"channel"`
+	Item    *Item  `json:"item"`
+}
+
+// the persisted items matching the query, by date, owner, channel then title
+func (m *Marshaller) Query(q Query) ([]Result, error) {
+	results := []Result{}
+	err := m.walk(q, func(date string, owner *Owner, channel *Channel, item *Item) {
+		results = append(results, Result{
+			Date:    date,
+			Owner:   owner.Id,
+			Channel: channel.Title,
+			Item:    item,
+		})
+	})
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	return results, nil
+}
+
+// call fn for each persisted item matching the query
+// indexed stores select the items of the owner or the channel first
+func (m *Marshaller) walk(q Query, fn ItemFunc) error {
+	items := m.Store.Items
+	if indexed, ok := m.Store.(IndexedStore); ok {
+		switch {
+		case q.Owner != "":
+			items = func(since, until string, fn ItemFunc) error {
+				return indexed.OwnerItems(q.Owner, since, until, fn)
+			}
+		case q.Channel != "":
+			items = func(since, until string, fn ItemFunc) error {
+				return indexed.ChannelItems(q.Channel, since, until, fn)
+			}
+		}
+	}
+
+	return items(q.Since, q.Until, func(date string, owner *Owner, channel *Channel, item *Item) {
+		if q.Owner != "" && owner.Id != q.Owner {
+			return
+		}
+		if q.Channel != "" && channel.Title != q.Channel {
+			return
+		}
+		if q.Match != nil && !q.Match.MatchString(item.Title) {
+			return
+		}
+		fn(date, owner, channel, item)
+	})
+}
+
+// figures about the persisted items
+type Stats struct {
+	Days   int          `json:"days"`
+	First  string       `json:"first,omitempty"` // date of the first day
+	Last   string       `json:"last,omitempty"`  // date of the last day
+	Items  int          `json:"items"`
+	Owners []OwnerStats `json:"owners"`
+}
+
+type OwnerStats struct {
+	Id       string         `json:"id"`
+	Items    int            `json:"items"`
+	Channels []ChannelStats `json:"channels"`
+}
+
+type ChannelStats struct {
+	Title string `json:"title"`
+	Items int    `json:"items"`
+	Last  string `json:"last"` // date of the last item
+}
+
+// count the persisted items, per owner and channel
+func (m *Marshaller) Stats() (*Stats, error) {
+	stats := &Stats{Owners: []OwnerStats{}}
+
+	owners := map[string]map[string]*ChannelStats{}
+	days := map[string]bool{}
+	err := m.walk(Query{}, func(date string, owner *Owner, channel *Channel, item *Item) {
+		days[date] = true
+		if stats.First == "" || strings.Compare(date, stats.First) < 0 {
+			stats.First = date
+		}
+		if strings.Compare(date, stats.Last) > 0 {
+			stats.Last = date
+		}
+		stats.Items++
+
+		channels, ok := owners[owner.Id]
+		if !ok {
+			channels = map[string]*ChannelStats{}
+			owners[owner.Id] = channels
+		}
+		cs, ok := channels[channel.Title]
+		if !ok {
+			cs = &ChannelStats{Title: channel.Title}
+			channels[channel.Title] = cs
+		}
+		cs.Items++
+		if strings.Compare(date, cs.Last) > 0 {
+			cs.Last = date
+		}
+	})
+	if err != nil {
+		return nil, err // already formatted
+	}
+	stats.Days = len(days)
+
+	for id, channels := range owners {
+		ownerStats := OwnerStats{Id: id, Channels: []ChannelStats{}}
+		for _, cs := range channels {
+			ownerStats.Items += cs.Items
+			ownerStats.Channels = append(ownerStats.Channels, *cs)
+		}
+		sort.Sort(byTitle(ownerStats.Channels))
+		stats.Owners = append(stats.Owners, ownerStats)
+	}
+	sort.Sort(byId(stats.Owners))
+
+	return stats, nil
+}
+
+// sort owner stats by id
+type byId []OwnerStats
+
+func (b byId) Len() int {
+	return len(b)
+}
+func (b byId) Less(i, j int) bool {
+	return strings.Compare(b[i].Id, b[j].Id) < 0
+}
+func (b byId) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+// sort channel stats by title
+type byTitle []ChannelStats
+
+func (b byTitle) Len() int {
+	return len(b)
+}
+func (b byTitle) Less(i, j int) bool {
+	return strings.Compare(b[i].Title, b[j].Title) < 0
+}
+func (b byTitle) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// persist days to a temp dir
+func writeDays(t *testing.T, days ...Day) string {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, day := range days {
+		bytes, err := json.Marshal(day)
+		if err != nil {
+			t.Error(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, day.Date), bytes, 0666)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	return dir
+}
+
+func dayOf(date, owner, channel string, titles ...string) Day {
+	items := Items{}
+	for _, title := range titles {
+		items = append(items, &Item{Title: title, Link: "http://" + title})
+	}
+	return Day{
+		Date: date,
+		Owners: &Owners{
+			&Owner{Id: owner, Channels: &Channels{&Channel{Title: channel, Items: &items}}},
+		},
+	}
+}
+
+func Test_Marshaller_Query(t *testing.T) {
+	dir := writeDays(t,
+		dayOf("2016-04-24", "cnet", "iPhone", "a", "b"),
+		dayOf("2016-04-25", "wsj", "World", "c", "d"),
+		dayOf("2016-04-26", "wsj", "Business", "e"),
+	)
+	defer os.RemoveAll(dir)
+
+	// not a day file
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0666)
+
+	marshaller, _ := NewMarshaller(dir)
+
+	testCases := []struct {
+		query  Query
+		titles []string
+	}{
+		{Query{}, []string{"a", "b", "c", "d", "e"}},
+		{Query{Owner: "wsj"}, []string{"c", "d", "e"}},
+		{Query{Channel: "World"}, []string{"c", "d"}},
+		{Query{Since: "2016-04-25", Until: "2016-04-25"}, []string{"c", "d"}},
+		{Query{Match: regexp.MustCompile("^[be]$")}, []string{"b", "e"}},
+		{Query{Owner: "nobody"}, []string{}},
+	}
+
+	for idx, testCase := range testCases {
+		results, err := marshaller.Query(testCase.query)
+		if err != nil {
+			t.Errorf("[Test case %d] Unexpected error: %v", idx, err)
+			continue
+		}
+
+		titles := []string{}
+		for _, result := range results {
+			titles = append(titles, result.Item.Title)
+		}
+		if !reflect.DeepEqual(titles, testCase.titles) {
+			t.Errorf("[Test case %d] Expected '%v', found '%v'", idx, testCase.titles, titles)
+		}
+	}
+}
+
+func Test_Marshaller_Stats(t *testing.T) {
+	dir := writeDays(t,
+		dayOf("2016-04-24", "wsj", "World", "a", "b"),
+		dayOf("2016-04-25", "wsj", "World", "c"),
+		dayOf("2016-04-26", "cnet", "iPhone", "d"),
+	)
+	defer os.RemoveAll(dir)
+
+	marshaller, _ := NewMarshaller(dir)
+	stats, err := marshaller.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Stats{
+		Days:  3,
+		First: "2016-04-24",
+		Last:  "2016-04-26",
+		Items: 4,
+		Owners: []OwnerStats{
+			{Id: "cnet", Items: 1, Channels: []ChannelStats{{Title: "iPhone", Items: 1, Last: "2016-04-26"}}},
+			{Id: "wsj", Items: 3, Channels: []ChannelStats{{Title: "World", Items: 3, Last: "2016-04-25"}}},
+		},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Expected '%+v', found '%+v'", expected, stats)
+	}
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// how often the channels files are checked for changes
+const DefaultReloadInterval = 10 * time.Second
+
+// the state of the channels files, to tell when they change
+// files are identified by their path, changes by their mtime and size
+type snapshot map[string]string
+
+// the files the loader would read now, and the ones it read last time
+// included files are only known once read
+func (l *Loader) snapshot(path string) (snapshot, error) {
+	paths, err := l.channelFiles(path)
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	s := snapshot{}
+	for _, p := range append(paths, l.Files...) {
+		fi, err := os.Stat(p)
+		if err != nil { // removed
+			s[p] = ""
+			continue
+		}
+		s[p] = fmt.Sprintf("%d %d", fi.ModTime().UnixNano(), fi.Size())
+	}
+
+	return s, nil
+}
+
+// the changes between two sets of channel groups, by schedule key
+type groupsDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string          // options changed
+	names   map[string]string // of the feeds, by key
+}
+
+func (d groupsDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d groupsDiff) String() string {
+	lines := []string{}
+	for _, key := range d.Added {
+		lines = append(lines, "+ "+d.names[key])
+	}
+	for _, key := range d.Removed {
+		lines = append(lines, "- "+d.names[key])
+	}
+	for _, key := range d.Changed {
+		lines = append(lines, "~ "+d.names[key])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// compare the feeds of two sets of channel groups, by owner and url
+func diffGroups(old, new ChannelGroups) groupsDiff {
+	names := map[string]string{}
+	oldFeeds := feedsByKey(old, names)
+	newFeeds := feedsByKey(new, names)
+
+	diff := groupsDiff{Added: []string{}, Removed: []string{}, Changed: []string{}, names: names}
+	for key, feed := range newFeeds {
+		oldFeed, ok := oldFeeds[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+		} else if !reflect.DeepEqual(oldFeed, feed) {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key, _ := range oldFeeds {
+		if _, ok := newFeeds[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// keyed as scheduled, so that the changes are found in the schedule
+// the names of the feeds are added to names
+func feedsByKey(groups ChannelGroups, names map[string]string) map[string]Feed {
+	feeds := map[string]Feed{}
+	for _, group := range groups {
+		for _, feed := range group.Channels {
+			key := scheduleKey(group.Owner, feed.Url)
+			feeds[key] = feed
+			names[key] = feedName(group.Owner, feed.Url)
+		}
+	}
+	return feeds
+}
+
+// reload the channels if their files changed
+// the current channels are kept if the new ones can't be loaded
+// what validate finds wrong with the new ones, e.g. a url of two owners, is logged as warnings
+func (d *Daemon) checkReload() {
+	current, err := d.Loader.snapshot(d.path)
+	if err != nil {
+		logf("%v\n", err)
+		return
+	}
+	if reflect.DeepEqual(current, d.snapshot) {
+		return
+	}
+	d.snapshot = current
+
+	// only what Load rejects keeps the current channels, the other problems are warned about
+	loader := d.Loader.clone()
+	err = loader.Load(d.path)
+	if err != nil {
+		logf("[ERR] Unable to reload channels, keeping the current ones: %v\n", err)
+		return
+	}
+	if diags, err := d.Loader.clone().Validate(d.path); err == nil {
+		for _, diag := range diags {
+			logf("[WRN] %v\n", diag)
+		}
+	}
+
+	diff := diffGroups(d.Loader.ChannelGroups, loader.ChannelGroups)
+	d.Loader = loader
+	// files read by the new loader, to catch changes to newly included files
+	if s, err := loader.snapshot(d.path); err == nil {
+		d.snapshot = s
+	}
+
+	if diff.empty() {
+		return
+	}
+	logf("[INF] Reloaded channels:\n%s\n", diff)
+
+	d.sync()
+
+	// the next poll of the changed feeds follows their new options
+	for _, key := range diff.Changed {
+		state, ok := d.Schedule.Feeds[key]
+		if !ok || state.Last.IsZero() { // disabled, or never polled
+			continue
+		}
+		state.Next = state.Last.Add(d.Schedule.Interval(d.feeds[key].Feed, state))
+	}
+
+	if err := d.Schedule.Save(); err != nil {
+		logf("%v\n", err)
+	}
+}
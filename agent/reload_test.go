@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_diffGroups(t *testing.T) {
+	testCases := []struct {
+		old  ChannelGroups
+		new  ChannelGroups
+		diff groupsDiff
+	}{
+		{ // same feeds
+			ChannelGroups{ChannelGroup{Owner: "a", Channels: Feeds{Feed{Url: "http://a"}}}},
+			ChannelGroups{ChannelGroup{Owner: "a", Channels: Feeds{Feed{Url: "http://a"}}}},
+			groupsDiff{Added: []string{}, Removed: []string{}, Changed: []string{}},
+		},
+		{ // added and removed
+			ChannelGroups{ChannelGroup{Owner: "a", Channels: Feeds{Feed{Url: "http://a"}}}},
+			ChannelGroups{ChannelGroup{Owner: "a", Channels: Feeds{Feed{Url: "http://b"}}}},
+			groupsDiff{Added: []string{"a http://b"}, Removed: []string{"a http://a"}, Changed: []string{}},
+		},
+		{ // same url, other owner
+			ChannelGroups{ChannelGroup{Owner: "a", Channels: Feeds{Feed{Url: "http://a"}}}},
+			ChannelGroups{ChannelGroup{Owner: "b", Channels: Feeds{Feed{Url: "http://a"}}}},
+			groupsDiff{Added: []string{"b http://a"}, Removed: []string{"a http://a"}, Changed: []string{}},
+		},
+		{ // options changed
+			ChannelGroups{ChannelGroup{Owner: "a", Channels: Feeds{Feed{Url: "http://a"}}}},
+			ChannelGroups{ChannelGroup{Owner: "a", Channels: Feeds{Feed{Url: "http://a", Interval: "2h"}}}},
+			groupsDiff{Added: []string{}, Removed: []string{}, Changed: []string{"a http://a"}},
+		},
+		{ // keyed as scheduled, with the secrets hidden
+			ChannelGroups{ChannelGroup{Owner: "a", Channels: Feeds{Feed{Url: "http://a?token=r3load-t0ken"}}}},
+			ChannelGroups{ChannelGroup{Owner: "a", Channels: Feeds{Feed{Url: "http://a?token=r3load-t0ken", Interval: "2h"}}}},
+			groupsDiff{Added: []string{}, Removed: []string{}, Changed: []string{"a http://a?token=****"}},
+		},
+	}
+
+	registerSecret("r3load-t0ken")
+	for idx, testCase := range testCases {
+		diff := diffGroups(testCase.old, testCase.new)
+
+		// compared by name, the keys are hashes
+		named := groupsDiff{}
+		for _, keys := range []struct{ from, to *[]string }{{&diff.Added, &named.Added}, {&diff.Removed, &named.Removed}, {&diff.Changed, &named.Changed}} {
+			*keys.to = []string{}
+			for _, key := range *keys.from {
+				*keys.to = append(*keys.to, diff.names[key])
+			}
+		}
+		if !reflect.DeepEqual(named, testCase.diff) {
+			t.Errorf("[Test case %d] Expected '%v', found '%v'", idx, testCase.diff, named)
+		}
+	}
+}
+
+func Test_Daemon_checkReload(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"channels/a.json": `[{"owner": "a", "channels": ["http://a.com/rss"]}]`,
+	})
+	defer os.RemoveAll(dir)
+	channels := filepath.Join(dir, "channels")
+
+	loader, _ := NewLoader()
+	if err := loader.Load(channels); err != nil {
+		t.Fatal(err)
+	}
+
+	schedule, _ := NewSchedule(filepath.Join(dir, "schedule.json"))
+	daemon, err := NewDaemon(loader, channels, schedule, NewDirStore(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.now = func() time.Time { return time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC) }
+	daemon.sync()
+	daemon.snapshot, _ = loader.snapshot(channels)
+
+	// a feed is added
+	err = ioutil.WriteFile(filepath.Join(channels, "a.json"), []byte(`[{"owner": "a", "channels": ["http://a.com/rss", "http://b.com/rss"]}]`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.checkReload()
+
+	if _, ok := schedule.Feeds[scheduleKey("a", "http://b.com/rss")]; !ok {
+		t.Errorf("Expected the added feed to be scheduled")
+	}
+	if _, ok := schedule.Feeds[scheduleKey("a", "http://a.com/rss")]; !ok {
+		t.Errorf("Expected the current feed to stay scheduled")
+	}
+
+	// the new file can't be loaded, the current channels are kept
+	err = ioutil.WriteFile(filepath.Join(channels, "a.json"), []byte(`[{"owner": "a", "channels": ["http://c.com/rss"`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.checkReload()
+
+	if len(daemon.Loader.ChannelGroups) != 1 || len(daemon.Loader.ChannelGroups[0].Channels) != 2 {
+		t.Errorf("Expected the current channels to be kept, found '%v'", daemon.Loader.ChannelGroups)
+	}
+	if len(schedule.Feeds) != 2 {
+		t.Errorf("Expected the schedule to be kept, found '%v'", schedule.Feeds)
+	}
+
+	// a url of two owners only warns, as Load takes it
+	err = ioutil.WriteFile(filepath.Join(channels, "a.json"), []byte(`[{"owner": "a", "channels": ["http://a.com/rss"]}, {"owner": "b", "channels": ["http://a.com/rss"]}]`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.checkReload()
+
+	if len(daemon.Loader.ChannelGroups) != 2 {
+		t.Errorf("Expected the channels reloaded, found '%v'", daemon.Loader.ChannelGroups)
+	}
+	if _, ok := schedule.Feeds[scheduleKey("b", "http://a.com/rss")]; !ok || len(schedule.Feeds) != 2 {
+		t.Errorf("Expected the feeds of the new channels scheduled, found '%v'", schedule.Feeds)
+	}
+}
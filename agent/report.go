@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// outcome of the fetch of a feed
+type FeedReport struct {
+	Owner     string  `json:"owner"`
+	Url       string  `json:"url"`
+	Items     int     `json:"items"`
+	Error     string  `json:"error,omitempty"`
+	MovedTo   string  `json:"moved_to,omitempty"`  // where the permanent redirects of the url lead
+	Gone      bool    `json:"gone,omitempty"`      // answered with 410
+	Recovered bool    `json:"recovered,omitempty"` // malformed, what could be parsed was kept
+	Robots    bool    `json:"robots,omitempty"`    // skipped, robots.txt disallows it
+	Traffic   Traffic `json:"traffic"`
+}
+
+// bytes downloaded, as sent and once decompressed
+type Traffic struct {
+	Compressed   int64 `json:"compressed"`
+	Decompressed int64 `json:"decompressed"`
+}
+
+func (t *Traffic) add(other Traffic) {
+	t.Compressed += other.Compressed
+	t.Decompressed += other.Decompressed
+}
+
+func (t Traffic) String() string {
+	return fmt.Sprintf("%s compressed, %s decompressed", formatBytes(t.Compressed), formatBytes(t.Decompressed))
+}
+
+// outcome of a crawl, per feed
+type Report struct {
+	Feeds map[string]*FeedReport `json:"feeds"` // by key
+	Hosts map[string]*Traffic    `json:"hosts"` // every request counts, redirects and discovery included
+}
+
+func NewReport() *Report {
+	return &Report{
+		Feeds: map[string]*FeedReport{},
+		Hosts: map[string]*Traffic{},
+	}
+}
+
+// account the bytes of a response to its host
+func (r *Report) count(host string, traffic Traffic) {
+	t, ok := r.Hosts[host]
+	if !ok {
+		t = &Traffic{}
+		r.Hosts[host] = t
+	}
+	t.add(traffic)
+}
+
+// a blank entry for a feed, replacing the one of a previous fetch
+func (r *Report) start(owner, url string) *FeedReport {
+	rep := &FeedReport{Owner: owner, Url: url}
+	r.Feeds[feedKey(owner, url)] = rep
+	return rep
+}
+
+// the entries, by owner then url
+func (r *Report) List() []*FeedReport {
+	keys := []string{}
+	for key, _ := range r.Feeds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := []*FeedReport{}
+	for _, key := range keys {
+		list = append(list, r.Feeds[key])
+	}
+	return list
+}
+
+// the feeds that couldn't be crawled, the ones robots.txt disallows aside
+func (r *Report) Failed() int {
+	failed := 0
+	for _, rep := range r.Feeds {
+		if !rep.Robots && rep.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// a summary, followed by a line per feed needing attention
+func (r *Report) String() string {
+	skipped := 0 // by robots.txt
+	items := 0
+	lines := []string{}
+	for _, rep := range r.List() {
+		items += rep.Items
+		if rep.Robots {
+			skipped++
+		}
+
+		key := feedKey(rep.Owner, rep.Url)
+		switch {
+		case rep.Gone:
+			lines = append(lines, fmt.Sprintf("[GONE] %s", key))
+		case rep.Robots:
+			lines = append(lines, fmt.Sprintf("[ROBOTS] %s", key))
+		case rep.Error != "":
+			lines = append(lines, fmt.Sprintf("[FAILED] %s: %s", key, strings.TrimPrefix(rep.Error, "[ERR] ")))
+		}
+		if rep.Recovered {
+			lines = append(lines, fmt.Sprintf("[RECOVERED] %s", key))
+		}
+		if rep.MovedTo != "" {
+			lines = append(lines, fmt.Sprintf("[MOVED] %s -> %s", key, rep.MovedTo))
+		}
+	}
+
+	// the hosts costing the most first
+	total := Traffic{}
+	hosts := []string{}
+	for host, traffic := range r.Hosts {
+		total.add(*traffic)
+		hosts = append(hosts, host)
+	}
+	sort.Sort(byTraffic{hosts, r.Hosts})
+	for _, host := range hosts {
+		lines = append(lines, fmt.Sprintf("[TRAFFIC] %s: %v", host, r.Hosts[host]))
+	}
+
+	summary := fmt.Sprintf("%d feed(s) crawled, %d failed, %d skipped, %d item(s), %v", len(r.Feeds), r.Failed(), skipped, items, total)
+	return strings.Join(append([]string{summary}, lines...), "\n")
+}
+
+// a byte count, in the largest unit it's at least one of
+func formatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	value := float64(n)
+	idx := 0
+	for value >= 1024 && idx < len(units)-1 {
+		value /= 1024
+		idx++
+	}
+	if idx == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.1f%s", value, units[idx])
+}
+
+// sort hosts by compressed bytes, descending, then by name
+type byTraffic struct {
+	hosts   []string
+	traffic map[string]*Traffic
+}
+
+func (b byTraffic) Len() int {
+	return len(b.hosts)
+}
+func (b byTraffic) Less(i, j int) bool {
+	ti, tj := b.traffic[b.hosts[i]].Compressed, b.traffic[b.hosts[j]].Compressed
+	if ti != tj {
+		return ti > tj
+	}
+	return strings.Compare(b.hosts[i], b.hosts[j]) < 0
+}
+func (b byTraffic) Swap(i, j int) {
+	b.hosts[i], b.hosts[j] = b.hosts[j], b.hosts[i]
+}
@@ -0,0 +1,194 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/marouenj/rss/util"
+)
+
+// how long the items are kept, and how many
+type Retention struct {
+	Before   string         // the items of the days before this date are removed, KeepDays applies if empty
+	KeepDays int            // the items of the days older than this many days are removed, none if 0
+	Owners   map[string]int // KeepDays of the owners keeping their items for another time, forever if 0
+	MaxItems int            // most items of a channel per day, the first by title are kept as day files don't keep the publication dates, no cap if 0
+	Archive  Store          // where the removed items are moved to, deleted if nil
+}
+
+// how often the daemon applies the retention
+const PruneInterval = time.Hour
+
+// reasons of the removals
+const (
+	Expired = "expired"
+	Capped  = "capped"
+)
+
+// the items of a channel a retention removes from a day
+// days with no item left are removed as a whole
+type Removal struct {
+	Date    string `json:"date"`
+	Owner   string `json:"owner,omitempty"` // empty for a day with no item
+	Channel string `json:"channel,omitempty"`
+	Items   int    `json:"items"`
+	Reason  string `json:"reason"` // Expired or Capped
+}
+
+func (r Removal) String() string {
+	if r.Owner == "" {
+		return fmt.Sprintf("'%s', %s", r.Date, r.Reason)
+	}
+	return fmt.Sprintf("%d item(s) of '%s' of '%s' on '%s', %s", r.Items, r.Channel, r.Owner, r.Date, r.Reason)
+}
+
+// the date the items of an owner are kept from, empty if kept forever
+func (r *Retention) cutoff(owner string, now time.Time) string {
+	if days, ok := r.Owners[owner]; ok {
+		if days <= 0 {
+			return ""
+		}
+		return util.DateInUtc(now.AddDate(0, 0, -days))
+	}
+	if r.Before != "" {
+		return r.Before
+	}
+	if r.KeepDays > 0 {
+		return util.DateInUtc(now.AddDate(0, 0, -r.KeepDays))
+	}
+	return ""
+}
+
+// the latest of the cutoffs, the days past it have no expired item
+func (r *Retention) lastCutoff(now time.Time) string {
+	last := r.cutoff("", now)
+	for owner, _ := range r.Owners {
+		if cutoff := r.cutoff(owner, now); cutoff > last {
+			last = cutoff
+		}
+	}
+	return last
+}
+
+// remove, or archive, the items of a store past the retention
+// with dryRun, nothing is removed
+// returns what's removed, by date, owner then channel
+func (r *Retention) Apply(store Store, now time.Time, dryRun bool) ([]Removal, error) {
+	if !dryRun {
+		unlock, err := lockStore(store)
+		if err != nil {
+			return nil, err // already formatted
+		}
+		defer unlock()
+	}
+
+	dates, err := store.Dates()
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	last := r.lastCutoff(now)
+	removals := []Removal{}
+	for _, date := range dates {
+		if r.MaxItems <= 0 && date >= last {
+			break // dates are sorted
+		}
+
+		day, err := store.LoadDay(date)
+		if err != nil {
+			return removals, err // already formatted
+		}
+		if day == nil {
+			continue
+		}
+		if day.Owners == nil {
+			day.Owners = &Owners{}
+		}
+
+		removed, dayRemovals := r.split(day, now)
+		if len(dayRemovals) == 0 {
+			if len(*day.Owners) > 0 || date >= r.cutoff("", now) {
+				continue
+			}
+			dayRemovals = []Removal{{Date: date, Reason: Expired}} // nothing left in it
+		}
+
+		if !dryRun {
+			if err := r.remove(store, day, removed); err != nil {
+				return removals, err // already formatted
+			}
+		}
+		removals = append(removals, dayRemovals...)
+	}
+
+	return removals, nil
+}
+
+// move the items past the retention out of a day
+// returns the removed items, as a day of their own
+func (r *Retention) split(day *Day, now time.Time) (*Day, []Removal) {
+	removed := &Day{Date: day.Date, Owners: &Owners{}}
+	removals := []Removal{}
+
+	kept := Owners{}
+	for _, owner := range *day.Owners {
+		if owner.Channels == nil {
+			owner.Channels = &Channels{}
+		}
+		expired := day.Date < r.cutoff(owner.Id, now)
+
+		keptChannels := Channels{}
+		removedChannels := Channels{}
+		for _, channel := range *owner.Channels {
+			if channel.Items == nil {
+				channel.Items = &Items{}
+			}
+
+			keep, drop, reason := *channel.Items, Items{}, Capped
+			if expired {
+				keep, drop, reason = Items{}, *channel.Items, Expired
+			} else if r.MaxItems > 0 && len(keep) > r.MaxItems {
+				keep, drop = append(Items{}, keep[:r.MaxItems]...), append(Items{}, keep[r.MaxItems:]...)
+			}
+
+			if len(keep) > 0 {
+				keptChannels = append(keptChannels, &Channel{Title: channel.Title, Desc: channel.Desc, Items: &keep})
+			}
+			if len(drop) > 0 {
+				removedChannels = append(removedChannels, &Channel{Title: channel.Title, Desc: channel.Desc, Items: &drop})
+				removals = append(removals, Removal{Date: day.Date, Owner: owner.Id, Channel: channel.Title, Items: len(drop), Reason: reason})
+			}
+		}
+
+		if len(keptChannels) > 0 {
+			kept = append(kept, &Owner{Id: owner.Id, Channels: &keptChannels})
+		}
+		if len(removedChannels) > 0 {
+			*removed.Owners = append(*removed.Owners, &Owner{Id: owner.Id, Channels: &removedChannels})
+		}
+	}
+
+	if len(removals) > 0 {
+		*day.Owners = kept
+	}
+	return removed, removals
+}
+
+// persist what's left of a day, archiving what's removed first
+func (r *Retention) remove(store Store, day, removed *Day) error {
+	if r.Archive != nil && len(*removed.Owners) > 0 {
+		marshaller, err := NewStoreMarshaller(r.Archive)
+		if err != nil {
+			return err // already formatted
+		}
+		marshaller.Days = &Days{removed}
+		if err := marshaller.Save(); err != nil {
+			return err // already formatted
+		}
+	}
+
+	if len(*day.Owners) == 0 {
+		return store.RemoveDay(day.Date)
+	}
+	return store.SaveDay(day)
+}
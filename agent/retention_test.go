@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// the store the retention tests start from
+func retentionStore() *MemStore {
+	store := NewMemStore()
+
+	days := []Day{
+		dayOf("2016-04-20", "cnet", "iPhone", "x"),
+		dayOf("2016-04-24", "wsj", "World", "b"),
+		dayOf("2016-04-25", "wsj", "World", "c"),
+		{Date: "2016-04-21", Owners: &Owners{}},
+	}
+	wsj := dayOf("2016-04-20", "wsj", "World", "a")
+	*days[0].Owners = append(*days[0].Owners, (*wsj.Owners)[0])
+	zdnet := dayOf("2016-04-25", "zdnet", "Tech", "m", "n", "o", "p")
+	*days[2].Owners = append(*days[2].Owners, (*zdnet.Owners)[0])
+
+	for idx, _ := range days {
+		store.SaveDay(&days[idx])
+	}
+	return store
+}
+
+// the titles of the items of a store, by date
+func storeTitles(s Store) map[string][]string {
+	titles := map[string][]string{}
+	s.Items("", "", func(date string, owner *Owner, channel *Channel, item *Item) {
+		titles[date] = append(titles[date], item.Title)
+	})
+	return titles
+}
+
+func Test_Retention_Apply(t *testing.T) {
+	now := time.Date(2016, 4, 27, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		retention Retention
+		removals  []Removal
+		left      map[string][]string
+	}{
+		{ // nothing set
+			Retention{},
+			[]Removal{},
+			map[string][]string{"2016-04-20": {"x", "a"}, "2016-04-24": {"b"}, "2016-04-25": {"c", "m", "n", "o", "p"}},
+		},
+		{ // before a date
+			Retention{Before: "2016-04-24"},
+			[]Removal{
+				{"2016-04-20", "cnet", "iPhone", 1, Expired},
+				{"2016-04-20", "wsj", "World", 1, Expired},
+				{"2016-04-21", "", "", 0, Expired},
+			},
+			map[string][]string{"2016-04-24": {"b"}, "2016-04-25": {"c", "m", "n", "o", "p"}},
+		},
+		{ // older than some days, but for an owner kept longer
+			Retention{KeepDays: 2, Owners: map[string]int{"cnet": 10}},
+			[]Removal{
+				{"2016-04-20", "wsj", "World", 1, Expired},
+				{"2016-04-21", "", "", 0, Expired},
+				{"2016-04-24", "wsj", "World", 1, Expired},
+			},
+			map[string][]string{"2016-04-20": {"x"}, "2016-04-25": {"c", "m", "n", "o", "p"}},
+		},
+		{ // an owner kept for less, the others forever
+			Retention{Owners: map[string]int{"wsj": 3}},
+			[]Removal{
+				{"2016-04-20", "wsj", "World", 1, Expired},
+			},
+			map[string][]string{"2016-04-20": {"x"}, "2016-04-24": {"b"}, "2016-04-25": {"c", "m", "n", "o", "p"}},
+		},
+		{ // capped, the first are kept
+			Retention{MaxItems: 2},
+			[]Removal{
+				{"2016-04-25", "zdnet", "Tech", 2, Capped},
+			},
+			map[string][]string{"2016-04-20": {"x", "a"}, "2016-04-24": {"b"}, "2016-04-25": {"c", "m", "n"}},
+		},
+	}
+
+	for idx, testCase := range testCases {
+		for _, dryRun := range []bool{true, false} {
+			store := retentionStore()
+			before := storeTitles(store)
+
+			removals, err := testCase.retention.Apply(store, now, dryRun)
+			if err != nil {
+				t.Errorf("[Test case %d] %v", idx, err)
+			}
+			if !reflect.DeepEqual(removals, testCase.removals) {
+				t.Errorf("[Test case %d] Expected %+v, found %+v", idx, testCase.removals, removals)
+			}
+
+			left := storeTitles(store)
+			if dryRun && !reflect.DeepEqual(left, before) {
+				t.Errorf("[Test case %d] Expected nothing removed on a dry run, found %v", idx, left)
+			}
+			if !dryRun && !reflect.DeepEqual(left, testCase.left) {
+				t.Errorf("[Test case %d] Expected %v left, found %v", idx, testCase.left, left)
+			}
+		}
+	}
+}
+
+func Test_Retention_Apply_archive(t *testing.T) {
+	store := retentionStore()
+	archive := NewMemStore()
+	extra := dayOf("2016-04-20", "wsj", "World", "z") // archived before
+	archive.SaveDay(&extra)
+
+	retention := Retention{Before: "2016-04-24", MaxItems: 3, Archive: archive}
+	if _, err := retention.Apply(store, time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{"2016-04-20": {"x", "a", "z"}, "2016-04-25": {"p"}}
+	if archived := storeTitles(archive); !reflect.DeepEqual(archived, expected) {
+		t.Errorf("Expected %v archived, found %v", expected, archived)
+	}
+}
@@ -0,0 +1,303 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the user agent the feeds are requested with
+const UserAgent = "marouenj-rss/1.0 (+https://github.com/marouenj/rss)"
+
+// the token robots.txt files name the crawler with
+const robotsAgent = "marouenj-rss"
+
+// how long robots.txt files are cached, shorter for the ones that couldn't be fetched
+const (
+	robotsTtl      = 24 * time.Hour
+	robotsErrorTtl = time.Hour
+)
+
+// the delay between requests to the same host by default
+const DefaultMinDelay = time.Second
+
+// robots.txt files are parsed up to this many bytes, the rest is ignored
+const maxRobotsSize = 500 << 10
+
+// the rules of a robots.txt file that apply to the crawler
+type robots struct {
+	rules   []robotsRule
+	delay   time.Duration // Crawl-delay
+	expires time.Time
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// parse a robots.txt file, keeping the groups of the agent, or else the ones of '*'
+// the groups of the agent name its product token exactly, case aside
+func parseRobots(data []byte, agent string) *robots {
+	agent = strings.ToLower(agent)
+
+	type group struct {
+		agents []string
+		rules  []robotsRule
+		delay  time.Duration
+	}
+	groups := []*group{}
+
+	var current *group
+	inAgents := false // consecutive user-agent lines share a group
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		idx := strings.Index(line, ":")
+		if idx == -1 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:idx]))
+		value := strings.TrimSpace(line[idx+1:])
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = &group{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if value == "" { // an empty disallow allows everything
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{allow: key == "allow", pattern: value, re: robotsPattern(value)})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.delay = time.Duration(seconds * float64(time.Second))
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	r := &robots{rules: []robotsRule{}}
+	for _, wildcard := range []bool{false, true} {
+		for _, g := range groups {
+			for _, a := range g.agents {
+				if (!wildcard && a == agent) || (wildcard && a == "*") {
+					r.rules = append(r.rules, g.rules...)
+					if g.delay > r.delay {
+						r.delay = g.delay
+					}
+					break
+				}
+			}
+		}
+		if len(r.rules) > 0 || r.delay > 0 {
+			break // groups of the agent take precedence over the ones of '*'
+		}
+	}
+
+	return r
+}
+
+// a robots.txt path pattern, '*' matches anything and a trailing '$' anchors the end
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	for idx, part := range parts {
+		parts[idx] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// whether the rules allow a path, the longest matching rule wins, allow wins ties
+func (r *robots) allows(path string) bool {
+	allowed := true
+	longest := -1
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allowed = rule.allow
+			longest = len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// the error of a url the robots.txt of its host disallows
+type RobotsError struct {
+	Url string
+}
+
+func (e *RobotsError) Error() string {
+	return fmt.Sprintf("[ERR] '%s' is disallowed by robots.txt", e.Url)
+}
+
+// honor robots.txt and space the requests to the same host
+type Politeness struct {
+	Agent    string               // token to look for in robots.txt
+	MinDelay time.Duration        // between requests to the same host, raised by Crawl-delay
+	robots   map[string]*robots   // by host
+	last     map[string]time.Time // next allowed request, by host
+	lock     sync.Mutex
+	now      func() time.Time
+	sleep    func(d time.Duration)
+}
+
+func NewPoliteness() *Politeness {
+	return &Politeness{
+		Agent:    robotsAgent,
+		MinDelay: DefaultMinDelay,
+		robots:   map[string]*robots{},
+		last:     map[string]time.Time{},
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+}
+
+// the rules cached for a host, nil if missing or expired
+func (p *Politeness) cached(host string) *robots {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	r, ok := p.robots[host]
+	if !ok || p.now().After(r.expires) {
+		return nil
+	}
+	return r
+}
+
+func (p *Politeness) store(host string, r *robots) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.robots[host] = r
+}
+
+// wait for the delay since the last request to the host to run out
+func (p *Politeness) wait(host string, crawlDelay time.Duration) {
+	delay := p.MinDelay
+	if crawlDelay > delay {
+		delay = crawlDelay
+	}
+
+	p.lock.Lock()
+	now := p.now()
+	next := p.last[host].Add(delay)
+	if next.Before(now) {
+		next = now
+	}
+	p.last[host] = next
+	p.lock.Unlock()
+
+	if next.After(now) {
+		p.sleep(next.Sub(now))
+	}
+}
+
+// check a url against the robots.txt of its host, then wait for our turn to request it
+func (c *Crawler) polite(u *url.URL) error {
+	p := c.Politeness
+
+	r := p.cached(u.Host)
+	if r == nil {
+		r = c.fetchRobots(u)
+		p.store(u.Host, r)
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !r.allows(path) {
+		return &RobotsError{Url: u.String()}
+	}
+
+	p.wait(u.Host, r.delay)
+	return nil
+}
+
+// the robots.txt of the host of a url, as RFC 9309 reads it
+// missing ones allow everything, the ones that can't be fetched disallow everything, for a shorter while
+func (c *Crawler) fetchRobots(u *url.URL) *robots {
+	p := c.Politeness
+	link := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}).String()
+	none := func(ttl time.Duration) *robots {
+		return &robots{rules: []robotsRule{}, expires: p.now().Add(ttl)}
+	}
+	all := func(ttl time.Duration) *robots {
+		return &robots{rules: []robotsRule{{allow: false, pattern: "/", re: robotsPattern("/")}}, expires: p.now().Add(ttl)}
+	}
+
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return all(robotsErrorTtl)
+	}
+	req.Header.Set("User-Agent", UserAgent)
+
+	p.wait(u.Host, 0)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil { // unreachable
+		return all(robotsErrorTtl)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	if err != nil {
+		return all(robotsErrorTtl)
+	}
+	c.Report.count(u.Host, Traffic{Compressed: int64(len(data)), Decompressed: int64(len(data))})
+
+	switch {
+	case resp.StatusCode >= 500:
+		return all(robotsErrorTtl)
+	case resp.StatusCode != http.StatusOK:
+		return none(robotsTtl)
+	}
+
+	// the line cut short by the limit is dropped
+	if len(data) == maxRobotsSize {
+		if idx := bytes.LastIndexByte(data, '\n'); idx != -1 {
+			data = data[:idx+1]
+		}
+	}
+
+	r := parseRobots(data, p.Agent)
+	r.expires = p.now().Add(robotsTtl)
+	return r
+}
@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseRobots(t *testing.T) {
+	robotsTxt := `# comment
+User-agent: other
+Disallow: /
+
+User-agent: rss
+User-agent: s
+Disallow: /
+
+User-agent: *
+Disallow: /private
+Crawl-delay: 2
+
+User-agent: Marouenj-RSS
+User-agent: another
+Disallow: /feeds/       # trailing comment
+Allow: /feeds/public
+Disallow: /*.php$
+Crawl-delay: 5
+`
+
+	testCases := []struct {
+		agent   string
+		path    string
+		allowed bool
+		delay   time.Duration
+	}{
+		{"marouenj-rss", "/", true, 5 * time.Second},        // the groups of parts of the agent don't apply
+		{"marouenj-rss", "/private", true, 5 * time.Second}, // the group of the agent wins over '*'
+		{"marouenj-rss", "/feeds/news", false, 5 * time.Second},
+		{"marouenj-rss", "/feeds/public/news", true, 5 * time.Second}, // longest match wins
+		{"marouenj-rss", "/index.php", false, 5 * time.Second},
+		{"marouenj-rss", "/index.php?q=1", true, 5 * time.Second}, // anchored at the end
+		{"unknown", "/private/feed", false, 2 * time.Second},
+		{"unknown", "/feeds/news", true, 2 * time.Second},
+	}
+
+	for idx, testCase := range testCases {
+		r := parseRobots([]byte(robotsTxt), testCase.agent)
+		if allowed := r.allows(testCase.path); allowed != testCase.allowed {
+			t.Errorf("[Test case %d] Expected '%s' allowed %v, found %v", idx, testCase.path, testCase.allowed, allowed)
+		}
+		if r.delay != testCase.delay {
+			t.Errorf("[Test case %d] Expected delay %v, found %v", idx, testCase.delay, r.delay)
+		}
+	}
+}
+
+func Test_Politeness_wait(t *testing.T) {
+	now := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	slept := []time.Duration{}
+
+	p := NewPoliteness()
+	p.MinDelay = time.Second
+	p.now = func() time.Time { return now }
+	p.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	testCases := []struct {
+		host    string
+		delay   time.Duration // Crawl-delay
+		elapsed time.Duration // since the previous request
+		slept   time.Duration
+	}{
+		{"a", 0, 0, 0},               // first request to the host
+		{"a", 0, 0, time.Second},     // right after
+		{"b", 0, 0, 0},               // hosts are apart
+		{"a", 0, 3 * time.Second, 0}, // long after
+		{"a", 5 * time.Second, 2 * time.Second, 3 * time.Second}, // crawl-delay longer than the min
+	}
+
+	for idx, testCase := range testCases {
+		now = now.Add(testCase.elapsed)
+		slept = []time.Duration{}
+
+		p.wait(testCase.host, testCase.delay)
+
+		found := time.Duration(0)
+		for _, d := range slept {
+			found += d
+		}
+		if found != testCase.slept {
+			t.Errorf("[Test case %d] Expected to sleep %v, found %v", idx, testCase.slept, found)
+		}
+		now = now.Add(found)
+	}
+}
+
+func Test_Crawler_fetch_robots(t *testing.T) {
+	robotsFetched := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		robotsFetched++
+		fmt.Fprintln(w, "User-agent: marouenj-rss\nDisallow: /private\nCrawl-delay: 3")
+	})
+	feed := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != UserAgent {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintln(w, `<rss><channel><title>News</title><item><title>a</title></item></channel></rss>`)
+	}
+	mux.HandleFunc("/feed", feed)
+	mux.HandleFunc("/private/feed", feed)
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/private/feed", http.StatusFound)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	testCases := []struct {
+		polite bool
+		path   string
+		robots bool
+		items  int
+	}{
+		{false, "/private/feed", false, 1}, // robots.txt ignored
+		{true, "/feed", false, 1},
+		{true, "/private/feed", true, 0},
+		{true, "/redirect", true, 0}, // redirects are checked too
+	}
+
+	slept := time.Duration(0)
+	for idx, testCase := range testCases {
+		crawler, _ := NewCrawler()
+		if testCase.polite {
+			crawler.Politeness = NewPoliteness()
+			crawler.Politeness.sleep = func(d time.Duration) { slept += d }
+		}
+
+		crawler.fetch("owner", Feed{Url: ts.URL + testCase.path})
+
+		rep := crawler.Report.Feeds[feedKey("owner", ts.URL+testCase.path)]
+		if rep.Robots != testCase.robots {
+			t.Errorf("[Test case %d] Expected robots %v, found %v (%s)", idx, testCase.robots, rep.Robots, rep.Error)
+		}
+		if rep.Items != testCase.items {
+			t.Errorf("[Test case %d] Expected %d item(s), found %d (%s)", idx, testCase.items, rep.Items, rep.Error)
+		}
+	}
+
+	if robotsFetched != 3 {
+		t.Errorf("Expected robots.txt fetched once per polite crawler, found %d", robotsFetched)
+	}
+	if slept < 3*time.Second {
+		t.Errorf("Expected the Crawl-delay to be waited, slept %v", slept)
+	}
+}
+
+func Test_Crawler_fetchRobots(t *testing.T) {
+	large := "User-agent: *\nDisallow: /feed\n" + strings.Repeat("# padding\n", maxRobotsSize/10) + "Allow: /feed\n"
+
+	testCases := []struct {
+		status  int
+		body    string
+		allowed bool
+	}{
+		{http.StatusOK, "User-agent: *\nDisallow: /private\n", true},
+		{http.StatusNotFound, "", true},            // missing, everything allowed
+		{http.StatusServiceUnavailable, "", false}, // server error, everything disallowed
+		{http.StatusOK, large, false},              // the first bytes are parsed
+	}
+
+	for idx, testCase := range testCases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(testCase.status)
+			fmt.Fprint(w, testCase.body)
+		}))
+
+		crawler, _ := NewCrawler()
+		crawler.Politeness = NewPoliteness()
+		u, _ := url.Parse(ts.URL + "/feed")
+		if allowed := crawler.fetchRobots(u).allows("/feed"); allowed != testCase.allowed {
+			t.Errorf("[Test case %d] Expected allowed %v, found %v", idx, testCase.allowed, allowed)
+		}
+		ts.Close()
+	}
+
+	// unreachable, everything disallowed
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	crawler, _ := NewCrawler()
+	crawler.Politeness = NewPoliteness()
+	u, _ := url.Parse(ts.URL + "/feed")
+	if crawler.fetchRobots(u).allows("/feed") {
+		t.Errorf("Expected an unreachable host to disallow everything")
+	}
+}
+
+func Test_Politeness_cached(t *testing.T) {
+	robotsFetched := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetched++
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, `<rss><channel><title>News</title></channel></rss>`)
+	}))
+	defer ts.Close()
+
+	now := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	crawler, _ := NewCrawler()
+	crawler.Politeness = NewPoliteness()
+	crawler.Politeness.now = func() time.Time { return now }
+	crawler.Politeness.sleep = func(d time.Duration) { now = now.Add(d) }
+
+	testCases := []struct {
+		elapsed time.Duration
+		fetched int
+	}{
+		{0, 1},
+		{time.Hour, 1},      // cached
+		{24 * time.Hour, 2}, // expired
+	}
+
+	for idx, testCase := range testCases {
+		now = now.Add(testCase.elapsed)
+		crawler.fetch("owner", Feed{Url: ts.URL + "/feed"})
+
+		rep := crawler.Report.Feeds[feedKey("owner", ts.URL+"/feed")]
+		if rep.Error != "" {
+			t.Errorf("[Test case %d] Expected a missing robots.txt to allow everything, found '%s'", idx, rep.Error)
+		}
+		if robotsFetched != testCase.fetched {
+			t.Errorf("[Test case %d] Expected robots.txt fetched %d time(s), found %d", idx, testCase.fetched, robotsFetched)
+		}
+	}
+}
@@ -1,11 +1,7 @@
 package agent
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 
@@ -122,15 +118,24 @@ func (d *Days) AddItem(item Item, date string, ownerId string, channelTitle stri
 // agent that's responsible for merging new feeds with existing ones
 // then persisting them back to disk
 type Marshaller struct {
-	Days *Days
-	dir  string // dir to load from/save to
+	Days  *Days
+	Store Store // where the days are loaded from/saved to
 }
 
-// init a new agent
+// init a new agent, persisting to a file per day in dir
 func NewMarshaller(dir string) (*Marshaller, error) {
+	return NewStoreMarshaller(NewDirStore(dir))
+}
+
+// init a new agent, persisting to a store
+func NewStoreMarshaller(store Store) (*Marshaller, error) {
+	if store == nil {
+		return nil, fmt.Errorf("[ERR] 'store' is nil")
+	}
+
 	return &Marshaller{
-		Days: &Days{},
-		dir:  dir,
+		Days:  &Days{},
+		Store: store,
 	}, nil
 }
 
@@ -144,7 +149,7 @@ func (m *Marshaller) ReArrange(channels Channels) error {
 		for _, item := range *channel.Items {
 			date, err := util.ParsePubDate(item.Date)
 			if err != nil {
-				fmt.Printf("[ERR] Unable to parse date '%s': %v", item.Date, err)
+				logf("[ERR] Unable to parse date '%s': %v\n", item.Date, err)
 				continue
 			}
 
@@ -156,60 +161,88 @@ func (m *Marshaller) ReArrange(channels Channels) error {
 }
 
 // for each day...
-// previous data is loaded from disk, if exists
+// previous data is loaded from the store, if exists
 // current data is then merged with previous data
-// the whole is persisted back to disk
+// the whole is persisted back to the store
 // merging operation insures no duplicates in 'owner', 'channel' and 'item' levels
 // cleaning operation insures entries are sorted by 'owner', 'channel' and 'item'
+// the store is locked meanwhile, so that overlapping runs don't lose each other's items
+// day files whose content is left unchanged aren't rewritten, their mtime tells when they last changed
 func (m *Marshaller) Save() error {
+	unlock, err := lockStore(m.Store)
+	if err != nil {
+		return err // already formatted
+	}
+	defer unlock()
+
 	for _, src := range *m.Days {
-		dest, err := m.load(src.Date)
-		if err != nil {
+		if err := m.saveDay(src); err != nil {
 			return err // already formatted
 		}
+	}
 
-		merge(*src, *dest)
-		clean(*dest)
+	return nil
+}
 
-		// persist back to disk
-		bytes, err := json.Marshal(*dest)
-		if err != nil {
-			return fmt.Errorf("[ERR] Unable to marshal: %v", err)
-		}
+// read, merge then write a day, with the day locked
+// with the days split per owner, only the files of the owners of the day are read and written
+func (m *Marshaller) saveDay(src *Day) error {
+	unlock, err := lockDay(m.Store, src.Date)
+	if err != nil {
+		return err // already formatted
+	}
+	defer unlock()
 
-		path := filepath.Join(m.dir, src.Date)
-		err = ioutil.WriteFile(path, bytes, 0666)
+	owned, ok := m.Store.(*OwnerStore)
+	if !ok {
+		return mergeDay(m.Store, src)
+	}
+
+	for _, owner := range *src.Owners {
+		store, err := owned.create(owner.Id)
 		if err != nil {
-			return fmt.Errorf("[ERR] Unable to write to '%s': %v", path, err)
+			return err // already formatted
+		}
+		if err := mergeDay(store, &Day{Date: src.Date, Owners: &Owners{owner}}); err != nil {
+			return err // already formatted
 		}
 	}
-
 	return nil
 }
 
+// merge a day with the one of the store, then write it back
+func mergeDay(store Store, src *Day) error {
+	dest, err := loadDay(store, src.Date)
+	if err != nil {
+		return err // already formatted
+	}
+
+	merge(*src, *dest)
+	clean(*dest)
+
+	return store.SaveDay(dest)
+}
+
 func (m *Marshaller) load(date string) (*Day, error) {
-	path := filepath.Join(m.dir, date)
+	return loadDay(m.Store, date)
+}
 
-	// file for this date hasn't been initialized yet
-	if _, err := os.Stat(path); err != nil {
+// the day of a store, empty if there's none yet
+func loadDay(store Store, date string) (*Day, error) {
+	day, err := store.LoadDay(date)
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	// day hasn't been initialized yet
+	if day == nil {
 		return &Day{
 			Date:   date,
 			Owners: &Owners{},
 		}, nil
 	}
 
-	file, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
-	}
-
-	var day Day
-	err = json.Unmarshal(file, &day)
-	if err != nil {
-		return nil, fmt.Errorf("[ERR] Unable to unmarshal '%s': %v", path, err)
-	}
-
-	return &day, nil
+	return day, nil
 }
 
 func merge(src, dest Day) {
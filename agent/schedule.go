@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marouenj/rss/util"
+)
+
+// bounds of the poll intervals
+const (
+	DefaultInterval = time.Hour
+	MinInterval     = 5 * time.Minute
+	MaxInterval     = 24 * time.Hour
+)
+
+// feeds due at startup are spread over this window, avoiding a thundering herd
+const startupSpread = 5 * time.Minute
+
+// poll state of a feed, persisted between runs
+type FeedState struct {
+	Owner       string        `json:"owner"`
+	Url         string        `json:"url"` // with the secrets hidden, so that they aren't written to disk, for display only
+	Next        time.Time     `json:"next"`
+	Last        time.Time     `json:"last"`
+	Hint        time.Duration `json:"hint,omitempty"`        // interval advertised by the channel
+	Quiet       int           `json:"quiet,omitempty"`       // consecutive polls with nothing new
+	Failures    int           `json:"failures,omitempty"`    // consecutive failed polls
+	Fingerprint string        `json:"fingerprint,omitempty"` // of the items of the last poll
+	key         string        // in the schedule
+}
+
+// the feed as shown in the logs
+func (s *FeedState) name() string {
+	return feedKey(s.Owner, s.Url)
+}
+
+// a feed is identified by its owner and url
+func feedKey(owner, url string) string {
+	return owner + " " + url
+}
+
+// a feed is scheduled by its owner and a hash of its url, as expanded
+// so that feeds differing by a secret only are told apart, with no secret written to disk
+func scheduleKey(owner, url string) string {
+	return fmt.Sprintf("%s %x", owner, sha256.Sum256([]byte(url)))
+}
+
+// a feed as shown in the logs, with the secrets of its url hidden
+func feedName(owner, url string) string {
+	return feedKey(owner, Redact(url))
+}
+
+// the schedule of the feeds polled by the daemon
+type Schedule struct {
+	Feeds           map[string]*FeedState `json:"feeds"`
+	DefaultInterval time.Duration         `json:"-"`
+	MinInterval     time.Duration         `json:"-"`
+	MaxInterval     time.Duration         `json:"-"`
+	path            string                // file to load from/save to
+}
+
+// init a schedule, restoring the state saved to path if any
+func NewSchedule(path string) (*Schedule, error) {
+	s := &Schedule{
+		Feeds:           map[string]*FeedState{},
+		DefaultInterval: DefaultInterval,
+		MinInterval:     MinInterval,
+		MaxInterval:     MaxInterval,
+		path:            path,
+	}
+
+	file, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) { // first run
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+	}
+
+	err = json.Unmarshal(file, s)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to unmarshal '%s': %v", path, err)
+	}
+	if s.Feeds == nil {
+		s.Feeds = map[string]*FeedState{}
+	}
+	for key, state := range s.Feeds {
+		state.key = key
+	}
+
+	return s, nil
+}
+
+// persist the schedule, the previous state is replaced at once
+func (s *Schedule) Save() error {
+	bytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to marshal: %v", err)
+	}
+
+	return util.WriteFileAtomic(s.path, bytes, 0666)
+}
+
+// track the enabled feeds of the groups, forget about the others
+// new feeds are due within the startup window
+// returns the names of the added and removed feeds
+func (s *Schedule) Sync(groups ChannelGroups, now time.Time) ([]string, []string) {
+	keys := map[string]bool{}
+	added := []string{}
+	for _, group := range groups {
+		for _, feed := range group.Channels {
+			if !feed.IsEnabled() {
+				continue
+			}
+
+			key := scheduleKey(group.Owner, feed.Url)
+			keys[key] = true
+			if _, ok := s.Feeds[key]; ok {
+				continue
+			}
+
+			state := &FeedState{
+				Owner: group.Owner,
+				Url:   Redact(feed.Url),
+				Next:  now.Add(spread(key, startupSpread)),
+				key:   key,
+			}
+			s.Feeds[key] = state
+			added = append(added, state.name())
+		}
+	}
+
+	removed := []string{}
+	for key, state := range s.Feeds {
+		if !keys[key] {
+			delete(s.Feeds, key)
+			removed = append(removed, state.name())
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// spread the feeds overdue at startup over the startup window
+func (s *Schedule) Spread(now time.Time) {
+	for key, state := range s.Feeds {
+		if state.Next.Before(now) {
+			state.Next = now.Add(spread(key, startupSpread))
+		}
+	}
+}
+
+// the feeds due at a given time, the most overdue first
+func (s *Schedule) Due(now time.Time) []*FeedState {
+	due := []*FeedState{}
+	for _, state := range s.Feeds {
+		if !state.Next.After(now) {
+			due = append(due, state)
+		}
+	}
+
+	sort.Sort(byNext(due))
+	return due
+}
+
+// when the next feed is due, zero if there is no feed
+func (s *Schedule) NextWake() time.Time {
+	var next time.Time
+	for _, state := range s.Feeds {
+		if next.IsZero() || state.Next.Before(next) {
+			next = state.Next
+		}
+	}
+	return next
+}
+
+// record the outcome of a poll and schedule the next one
+func (s *Schedule) Update(state *FeedState, feed Feed, channels Channels, err error, now time.Time) {
+	state.Last = now
+
+	if err != nil {
+		state.Failures++
+	} else {
+		state.Failures = 0
+		state.Hint = hint(channels)
+
+		fingerprint := fingerprint(channels)
+		if fingerprint == state.Fingerprint {
+			state.Quiet++
+		} else {
+			state.Quiet = 0
+		}
+		state.Fingerprint = fingerprint
+	}
+
+	state.Next = now.Add(s.Interval(feed, state))
+}
+
+// the interval until the next poll of a feed
+// the configured interval (or the default one) is raised to the interval advertised by the channel
+// it's then doubled for each quiet or failed poll in a row, within the bounds of the schedule
+func (s *Schedule) Interval(feed Feed, state *FeedState) time.Duration {
+	interval := s.DefaultInterval
+	if configured, err := feed.PollInterval(); err == nil && configured > 0 {
+		interval = configured
+	}
+	if state.Hint > interval {
+		interval = state.Hint
+	}
+
+	for idx := 0; idx < state.Quiet+state.Failures && interval < s.MaxInterval; idx++ {
+		interval *= 2
+	}
+
+	if interval < s.MinInterval {
+		interval = s.MinInterval
+	}
+	if interval > s.MaxInterval {
+		interval = s.MaxInterval
+	}
+
+	return interval
+}
+
+// the update interval advertised by the channels, through 'ttl' or 'sy:updatePeriod'
+func hint(channels Channels) time.Duration {
+	var interval time.Duration
+	for _, channel := range channels {
+		if d := time.Duration(channel.Ttl) * time.Minute; d > interval {
+			interval = d
+		}
+
+		var period time.Duration
+		switch strings.TrimSpace(channel.UpdatePeriod) {
+		case "hourly":
+			period = time.Hour
+		case "daily":
+			period = 24 * time.Hour
+		case "weekly":
+			period = 7 * 24 * time.Hour
+		case "monthly":
+			period = 30 * 24 * time.Hour
+		case "yearly":
+			period = 365 * 24 * time.Hour
+		}
+		if channel.UpdateFrequency > 1 {
+			period /= time.Duration(channel.UpdateFrequency)
+		}
+		if period > interval {
+			interval = period
+		}
+	}
+	return interval
+}
+
+// identify the items of a poll, to tell whether a feed has been updated
+func fingerprint(channels Channels) string {
+	lines := []string{}
+	for _, channel := range channels {
+		if channel.Items == nil {
+			continue
+		}
+		for _, item := range *channel.Items {
+			lines = append(lines, item.Title+"\n"+item.Link)
+		}
+	}
+	sort.Strings(lines)
+
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(lines, "\n"))))
+}
+
+// a stable offset within a window, derived from the key
+func spread(key string, window time.Duration) time.Duration {
+	sum := sha1.Sum([]byte(key))
+	fraction := float64(binary.BigEndian.Uint32(sum[:4])) / float64(^uint32(0))
+	return time.Duration(fraction * float64(window))
+}
+
+// sort feed states by their next poll
+type byNext []*FeedState
+
+func (b byNext) Len() int {
+	return len(b)
+}
+func (b byNext) Less(i, j int) bool {
+	return b[i].Next.Before(b[j].Next)
+}
+func (b byNext) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
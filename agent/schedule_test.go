@@ -0,0 +1,190 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Interval(t *testing.T) {
+	testCases := []struct {
+		feed     Feed
+		state    FeedState
+		interval time.Duration
+	}{
+		{ // test case 0, default
+			Feed{}, FeedState{}, DefaultInterval,
+		},
+		{ // test case 1, configured
+			Feed{Interval: "2h"}, FeedState{}, 2 * time.Hour,
+		},
+		{ // test case 2, configured, raised by the channel hint
+			Feed{Interval: "2h"}, FeedState{Hint: 3 * time.Hour}, 3 * time.Hour,
+		},
+		{ // test case 3, quiet feed backs off
+			Feed{Interval: "2h"}, FeedState{Quiet: 2}, 8 * time.Hour,
+		},
+		{ // test case 4, failing feed backs off, within bounds
+			Feed{Interval: "2h"}, FeedState{Failures: 10}, MaxInterval,
+		},
+		{ // test case 5, within bounds
+			Feed{Interval: "1m"}, FeedState{}, MinInterval,
+		},
+	}
+
+	schedule, _ := NewSchedule(filepath.Join(os.TempDir(), "none"))
+	for idx, testCase := range testCases {
+		if interval := schedule.Interval(testCase.feed, &testCase.state); interval != testCase.interval {
+			t.Errorf("[Test case %d], expected %v, got %v", idx, testCase.interval, interval)
+		}
+	}
+}
+
+func Test_hint(t *testing.T) {
+	testCases := []struct {
+		channel Channel
+		hint    time.Duration
+	}{
+		{Channel{}, 0},
+		{Channel{Ttl: 90}, 90 * time.Minute},
+		{Channel{UpdatePeriod: "daily"}, 24 * time.Hour},
+		{Channel{UpdatePeriod: "daily", UpdateFrequency: 4}, 6 * time.Hour},
+		{Channel{Ttl: 600, UpdatePeriod: "hourly"}, 10 * time.Hour},
+	}
+
+	for idx, testCase := range testCases {
+		channel := testCase.channel
+		if hint := hint(Channels{&channel}); hint != testCase.hint {
+			t.Errorf("[Test case %d], expected %v, got %v", idx, testCase.hint, hint)
+		}
+	}
+}
+
+func Test_Schedule(t *testing.T) {
+	// create temp dir
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "schedule.json")
+	now := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	disabled := false
+
+	schedule, err := NewSchedule(path)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// new feeds are spread over the startup window
+	groups := ChannelGroups{
+		ChannelGroup{
+			Owner: "wsj",
+			Channels: Feeds{
+				Feed{Url: "http://www.wsj.com/xml/rss/3_7014.xml"},
+				Feed{Url: "http://www.wsj.com/xml/rss/3_7085.xml", Interval: "2h"},
+				Feed{Url: "http://www.wsj.com/xml/rss/3_7041.xml", Enabled: &disabled},
+			},
+		},
+	}
+	added, removed := schedule.Sync(groups, now)
+	expected := []string{"wsj http://www.wsj.com/xml/rss/3_7014.xml", "wsj http://www.wsj.com/xml/rss/3_7085.xml"}
+	if !reflect.DeepEqual(added, expected) || len(removed) != 0 {
+		t.Errorf("expected %v added, got %v added and %v removed", expected, added, removed)
+	}
+	for key, state := range schedule.Feeds {
+		if state.Next.Before(now) || state.Next.After(now.Add(startupSpread)) {
+			t.Errorf("'%s' scheduled out of the startup window at %v", key, state.Next)
+		}
+	}
+	if len(schedule.Due(now.Add(startupSpread))) != 2 {
+		t.Errorf("expected all the feeds to be due after the startup window")
+	}
+
+	// quiet polls back off
+	key := scheduleKey("wsj", "http://www.wsj.com/xml/rss/3_7085.xml")
+	state := schedule.Feeds[key]
+	channels := Channels{&Channel{Items: &Items{&Item{Title: "a", Link: "http://a"}}}}
+	schedule.Update(state, groups[0].Channels[1], channels, nil, now)
+	if state.Next != now.Add(2*time.Hour) || state.Quiet != 0 {
+		t.Errorf("expected next poll in 2h, got %v (quiet %d)", state.Next.Sub(now), state.Quiet)
+	}
+	schedule.Update(state, groups[0].Channels[1], channels, nil, now)
+	if state.Next != now.Add(4*time.Hour) || state.Quiet != 1 {
+		t.Errorf("expected next poll in 4h, got %v (quiet %d)", state.Next.Sub(now), state.Quiet)
+	}
+
+	// the state survives a restart, overdue feeds are spread
+	err = schedule.Save()
+	if err != nil {
+		t.Error(err)
+	}
+	restored, err := NewSchedule(path)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(restored.Feeds[key].Fingerprint, state.Fingerprint) || !restored.Feeds[key].Next.Equal(state.Next) {
+		t.Errorf("expected %+v, got %+v", state, restored.Feeds[key])
+	}
+	later := now.Add(48 * time.Hour)
+	restored.Spread(later)
+	if len(restored.Due(later)) != 0 {
+		t.Errorf("expected no feed to be due right after a restart")
+	}
+
+	// removed feeds are forgotten
+	added, removed = restored.Sync(ChannelGroups{ChannelGroup{Owner: "wsj", Channels: groups[0].Channels[:1]}}, later)
+	if len(added) != 0 || !reflect.DeepEqual(removed, expected[1:]) {
+		t.Errorf("expected %v removed, got %v added and %v removed", expected[1:], added, removed)
+	}
+}
+
+func Test_Schedule_secrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "schedule.json")
+	schedule, err := NewSchedule(path)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// as expanded from ${secret:...}
+	registerSecret("sch3dule-t0ken")
+	groups := ChannelGroups{
+		ChannelGroup{Owner: "gitlab", Channels: Feeds{Feed{Url: "https://gitlab.example.com/projects.atom?feed_token=sch3dule-t0ken"}}},
+	}
+	added, _ := schedule.Sync(groups, time.Now())
+	expected := []string{"gitlab https://gitlab.example.com/projects.atom?feed_token=****"}
+	if !reflect.DeepEqual(added, expected) {
+		t.Errorf("Expected %v added, found %v", expected, added)
+	}
+
+	if err := schedule.Save(); err != nil {
+		t.Error(err)
+	}
+	if data, _ := ioutil.ReadFile(path); strings.Contains(string(data), "sch3dule-t0ken") {
+		t.Errorf("Expected the secret hidden, found %s", data)
+	}
+
+	// the state is found again after a restart
+	restored, _ := NewSchedule(path)
+	if added, removed := restored.Sync(groups, time.Now()); len(added) != 0 || len(removed) != 0 {
+		t.Errorf("Expected the feed still scheduled, found %v added and %v removed", added, removed)
+	}
+
+	// feeds differing by a secret only are both scheduled
+	registerSecret("sch3dule-0ther")
+	groups[0].Channels = append(groups[0].Channels, Feed{Url: "https://gitlab.example.com/projects.atom?feed_token=sch3dule-0ther"})
+	if added, _ := restored.Sync(groups, time.Now()); !reflect.DeepEqual(added, expected) || len(restored.Feeds) != 2 {
+		t.Errorf("Expected %v added, found %v", expected, added)
+	}
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// dir holding one file per secret, e.g. docker secrets mounted in /run/secrets
+var SecretsDir = ""
+
+const secretPrefix = "secret:"
+
+// what expanded values are replaced with in logs
+const redactedValue = "****"
+
+// expand the references in a string of a channels file
+// ${VAR} is replaced by the environment variable VAR
+// ${secret:NAME} is replaced by the content of the file NAME of the secrets dir
+// $${ stands for a literal ${
+// expanded values are redacted from the logs
+func expand(s string) (string, error) {
+	return expandWith(s, lookup)
+}
+
+func expandWith(s string, lookup func(key string) (string, error)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var out []byte
+	for idx := 0; idx < len(s); idx++ {
+		if strings.HasPrefix(s[idx:], "$${") { // escaped
+			out = append(out, "${"...)
+			idx += 2
+			continue
+		}
+
+		if !strings.HasPrefix(s[idx:], "${") {
+			out = append(out, s[idx])
+			continue
+		}
+
+		end := strings.Index(s[idx:], "}")
+		if end == -1 {
+			return "", fmt.Errorf("Unterminated reference in '%s'", Redact(s))
+		}
+
+		key := s[idx+2 : idx+end]
+		if key == "" || key == secretPrefix {
+			return "", fmt.Errorf("Empty reference in '%s'", Redact(s))
+		}
+
+		value, err := lookup(key)
+		if err != nil {
+			return "", err
+		}
+
+		out = append(out, value...)
+		idx += end
+	}
+
+	return string(out), nil
+}
+
+// resolve a reference, registering its value as a secret
+func lookup(key string) (string, error) {
+	var value string
+
+	if strings.HasPrefix(key, secretPrefix) {
+		name := strings.TrimPrefix(key, secretPrefix)
+		if SecretsDir == "" {
+			return "", fmt.Errorf("Unable to read secret '%s', no secrets dir", name)
+		}
+		if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+			return "", fmt.Errorf("Invalid secret name '%s'", name)
+		}
+
+		bytes, err := ioutil.ReadFile(filepath.Join(SecretsDir, name))
+		if err != nil {
+			return "", fmt.Errorf("Unable to read secret '%s': %v", name, err)
+		}
+		value = strings.TrimRight(string(bytes), "\r\n")
+	} else {
+		var ok bool
+		value, ok = os.LookupEnv(key)
+		if !ok {
+			return "", fmt.Errorf("Undefined variable '%s'", key)
+		}
+	}
+
+	registerSecret(value)
+	return value, nil
+}
+
+// expand the references of the urls and options of the channels
+// the credentials are copied, the raw ones keep their references
+func (cg ChannelGroups) expand() error {
+	for _, group := range cg {
+		for idx, _ := range group.Channels {
+			feed := &group.Channels[idx]
+
+			var err error
+			if feed.Url, err = expand(feed.Url); err != nil {
+				return err
+			}
+			if feed.Name, err = expand(feed.Name); err != nil {
+				return err
+			}
+			if feed.Auth != nil {
+				if feed.Auth, err = feed.Auth.expand(); err != nil {
+					return err
+				}
+			}
+
+			if len(feed.Headers) == 0 {
+				continue
+			}
+			headers := make(map[string]string, len(feed.Headers))
+			for key, value := range feed.Headers {
+				if headers[key], err = expand(value); err != nil {
+					return err
+				}
+			}
+			feed.Headers = headers
+		}
+	}
+
+	return nil
+}
+
+// values to hide from the logs
+var secrets = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// every value is hidden, however short, but the empty one
+func registerSecret(value string) {
+	if value == "" {
+		return
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	// secrets often end up in urls, hide their escaped form too
+	for _, v := range []string{value, url.QueryEscape(value), url.PathEscape(value)} {
+		known := false
+		for _, existing := range secrets.values {
+			if existing == v {
+				known = true
+				break
+			}
+		}
+		if !known {
+			secrets.values = append(secrets.values, v)
+		}
+	}
+
+	// longer values first, the ones they contain would show them partly
+	sort.SliceStable(secrets.values, func(i, j int) bool {
+		return len(secrets.values[i]) > len(secrets.values[j])
+	})
+}
+
+// hide the values of the expanded references
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+
+	for _, value := range secrets.values {
+		s = strings.Replace(s, value, redactedValue, -1)
+	}
+	return s
+}
+
+// print to the standard output, with the secrets hidden
+func logf(format string, args ...interface{}) {
+	fmt.Print(Redact(fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_expand(t *testing.T) {
+	// create temp secrets dir
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "gitlab_token"), []byte("s3cr3t-t0ken\n"), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+
+	SecretsDir = dir
+	defer func() { SecretsDir = "" }()
+	os.Setenv("RSS_TEST_HOST", "gitlab.example.com")
+	defer os.Unsetenv("RSS_TEST_HOST")
+
+	testCases := []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{ // test case 0, nothing to expand
+			"http://www.wsj.com/xml/rss/3_7085.xml", "http://www.wsj.com/xml/rss/3_7085.xml", false,
+		},
+		{ // test case 1, variable and secret
+			"https://${RSS_TEST_HOST}/dashboard/projects.atom?feed_token=${secret:gitlab_token}",
+			"https://gitlab.example.com/dashboard/projects.atom?feed_token=s3cr3t-t0ken",
+			false,
+		},
+		{ // test case 2, escaped
+			"http://example.com/$${RSS_TEST_HOST}", "http://example.com/${RSS_TEST_HOST}", false,
+		},
+		{ // test case 3, undefined variable
+			"http://${RSS_TEST_UNDEFINED}/", "", true,
+		},
+		{ // test case 4, unknown secret
+			"http://example.com/?token=${secret:unknown}", "", true,
+		},
+		{ // test case 5, secret outside of the secrets dir
+			"http://example.com/?token=${secret:../gitlab_token}", "", true,
+		},
+		{ // test case 6, unterminated
+			"http://example.com/${RSS_TEST_HOST", "", true,
+		},
+	}
+
+	for idx, testCase := range testCases {
+		out, err := expand(testCase.in)
+		if (err != nil) != testCase.err {
+			t.Errorf("[Test case %d], expected error %v, got %v", idx, testCase.err, err)
+		}
+
+		if out != testCase.out {
+			t.Errorf("[Test case %d], expected '%s', got '%s'", idx, testCase.out, out)
+		}
+	}
+
+	// expanded values are redacted
+	redacted := Redact("GET https://gitlab.example.com/dashboard/projects.atom?feed_token=s3cr3t-t0ken")
+	expected := "GET https://****/dashboard/projects.atom?feed_token=****"
+	if redacted != expected {
+		t.Errorf("expected '%s', got '%s'", expected, redacted)
+	}
+
+	// short values are hidden too, longer ones first so that none is partly shown
+	registerSecret("k3y")
+	registerSecret("long-k3y-value")
+	redacted = Redact("?a=k3y&b=long-k3y-value")
+	expected = "?a=****&b=****"
+	if redacted != expected {
+		t.Errorf("expected '%s', got '%s'", expected, redacted)
+	}
+}
+
+func Test_NewChannelGroups_expand(t *testing.T) {
+	os.Setenv("RSS_TEST_TOKEN", "t0ken")
+	defer os.Unsetenv("RSS_TEST_TOKEN")
+
+	// create temp dir
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	json := `
+	[
+		{
+			"owner": "gitlab",
+			"channels": [
+				"https://gitlab.example.com/activity.atom?feed_token=${RSS_TEST_TOKEN}",
+				{"url": "https://news.example.com/rss", "headers": {"Authorization": "Bearer ${RSS_TEST_TOKEN}"}}
+			]
+		}
+	]
+	`
+	err = ioutil.WriteFile(filepath.Join(dir, "file.json"), []byte(json), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// under test
+	groups, err := NewChannelGroups(dir, "file.json")
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := ChannelGroups{
+		ChannelGroup{
+			Owner: "gitlab",
+			Channels: Feeds{
+				Feed{Url: "https://gitlab.example.com/activity.atom?feed_token=t0ken"},
+				Feed{Url: "https://news.example.com/rss", Headers: map[string]string{"Authorization": "Bearer t0ken"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(*groups, expected) {
+		t.Errorf("expected %+v, got %+v", expected, *groups)
+	}
+
+	// undefined variable
+	os.Unsetenv("RSS_TEST_TOKEN")
+	_, err = NewChannelGroups(dir, "file.json")
+	if err == nil {
+		t.Errorf("expected an error for an undefined variable")
+	}
+}
@@ -0,0 +1,495 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marouenj/rss/util"
+)
+
+// where the days are persisted
+type Store interface {
+	// the day of a date, nil if none
+	LoadDay(date string) (*Day, error)
+	// replace the day of its date
+	SaveDay(day *Day) error
+	RemoveDay(date string) error
+	// the dates of the days persisted so far, in order
+	Dates() ([]string, error)
+	// call fn for each item of the days from since to until, inclusive, empty ones are open
+	Items(since, until string, fn ItemFunc) error
+}
+
+type ItemFunc func(date string, owner *Owner, channel *Channel, item *Item)
+
+// stores able to select the items of an owner or a channel without walking every day
+type IndexedStore interface {
+	Store
+	OwnerItems(owner, since, until string, fn ItemFunc) error
+	ChannelItems(channel, since, until string, fn ItemFunc) error
+}
+
+// walk the items of the days of a store, by date, owner, channel then title
+// for the stores with no better way
+func eachItem(s Store, since, until string, fn ItemFunc) error {
+	dates, err := s.Dates()
+	if err != nil {
+		return err // already formatted
+	}
+
+	for _, date := range dates {
+		if !(Query{Since: since, Until: until}).keepsDate(date) {
+			continue
+		}
+
+		day, err := s.LoadDay(date)
+		if err != nil {
+			return err // already formatted
+		}
+		if day == nil || day.Owners == nil {
+			continue
+		}
+
+		for _, owner := range *day.Owners {
+			if owner.Channels == nil {
+				continue
+			}
+			for _, channel := range *owner.Channels {
+				if channel.Items == nil {
+					continue
+				}
+				for _, item := range *channel.Items {
+					fn(date, owner, channel, item)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// stores whose days may be damaged, e.g. by a crash of another version
+type CheckedStore interface {
+	Store
+	Check() ([]DayProblem, error)
+	Quarantine(date string) (string, error)
+}
+
+// a json file per day, named after its date, compressed or not, see dayfile.go
+// older days may be rolled into an archive per month
+// writers lock the dir, then the days they rewrite, see lock.go
+type DirStore struct {
+	dir          string
+	lockDir      string              // where the locks are, the dir unless shared with other stores
+	Compression  string              // of the day files written, gzip or zstd, none if empty
+	JsonSuffix   bool                // whether the uncompressed day files are named <date>.json rather than <date>
+	Pretty       bool                // whether the day files are indented, one item per block, for diffs
+	LockTimeout  time.Duration       // how long to wait for a lock held by another process
+	StaleAfter   time.Duration       // locks held longer are taken over
+	archives     map[string]archived // by path, as read last
+	archivesLock sync.Mutex
+}
+
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{
+		dir:         dir,
+		lockDir:     dir,
+		LockTimeout: DefaultLockTimeout,
+		StaleAfter:  DefaultStaleAfter,
+	}
+}
+
+// the day file is read first, then the archive of the month
+func (s *DirStore) LoadDay(date string) (*Day, error) {
+	path := s.dayFile(date)
+
+	// file for this date hasn't been initialized yet
+	if path == "" {
+		return s.archivedDay(date)
+	}
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+	}
+
+	day, problem := parseDayFile(path, file)
+	if problem != "" {
+		return nil, fmt.Errorf("[ERR] Unable to load '%s': %s", path, problem)
+	}
+
+	return day, nil
+}
+
+// the day of a file, compressed or not, or what's wrong with it
+func parseDayFile(path string, file []byte) (*Day, string) {
+	data, err := decompressDay(path, file)
+	if err == io.ErrUnexpectedEOF {
+		return nil, "truncated"
+	}
+	if err != nil {
+		return nil, fmt.Sprintf("unparsable, %v", err)
+	}
+	return parseDay(data)
+}
+
+// the day of a file, or what's wrong with it
+// days of an older schema are migrated, see migrate.go
+func parseDay(data []byte) (*Day, string) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, "empty"
+	}
+
+	version, err := dayVersion(data)
+	if err != nil {
+		return nil, jsonProblem(data, err)
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Sprintf("%s, version %d", newerSchema, version)
+	}
+	if version < SchemaVersion {
+		if data, err = migrateDay(data, version); err != nil {
+			return nil, fmt.Sprintf("unparsable, %v", err)
+		}
+	}
+
+	var day Day
+	if err := json.Unmarshal(data, &day); err != nil {
+		return nil, jsonProblem(data, err)
+	}
+	return &day, ""
+}
+
+// what's wrong with some json, as told by the error unmarshalling it
+func jsonProblem(data []byte, err error) string {
+	// cut short, e.g. by a crash or a full disk
+	if e, ok := err.(*json.SyntaxError); ok && e.Offset >= int64(len(data)) || err == io.ErrUnexpectedEOF {
+		return "truncated"
+	}
+	return fmt.Sprintf("unparsable, %v", err)
+}
+
+// whether a day file was written by another version, of another schema or with other fields than the current ones
+func schemaChanged(data []byte) bool {
+	if version, err := dayVersion(data); err != nil || version != SchemaVersion {
+		return true
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(&stampedDay{Day: &Day{}}) != nil
+}
+
+// the file is replaced as a whole, a crash leaves the previous version
+// the previous version is backed up first if written by another schema
+// the file is written with the compression of the store, the one of another compression is removed
+func (s *DirStore) SaveDay(day *Day) error {
+	return s.saveDayAs(day, s.Compression)
+}
+
+func (s *DirStore) saveDayAs(day *Day, compression string) error {
+	encode := encodeDay
+	if s.Pretty {
+		encode = indentDay
+	}
+	data, err := encode(day)
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to marshal: %v", err)
+	}
+
+	suffix, ok := compressions[compression]
+	if !ok && compression != "" {
+		return fmt.Errorf("[ERR] Unknown compression '%s'", compression)
+	}
+	if compression == "" && s.JsonSuffix {
+		suffix = jsonSuffix
+	}
+	path := filepath.Join(s.dir, day.Date+suffix)
+
+	previousPath := s.dayFile(day.Date)
+	if previousPath != "" {
+		file, err := readDayFile(previousPath)
+		if err == nil && previousPath == path && bytes.Equal(file, data) {
+			return nil // unchanged, left as is so that its mtime tells when it last changed
+		}
+		if err == nil && schemaChanged(file) {
+			backup := filepath.Join(s.dir, day.Date) + backupSuffix
+			if err := util.WriteFileAtomic(backup, file, 0666); err != nil {
+				return err // already formatted
+			}
+			logf("[INF] Backed up '%s' to '%s', written by another schema\n", previousPath, backup)
+		}
+	}
+
+	data, err = compressDay(compression, data)
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to compress '%s': %v", path, err)
+	}
+	if err := util.WriteFileAtomic(path, data, 0666); err != nil {
+		return err // already formatted
+	}
+
+	if previousPath != "" && previousPath != path {
+		if err := os.Remove(previousPath); err != nil {
+			return fmt.Errorf("[ERR] Unable to remove '%s': %v", previousPath, err)
+		}
+	}
+	return nil
+}
+
+// suffixes of the files set aside next to the day files
+const (
+	backupSuffix  = ".bak"
+	corruptSuffix = ".corrupt"
+)
+
+// a day file that can't be loaded
+type DayProblem struct {
+	Date    string `json:"date"`
+	Path    string `json:"path"`
+	Problem string `json:"problem"` // e.g. 'truncated'
+}
+
+func (p DayProblem) String() string {
+	return fmt.Sprintf("'%s' is %s", p.Path, p.Problem)
+}
+
+// find the day files that are truncated or can't be parsed
+// archived days are left out, as are the days of a newer schema
+func (s *DirStore) Check() ([]DayProblem, error) {
+	dates, err := s.Dates()
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	problems := []DayProblem{}
+	for _, date := range dates {
+		path := s.dayFile(date)
+		if path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+		}
+
+		if _, problem := parseDayFile(path, data); problem != "" && !strings.HasPrefix(problem, newerSchema) {
+			problems = append(problems, DayProblem{Date: date, Path: path, Problem: problem})
+		}
+	}
+
+	return problems, nil
+}
+
+// set a day file aside, so that the day starts over
+// returns where the file went
+func (s *DirStore) Quarantine(date string) (string, error) {
+	path := s.dayFile(date)
+	if path == "" {
+		return "", fmt.Errorf("[ERR] Unable to set '%s' aside: no day file", date)
+	}
+	aside := path + corruptSuffix
+	if _, err := os.Stat(aside); err == nil { // keep the ones set aside before
+		aside = fmt.Sprintf("%s-%d", aside, time.Now().Unix())
+	}
+
+	if err := os.Rename(path, aside); err != nil {
+		return "", fmt.Errorf("[ERR] Unable to rename '%s': %v", path, err)
+	}
+	return aside, nil
+}
+
+// the day file and the archived day are removed both
+func (s *DirStore) RemoveDay(date string) error {
+	archived, err := s.unarchiveDay(date)
+	if err != nil {
+		return err // already formatted
+	}
+
+	path := s.dayFile(date)
+	if path == "" {
+		if archived {
+			return nil
+		}
+		return fmt.Errorf("[ERR] Unable to remove '%s': no such day", filepath.Join(s.dir, date))
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("[ERR] Unable to remove '%s': %v", path, err)
+	}
+	return nil
+}
+
+// the dates of the day files and of the archived days
+// files whose name isn't a date or a month are ignored
+func (s *DirStore) Dates() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) { // nothing persisted yet
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to list '%s': %v", s.dir, err)
+	}
+
+	found := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if date := dayFileDate(entry.Name()); date != "" {
+			found[date] = true
+			continue
+		}
+
+		if archiveMonth(entry.Name()) == "" { // not a day file
+			continue
+		}
+		days, err := s.readArchive(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, err // already formatted
+		}
+		for date, _ := range days {
+			found[date] = true
+		}
+	}
+
+	dates := []string{}
+	for date, _ := range found {
+		dates = append(dates, date)
+	}
+
+	sort.Strings(dates)
+	return dates, nil
+}
+
+func (s *DirStore) Items(since, until string, fn ItemFunc) error {
+	return eachItem(s, since, until, fn)
+}
+
+// days kept in memory, e.g. for tests
+// days are kept as json, so that callers don't share them with the store
+type MemStore struct {
+	days map[string][]byte // by date
+	lock sync.RWMutex
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{days: map[string][]byte{}}
+}
+
+func (s *MemStore) LoadDay(date string) (*Day, error) {
+	s.lock.RLock()
+	data, ok := s.days[date]
+	s.lock.RUnlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	day, problem := parseDay(data)
+	if problem != "" {
+		return nil, fmt.Errorf("[ERR] Unable to load '%s': %s", date, problem)
+	}
+	return day, nil
+}
+
+func (s *MemStore) SaveDay(day *Day) error {
+	data, err := encodeDay(day)
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to marshal: %v", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.days[day.Date] = data
+	return nil
+}
+
+func (s *MemStore) RemoveDay(date string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.days[date]; !ok {
+		return fmt.Errorf("[ERR] Unable to remove '%s': no such day", date)
+	}
+	delete(s.days, date)
+	return nil
+}
+
+func (s *MemStore) Dates() ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	dates := []string{}
+	for date, _ := range s.days {
+		dates = append(dates, date)
+	}
+
+	sort.Strings(dates)
+	return dates, nil
+}
+
+func (s *MemStore) Items(since, until string, fn ItemFunc) error {
+	return eachItem(s, since, until, fn)
+}
+
+// copy the days of a store to another, merged with the days it has already
+// fn, if set, is called once each day is copied
+func CopyDays(from, to Store, fn func(date string, items int)) error {
+	dates, err := from.Dates()
+	if err != nil {
+		return err // already formatted
+	}
+
+	for _, date := range dates {
+		day, err := from.LoadDay(date)
+		if err != nil {
+			return err // already formatted
+		}
+		if day == nil {
+			continue
+		}
+		if day.Owners == nil {
+			day.Owners = &Owners{}
+		}
+
+		marshaller, err := NewStoreMarshaller(to)
+		if err != nil {
+			return err // already formatted
+		}
+		marshaller.Days = &Days{day}
+
+		items := 0
+		for _, owner := range *day.Owners {
+			if owner.Channels == nil {
+				owner.Channels = &Channels{}
+			}
+			for _, channel := range *owner.Channels {
+				if channel.Items == nil {
+					channel.Items = &Items{}
+				}
+				items += len(*channel.Items)
+			}
+		}
+
+		if err := marshaller.Save(); err != nil {
+			return err // already formatted
+		}
+		if fn != nil {
+			fn(date, items)
+		}
+	}
+
+	return nil
+}
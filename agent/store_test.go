@@ -0,0 +1,447 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Store(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenBoltStore(filepath.Join(dir, "items.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gzipped := NewDirStore(filepath.Join(dir, "gzip"))
+	gzipped.Compression = "gzip"
+	zstded := NewDirStore(filepath.Join(dir, "zstd"))
+	zstded.Compression = "zstd"
+	for _, sub := range []string{"gzip", "zstd"} {
+		os.Mkdir(filepath.Join(dir, sub), os.ModePerm)
+	}
+
+	stores := map[string]Store{
+		"dir":   NewDirStore(dir),
+		"gzip":  gzipped,
+		"zstd":  zstded,
+		"owner": NewOwnerStore(filepath.Join(dir, "owner")),
+		"mem":   NewMemStore(),
+		"bolt":  db,
+	}
+
+	for name, store := range stores {
+		for _, day := range []Day{
+			dayOf("2016-04-25", "wsj", "World", "c", "d"),
+			dayOf("2016-04-24", "cnet", "iPhone", "a", "b"),
+			dayOf("2016-04-26", "wsj", "Business", "e"),
+		} {
+			if err := store.SaveDay(&day); err != nil {
+				t.Errorf("[%s] %v", name, err)
+			}
+		}
+
+		// missing day
+		day, err := store.LoadDay("2016-04-23")
+		if err != nil || day != nil {
+			t.Errorf("[%s] Expected no day, found %+v, %v", name, day, err)
+		}
+
+		// saved day
+		expected := dayOf("2016-04-25", "wsj", "World", "c", "d")
+		day, err = store.LoadDay("2016-04-25")
+		if err != nil || !reflect.DeepEqual(*day, expected) {
+			t.Errorf("[%s] Expected %+v, found %+v, %v", name, expected, day, err)
+		}
+
+		// replaced day
+		replaced := dayOf("2016-04-25", "wsj", "World", "c", "d", "f")
+		store.SaveDay(&replaced)
+		day, _ = store.LoadDay("2016-04-25")
+		if !reflect.DeepEqual(*day, replaced) {
+			t.Errorf("[%s] Expected %+v, found %+v", name, replaced, *day)
+		}
+
+		testCases := []struct {
+			since string
+			until string
+			items []string // date title
+		}{
+			{"", "", []string{"2016-04-24 a", "2016-04-24 b", "2016-04-25 c", "2016-04-25 d", "2016-04-25 f", "2016-04-26 e"}},
+			{"2016-04-25", "", []string{"2016-04-25 c", "2016-04-25 d", "2016-04-25 f", "2016-04-26 e"}},
+			{"", "2016-04-24", []string{"2016-04-24 a", "2016-04-24 b"}},
+			{"2016-04-27", "", []string{}},
+		}
+
+		for idx, testCase := range testCases {
+			items := []string{}
+			err := store.Items(testCase.since, testCase.until, func(date string, owner *Owner, channel *Channel, item *Item) {
+				items = append(items, date+" "+item.Title)
+			})
+			if err != nil {
+				t.Errorf("[%s][Test case %d] %v", name, idx, err)
+			}
+			if !reflect.DeepEqual(items, testCase.items) {
+				t.Errorf("[%s][Test case %d] Expected %v, found %v", name, idx, testCase.items, items)
+			}
+		}
+
+		// removed day
+		if err := store.RemoveDay("2016-04-24"); err != nil {
+			t.Errorf("[%s] %v", name, err)
+		}
+		if err := store.RemoveDay("2016-04-24"); err == nil {
+			t.Errorf("[%s] Expected an error removing a missing day", name)
+		}
+		dates, err := store.Dates()
+		if err != nil || !reflect.DeepEqual(dates, []string{"2016-04-25", "2016-04-26"}) {
+			t.Errorf("[%s] Expected the dates left, found %v, %v", name, dates, err)
+		}
+	}
+}
+
+func Test_Marshaller_Save_store(t *testing.T) {
+	store := NewMemStore()
+	existing := dayOf("2016-04-25", "wsj", "World", "c")
+	store.SaveDay(&existing)
+
+	marshaller := &Marshaller{Days: &Days{}, Store: store}
+	err := marshaller.ReArrange(Channels{
+		&Channel{Owner: "wsj", Title: "World", Items: &Items{
+			&Item{Title: "b", Link: "http://b", Date: "Mon, 25 Apr 2016 10:00:00 +0000"},
+		}},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = marshaller.Save()
+	if err != nil {
+		t.Error(err)
+	}
+
+	day, _ := store.LoadDay("2016-04-25")
+	titles := []string{}
+	for _, item := range *(*(*day.Owners)[0].Channels)[0].Items {
+		titles = append(titles, item.Title)
+	}
+	if !reflect.DeepEqual(titles, []string{"b", "c"}) {
+		t.Errorf("Expected the items merged and sorted, found %v", titles)
+	}
+}
+
+func Test_DirStore_Check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"2016-04-24": `{"date":"2016-04-24","owners":[]}`,
+		"2016-04-25": `{"date":"2016-04-25","owners":[{"id":"wsj","chan`,
+		"2016-04-26": ``,
+		"2016-04-27": `{"date":"2016-04-27","owners":{}}`,
+	}
+	for name, content := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666)
+	}
+
+	store := NewDirStore(dir)
+	problems, err := store.Check()
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := []DayProblem{
+		{"2016-04-25", filepath.Join(dir, "2016-04-25"), "truncated"},
+		{"2016-04-26", filepath.Join(dir, "2016-04-26"), "empty"},
+		{"2016-04-27", filepath.Join(dir, "2016-04-27"), "unparsable, json: cannot unmarshal object into Go struct field Day.owners of type agent.Owners"},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, problems)
+	}
+
+	// loading fails with the problem
+	_, err = store.LoadDay("2016-04-25")
+	if err == nil || err.Error() != "[ERR] Unable to load '"+filepath.Join(dir, "2016-04-25")+"': truncated" {
+		t.Errorf("Expected the day reported truncated, found '%v'", err)
+	}
+
+	// set aside, the day starts over
+	for idx, _ := range []int{0, 1} {
+		ioutil.WriteFile(filepath.Join(dir, "2016-04-25"), []byte(files["2016-04-25"]), 0666)
+		aside, err := store.Quarantine("2016-04-25")
+		if err != nil {
+			t.Error(err)
+		}
+		if data, _ := ioutil.ReadFile(aside); string(data) != files["2016-04-25"] {
+			t.Errorf("[Test case %d] Expected the file set aside to '%s'", idx, aside)
+		}
+		if day, err := store.LoadDay("2016-04-25"); day != nil || err != nil {
+			t.Errorf("[Test case %d] Expected no day left, found %+v, %v", idx, day, err)
+		}
+	}
+}
+
+func Test_DirStore_SaveDay_backup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		previous string // empty if none
+		backup   bool
+	}{
+		{"", false},
+		{`{"version":1,"date":"2016-04-24","owners":[]}`, false},
+		{`{"date":"2016-04-24","owners":[]}`, true},                   // written before versions were stamped
+		{`{"date":"2016-04-24","version":9,"owners":[]}`, true},       // written by a newer version
+		{`{"version":1,"date":"2016-04-24","owners":[],"x":1}`, true}, // with other fields
+	}
+
+	store := NewDirStore(dir)
+	path := filepath.Join(dir, "2016-04-24")
+	for idx, testCase := range testCases {
+		os.Remove(path)
+		os.Remove(path + backupSuffix)
+		if testCase.previous != "" {
+			ioutil.WriteFile(path, []byte(testCase.previous), 0666)
+		}
+
+		day := dayOf("2016-04-24", "wsj", "World", "a")
+		if err := store.SaveDay(&day); err != nil {
+			t.Error(err)
+		}
+
+		backup, err := ioutil.ReadFile(path + backupSuffix)
+		if testCase.backup && string(backup) != testCase.previous {
+			t.Errorf("[Test case %d] Expected the previous version backed up, found '%s'", idx, backup)
+		}
+		if !testCase.backup && err == nil {
+			t.Errorf("[Test case %d] Expected no backup", idx)
+		}
+
+		loaded, _ := store.LoadDay("2016-04-24")
+		if !reflect.DeepEqual(*loaded, day) {
+			t.Errorf("[Test case %d] Expected %+v, found %+v", idx, day, *loaded)
+		}
+	}
+}
+
+func Test_DirStore_compression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewDirStore(dir)
+	testCases := []struct {
+		compression string
+		file        string // the only file of the day
+	}{
+		{"", "2016-04-24"},
+		{"gzip", "2016-04-24.json.gz"},
+		{"zstd", "2016-04-24.json.zst"},
+		{"", "2016-04-24"},
+	}
+
+	titles := []string{}
+	for idx, testCase := range testCases {
+		store.Compression = testCase.compression
+		titles = append(titles, fmt.Sprint(idx))
+
+		// the previous version is read whatever its compression
+		marshaller, _ := NewStoreMarshaller(store)
+		marshaller.Days = &Days{}
+		marshaller.Days.AddItem(Item{Title: fmt.Sprint(idx)}, "2016-04-24", "wsj", "World", "")
+		if err := marshaller.Save(); err != nil {
+			t.Errorf("[Test case %d] %v", idx, err)
+		}
+
+		names, _ := filepath.Glob(filepath.Join(dir, "2016-04-24*"))
+		if len(names) != 1 || filepath.Base(names[0]) != testCase.file {
+			t.Errorf("[Test case %d] Expected '%s' only, found %v", idx, testCase.file, names)
+		}
+
+		expected := dayOf("2016-04-24", "wsj", "World", titles...)
+		for _, item := range *(*(*expected.Owners)[0].Channels)[0].Items {
+			item.Link = ""
+		}
+		day, err := store.LoadDay("2016-04-24")
+		if err != nil || !reflect.DeepEqual(*day, expected) {
+			t.Errorf("[Test case %d] Expected %+v, found %+v, %v", idx, expected, day, err)
+		}
+	}
+}
+
+func Test_DirStore_pretty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewDirStore(dir)
+	store.Pretty = true
+	day := dayOf("2016-04-24", "wsj", "World", "a", "b")
+	if err := store.SaveDay(&day); err != nil {
+		t.Error(err)
+	}
+
+	path := filepath.Join(dir, "2016-04-24")
+	expected := `{
+  "version": 1,
+  "date": "2016-04-24",
+  "owners": [
+    {
+      "id": "wsj",
+      "channels": [
+        {
+          "title": "World",
+          "desc": "",
+          "items": [
+            {
+              "title": "a",
+              "link": "http://a",
+              "desc": ""
+            },
+            {
+              "title": "b",
+              "link": "http://b",
+              "desc": ""
+            }
+          ]
+        }
+      ]
+    }
+  ]
+}
+`
+	if data, _ := ioutil.ReadFile(path); string(data) != expected {
+		t.Errorf("Expected '%s', found '%s'", expected, data)
+	}
+
+	testCases := []struct {
+		pretty  bool
+		day     Day
+		written bool
+	}{
+		{true, dayOf("2016-04-24", "wsj", "World", "a", "b"), false},
+		{true, dayOf("2016-04-24", "wsj", "World", "a", "b", "c"), true},
+		{true, dayOf("2016-04-24", "wsj", "World", "a", "b", "c"), false},
+		{false, dayOf("2016-04-24", "wsj", "World", "a", "b", "c"), true},
+		{false, dayOf("2016-04-24", "wsj", "World", "a", "b", "c"), false},
+	}
+
+	for idx, testCase := range testCases {
+		old := time.Now().Add(-time.Hour).Truncate(time.Second)
+		os.Chtimes(path, old, old)
+
+		store.Pretty = testCase.pretty
+		if err := store.SaveDay(&testCase.day); err != nil {
+			t.Errorf("[Test case %d] %v", idx, err)
+		}
+
+		stat, _ := os.Stat(path)
+		if written := !stat.ModTime().Equal(old); written != testCase.written {
+			t.Errorf("[Test case %d] Expected written %v, found %v", idx, testCase.written, written)
+		}
+		loaded, err := store.LoadDay("2016-04-24")
+		if err != nil || !reflect.DeepEqual(*loaded, testCase.day) {
+			t.Errorf("[Test case %d] Expected %+v, found %+v, %v", idx, testCase.day, loaded, err)
+		}
+	}
+}
+
+func Test_DirStore_Archive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewDirStore(dir)
+	for _, day := range []Day{
+		dayOf("2016-03-31", "wsj", "World", "a"),
+		dayOf("2016-04-01", "wsj", "World", "b"),
+		dayOf("2016-04-02", "wsj", "World", "c"),
+		dayOf("2016-05-01", "wsj", "World", "d"),
+	} {
+		store.SaveDay(&day)
+	}
+
+	archived := map[string][]string{}
+	err = store.Archive("2016-05-01", func(path string, dates []string) {
+		archived[filepath.Base(path)] = dates
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"2016-03.json.gz": {"2016-03-31"},
+		"2016-04.json.gz": {"2016-04-01", "2016-04-02"},
+	}
+	if !reflect.DeepEqual(archived, expected) {
+		t.Errorf("Expected %v, found %v", expected, archived)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "2016-0*"))
+	for idx, _ := range names {
+		names[idx] = filepath.Base(names[idx])
+	}
+	if !reflect.DeepEqual(names, []string{"2016-03.json.gz", "2016-04.json.gz", "2016-05-01"}) {
+		t.Errorf("Expected the day files rolled into the archives, found %v", names)
+	}
+
+	// read as before
+	dates, err := store.Dates()
+	if err != nil || !reflect.DeepEqual(dates, []string{"2016-03-31", "2016-04-01", "2016-04-02", "2016-05-01"}) {
+		t.Errorf("Expected the archived dates, found %v, %v", dates, err)
+	}
+	if titles := storeTitles(store); !reflect.DeepEqual(titles["2016-04-02"], []string{"c"}) {
+		t.Errorf("Expected the archived items, found %v", titles)
+	}
+
+	// merged with the archived version, then archived again
+	marshaller, _ := NewStoreMarshaller(store)
+	marshaller.Days.AddItem(Item{Title: "e", Link: "http://e"}, "2016-04-02", "wsj", "World", "")
+	if err := marshaller.Save(); err != nil {
+		t.Error(err)
+	}
+	if err := store.Archive("2016-05-01", nil); err != nil {
+		t.Error(err)
+	}
+	if titles := storeTitles(store); !reflect.DeepEqual(titles["2016-04-02"], []string{"c", "e"}) {
+		t.Errorf("Expected the items merged, found %v", titles)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2016-04-02")); !os.IsNotExist(err) {
+		t.Errorf("Expected the day file archived again")
+	}
+
+	// removed from the archive, the archive goes with its last day
+	for _, date := range []string{"2016-04-01", "2016-03-31"} {
+		if err := store.RemoveDay(date); err != nil {
+			t.Error(err)
+		}
+	}
+	dates, _ = store.Dates()
+	if !reflect.DeepEqual(dates, []string{"2016-04-02", "2016-05-01"}) {
+		t.Errorf("Expected the days removed, found %v", dates)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2016-03.json.gz")); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty archive removed")
+	}
+}
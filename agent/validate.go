@@ -0,0 +1,501 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// a problem found while validating a channels file
+type Diagnostic struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.location(), d.Msg)
+}
+
+// the line and column are omitted when unknown
+func (d Diagnostic) location() string {
+	if d.Line == 0 {
+		return d.File
+	}
+	return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+}
+
+type Diagnostics []Diagnostic
+
+// known keys of the objects of a channels file
+var (
+	groupKeys = map[string]bool{
+		"owner":    true,
+		"channels": true,
+		"include":  true,
+	}
+	feedKeys = map[string]bool{
+		"url":      true,
+		"name":     true,
+		"tags":     true,
+		"enabled":  true,
+		"interval": true,
+		"headers":  true,
+		"auth":     true,
+		"parser":   true,
+		"filters":  true,
+	}
+	authKeys = map[string]bool{
+		"basic":   true,
+		"bearer":  true,
+		"cookies": true,
+		"headers": true,
+		"cert":    true,
+	}
+	basicKeys = map[string]bool{
+		"user":     true,
+		"password": true,
+	}
+	certKeys = map[string]bool{
+		"cert": true,
+		"key":  true,
+	}
+	filterKeys = map[string]bool{
+		"field":   true,
+		"match":   true,
+		"exclude": true,
+	}
+)
+
+// check the channels files of a dir (or a single channels file)
+// the files are discovered as Load does, included files are checked too
+// problems found in the files are returned as diagnostics
+// the error is reserved to failures preventing the check itself
+func (l *Loader) Validate(file string) (Diagnostics, error) {
+	paths, err := l.channelFiles(file)
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	v := &validator{
+		diags: Diagnostics{},
+		urls:  map[string]occurrence{},
+		seen:  map[string]bool{},
+	}
+	v.queue(paths...)
+
+	for len(v.pending) > 0 {
+		path := v.pending[0]
+		v.pending = v.pending[1:]
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+		}
+
+		// files with no known extension are assumed to be json
+		format := FormatOf(path)
+		if format == "" {
+			format = FormatJson
+		}
+
+		v.validateFile(path, data, format)
+	}
+
+	// cycles are only reported once the files themselves are fine
+	if len(v.diags) == 0 {
+		// the references are left as written, their values may not be available here
+		includer := newIncluder()
+		includer.raw = true
+		if _, err := includer.read(paths); err != nil {
+			v.diags = append(v.diags, Diagnostic{File: file, Msg: strings.TrimPrefix(err.Error(), "[ERR] ")})
+		}
+	}
+
+	return v.diags, nil
+}
+
+// where a url has been seen first
+type occurrence struct {
+	owner  string
+	file   string
+	line   int
+	column int
+}
+
+type validator struct {
+	diags   Diagnostics
+	urls    map[string]occurrence
+	seen    map[string]bool // files already queued
+	pending []string        // files left to check
+
+	// file under validation
+	file      string
+	data      []byte
+	positions bool // whether offsets in data match the file
+}
+
+// queue files not yet checked
+func (v *validator) queue(paths ...string) {
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		if !v.seen[abs] {
+			v.seen[abs] = true
+			v.pending = append(v.pending, path)
+		}
+	}
+}
+
+func (v *validator) report(offset int, format string, args ...interface{}) {
+	line, column := v.position(offset)
+	v.diags = append(v.diags, Diagnostic{
+		File:   v.file,
+		Line:   line,
+		Column: column,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validateFile(path string, data []byte, format string) {
+	v.file = path
+	v.data = data
+	v.positions = format == FormatJson
+
+	// yaml and toml are checked once converted to json, losing the positions
+	if format != FormatJson {
+		converted, err := toJson(data, format)
+		if err != nil {
+			v.report(0, "%v", err)
+			return
+		}
+		data = converted
+		v.data = converted
+	}
+
+	if !json.Valid(data) {
+		var tmp interface{}
+		err := json.Unmarshal(data, &tmp)
+		offset, _ := errorOffset(err)
+		v.report(int(offset), "invalid json: %v", err)
+		return
+	}
+
+	start := skip(data, 0)
+	if kind := kindOf(data[start:]); kind != "array" {
+		v.report(start, "expected a list of channel groups, got %s", kind)
+		return
+	}
+
+	v.array(data, 0, v.validateGroup)
+}
+
+func (v *validator) validateGroup(raw json.RawMessage, base int) {
+	if kind := kindOf(raw); kind != "object" {
+		v.report(base, "expected a channel group, got %s", kind)
+		return
+	}
+
+	owner := ""
+	ownerFound := false
+	var channels json.RawMessage
+	channelsOffset := -1
+	includeFound := false
+
+	v.object(raw, base, groupKeys, func(key string, value json.RawMessage, offset int) {
+		switch key {
+		case "include":
+			includeFound = true
+			if kind := kindOf(value); kind != "string" {
+				v.report(offset, "expected 'include' to be a string, got %s", kind)
+				return
+			}
+			var pattern string
+			json.Unmarshal(value, &pattern)
+			matches, err := resolveInclude(v.file, pattern)
+			if err != nil {
+				v.report(offset, "%s", strings.TrimPrefix(err.Error(), "[ERR] "))
+				return
+			}
+			v.queue(matches...)
+		case "owner":
+			if kind := kindOf(value); kind != "string" {
+				v.report(offset, "expected 'owner' to be a string, got %s", kind)
+				return
+			}
+			json.Unmarshal(value, &owner)
+			ownerFound = true
+			if len(bytes.TrimSpace([]byte(owner))) == 0 {
+				v.report(offset, "empty owner")
+			}
+		case "channels":
+			if kind := kindOf(value); kind != "array" {
+				v.report(offset, "expected 'channels' to be a list, got %s", kind)
+				return
+			}
+			channels = value
+			channelsOffset = offset
+		}
+	})
+
+	if includeFound {
+		if ownerFound || channelsOffset != -1 {
+			v.report(base, "an include can't have an owner or channels")
+		}
+		return
+	}
+
+	if !ownerFound {
+		v.report(base, "missing owner")
+	}
+
+	if channelsOffset != -1 {
+		v.array(channels, channelsOffset, func(value json.RawMessage, offset int) {
+			v.validateFeed(owner, value, offset)
+		})
+	}
+}
+
+func (v *validator) validateFeed(owner string, raw json.RawMessage, base int) {
+	switch kind := kindOf(raw); kind {
+	case "string":
+		var u string
+		json.Unmarshal(raw, &u)
+		v.validateUrl(owner, u, base)
+		return
+	case "object":
+		// handled below
+	default:
+		v.report(base, "expected a url or a channel, got %s", kind)
+		return
+	}
+
+	// locate the known keys
+	offsets := map[string]int{}
+	var filters, auth json.RawMessage
+	v.object(raw, base, feedKeys, func(key string, value json.RawMessage, offset int) {
+		offsets[key] = offset
+		switch key {
+		case "filters":
+			filters = value
+		case "auth":
+			auth = value
+		}
+	})
+
+	var feed Feed
+	err := json.Unmarshal(raw, &feed)
+	if err != nil {
+		offset, _ := errorOffset(err)
+		if e, ok := err.(*json.UnmarshalTypeError); ok {
+			if fieldOffset, ok := offsets[e.Field]; ok { // point to the faulty field
+				offset = int64(fieldOffset - base)
+			}
+		}
+		v.report(base+int(offset), "invalid channel: %v", err)
+		return
+	}
+
+	if _, ok := offsets["url"]; !ok {
+		v.report(base, "missing url")
+	} else {
+		v.validateUrl(owner, feed.Url, offsets["url"])
+	}
+
+	if _, err := feed.PollInterval(); err != nil {
+		v.report(offsets["interval"], "invalid interval '%s'", feed.Interval)
+	}
+
+	if !parsers[feed.Parser] {
+		v.report(offsets["parser"], "unknown parser '%s'", feed.Parser)
+	}
+
+	if auth != nil && feed.Auth != nil {
+		v.object(auth, offsets["auth"], authKeys, func(key string, value json.RawMessage, offset int) {
+			switch key {
+			case "basic":
+				v.object(value, offset, basicKeys, func(string, json.RawMessage, int) {})
+			case "cert":
+				v.object(value, offset, certKeys, func(string, json.RawMessage, int) {})
+			}
+		})
+		if err := feed.Auth.check(); err != nil {
+			v.report(offsets["auth"], "invalid auth: %v", err)
+		}
+	}
+
+	if filters != nil {
+		idx := 0
+		v.array(filters, offsets["filters"], func(value json.RawMessage, offset int) {
+			v.object(value, offset, filterKeys, func(string, json.RawMessage, int) {})
+			if _, err := feed.Filters[idx].compile(); err != nil {
+				v.report(offset, "invalid filter: %v", err)
+			}
+			idx++
+		})
+	}
+}
+
+func (v *validator) validateUrl(owner, link string, offset int) {
+	// references are checked for their syntax only, their values may not be available here
+	expanded, err := expandWith(link, func(string) (string, error) { return "reference", nil })
+	if err != nil {
+		v.report(offset, "%v", err)
+		return
+	}
+
+	u, err := url.Parse(expanded)
+	if err != nil || u.Host == "" {
+		v.report(offset, "invalid url '%s'", link)
+		return
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		v.report(offset, "unsupported scheme '%s' in '%s', expected http or https", u.Scheme, link)
+		return
+	}
+
+	line, column := v.position(offset)
+	prev, ok := v.urls[link]
+	if !ok {
+		v.urls[link] = occurrence{owner, v.file, line, column}
+		return
+	}
+
+	if prev.owner != owner {
+		at := Diagnostic{File: prev.file, Line: prev.line, Column: prev.column}.location()
+		v.report(offset, "duplicate url '%s', already owned by '%s' at %s", link, prev.owner, at)
+	}
+}
+
+// line and column of an offset, zero when unknown
+func (v *validator) position(offset int) (int, int) {
+	if !v.positions {
+		return 0, 0
+	}
+	return position(v.data, int64(offset))
+}
+
+// walk the elements of a json array
+// base is the offset of the array in the file under validation
+func (v *validator) array(raw json.RawMessage, base int, fn func(value json.RawMessage, offset int)) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil { // [
+		return
+	}
+
+	for dec.More() {
+		offset := base + skip(raw, dec.InputOffset())
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			v.report(offset, "invalid json: %v", err)
+			return
+		}
+
+		fn(value, offset)
+	}
+}
+
+// walk the members of a json object, reporting the unknown keys
+// base is the offset of the object in the file under validation
+func (v *validator) object(raw json.RawMessage, base int, known map[string]bool, fn func(key string, value json.RawMessage, offset int)) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil { // {
+		return
+	}
+
+	for dec.More() {
+		keyOffset := base + skip(raw, dec.InputOffset())
+		token, err := dec.Token()
+		if err != nil {
+			v.report(keyOffset, "invalid json: %v", err)
+			return
+		}
+		key, _ := token.(string)
+
+		offset := base + skip(raw, dec.InputOffset())
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			v.report(offset, "invalid json: %v", err)
+			return
+		}
+
+		if !known[key] {
+			v.report(keyOffset, "unknown field '%s'", key)
+			continue
+		}
+
+		fn(key, value, offset)
+	}
+}
+
+// offset of the next json value, separators are skipped
+func skip(data []byte, offset int64) int {
+	idx := int(offset)
+	for idx < len(data) && bytes.IndexByte([]byte(" \t\r\n,:"), data[idx]) != -1 {
+		idx++
+	}
+	return idx
+}
+
+func kindOf(raw []byte) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "nothing"
+	}
+
+	switch raw[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "bool"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+// offset in the input of a json decoding error, if known
+func errorOffset(err error) (int64, bool) {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		if e.Offset > 0 {
+			return e.Offset - 1, true // offset is past the faulty byte
+		}
+		return 0, true
+	case *json.UnmarshalTypeError:
+		return e.Offset, true
+	}
+	return 0, false
+}
+
+// line and column (both starting at 1) of an offset
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	line, column := 1, 1
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_Validate(t *testing.T) {
+	testCases := []struct {
+		json  []string // yaml when starting with '-'
+		diags []string // file index:line:column: message
+	}{
+		{ // test case 0, valid
+			[]string{
+				`[
+	{
+		"owner": "wsj",
+		"channels": ["http://www.wsj.com/xml/rss/3_7085.xml", {"url": "https://www.wsj.com/xml/rss/3_7014.xml", "interval": "1h"}]
+	}
+]`,
+			},
+			[]string{},
+		},
+		{ // test case 1, syntax error
+			[]string{
+				`[
+	{
+		"owner": "wsj",
+		"channels": ["http://www.wsj.com/xml/rss/3_7085.xml",]
+	}
+]`,
+			},
+			[]string{
+				"0:4:56: invalid json: invalid character ']' looking for beginning of value",
+			},
+		},
+		{ // test case 2, invalid urls, empty owner, unknown fields
+			[]string{
+				`[
+	{
+		"owner": " ",
+		"chanels": []
+	},
+	{
+		"owner": "wsj",
+		"channels": ["www.wsj.com/xml/rss/3_7085.xml", "ftp://www.wsj.com/3_7014.xml", {"url": "http://www.wsj.com/xml/rss/3_7014.xml", "intervals": "1h"}]
+	}
+]`,
+			},
+			[]string{
+				"0:3:12: empty owner",
+				"0:4:3: unknown field 'chanels'",
+				"0:8:16: invalid url 'www.wsj.com/xml/rss/3_7085.xml'",
+				"0:8:50: unsupported scheme 'ftp' in 'ftp://www.wsj.com/3_7014.xml', expected http or https",
+				"0:8:131: unknown field 'intervals'",
+			},
+		},
+		{ // test case 3, duplicate urls across owners and files
+			[]string{
+				`[
+	{
+		"owner": "wsj",
+		"channels": ["http://www.wsj.com/xml/rss/3_7085.xml", "http://www.wsj.com/xml/rss/3_7085.xml"]
+	}
+]`,
+				`[
+	{
+		"owner": "cnet",
+		"channels": ["http://www.wsj.com/xml/rss/3_7085.xml"]
+	}
+]`,
+			},
+			[]string{
+				"1:4:16: duplicate url 'http://www.wsj.com/xml/rss/3_7085.xml', already owned by 'wsj' at 0:4:16",
+			},
+		},
+		{ // test case 4, invalid options
+			[]string{
+				`[
+	{
+		"owner": "wsj",
+		"channels": [
+			{"url": "http://www.wsj.com/xml/rss/3_7085.xml", "interval": "often", "parser": "html", "filters": [{"field": "author", "match": "x"}]},
+			{"url": "http://www.wsj.com/xml/rss/3_7014.xml", "tags": "news"}
+		]
+	}
+]`,
+			},
+			[]string{
+				"0:5:65: invalid interval 'often'",
+				"0:5:84: unknown parser 'html'",
+				"0:5:104: invalid filter: [ERR] Unknown filter field 'author'",
+				"0:6:61: invalid channel: json: cannot unmarshal string into Go struct field feed.tags of type []string",
+			},
+		},
+		{ // test case 5, yaml, positions are unknown
+			[]string{
+				"- owner: wsj\n  channels: [http://www.wsj.com/xml/rss/3_7085.xml]\n  tag: news\n",
+			},
+			[]string{
+				"0: unknown field 'tag'",
+			},
+		},
+		{ // test case 6, wrong structure
+			[]string{
+				`{"owner": "wsj"}`,
+				`[{"channels": "http://www.wsj.com/xml/rss/3_7085.xml"}]`,
+			},
+			[]string{
+				"0:1:1: expected a list of channel groups, got object",
+				"1:1:15: expected 'channels' to be a list, got string",
+				"1:1:2: missing owner",
+			},
+		},
+		{ // test case 7, credentials
+			[]string{
+				`[
+	{
+		"owner": "wsj",
+		"channels": [
+			{"url": "http://www.wsj.com/xml/rss/3_7085.xml", "auth": {"basic": {"user": "me", "pass": "x"}, "bearer": "${secret:token}"}},
+			{"url": "http://www.wsj.com/xml/rss/3_7014.xml", "auth": {"cert": {"cert": "client.pem"}, "cookie": {"a": "b"}}}
+		]
+	}
+]`,
+			},
+			[]string{
+				"0:5:86: unknown field 'pass'",
+				"0:5:61: invalid auth: both basic auth and a bearer token",
+				"0:6:94: unknown field 'cookie'",
+				"0:6:61: invalid auth: client certificate with no cert or key file",
+			},
+		},
+		{ // test case 8, references with no value here, in a file with an include
+			[]string{
+				`[
+	{
+		"owner": "wsj",
+		"channels": [{"url": "https://${RSS_TEST_UNDEFINED}/xml/rss/3_7085.xml?token=${secret:token}", "name": "${RSS_TEST_UNDEFINED}"}]
+	},
+	{"include": "1.json"}
+]`,
+				`[{"owner": "cnet", "channels": []}]`,
+			},
+			[]string{},
+		},
+	}
+
+	for idx, testCase := range testCases {
+		// create temp dir
+		dir, err := ioutil.TempDir("", "dir")
+		if err != nil {
+			t.Error(err)
+		}
+
+		// write json load to temp files
+		for idx, i := range testCase.json {
+			ext := ".json"
+			if strings.HasPrefix(i, "-") {
+				ext = ".yaml"
+			}
+			file := filepath.Join(dir, strings.Join([]string{strconv.Itoa(idx), ext}, ""))
+			err = ioutil.WriteFile(file, []byte(i), 0666)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		// under test
+		loader, _ := NewLoader()
+		diags, err := loader.Validate(dir)
+		if err != nil {
+			t.Error(err)
+		}
+
+		// assert, file paths are shortened to the file index
+		replacer := strings.NewReplacer(dir+string(filepath.Separator), "", ".json", "", ".yaml", "")
+		actual := []string{}
+		for _, diag := range diags {
+			actual = append(actual, replacer.Replace(diag.String()))
+		}
+
+		if !reflect.DeepEqual(actual, testCase.diags) {
+			t.Errorf("[Test case %d], expected %q, got %q", idx, testCase.diags, actual)
+		}
+
+		// clean
+		os.RemoveAll(dir)
+	}
+}
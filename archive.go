@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+)
+
+// roll the day files of the past months into monthly compressed archives
+func archive(args []string) int {
+	opts := newOptions("archive")
+	before := opts.flags.String("before", "", "archive the days before this date (YYYY-MM-DD), defaults to the first day of the current month")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	if *opts.itemsDb != "" {
+		printf("[ERR] Archives are made of the day files of the items dir, not of -items_db\n")
+		return exitUsage
+	}
+
+	date := *before
+	if date == "" {
+		now := time.Now().UTC()
+		date = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).Format(dateLayout)
+	}
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		printf("[ERR] Invalid date '%s', expected YYYY-MM-DD\n", date)
+		return exitUsage
+	}
+
+	stores, err := opts.dirStores()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	for _, store := range stores {
+		err = store.Archive(date, func(path string, dates []string) {
+			printf("[INF] Archived %d day(s) to '%s'\n", len(dates), path)
+		})
+		if err != nil {
+			printf("%v\n", err)
+			return exitStorage
+		}
+	}
+
+	return exitOk
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/marouenj/rss/agent"
+)
+
+// translate a channels file from a format to another
+// comments are not carried over
+func convertConfig(args []string) int {
+	flags := flag.NewFlagSet("convert-config", flag.ExitOnError)
+	format := flags.String("format", "", "target format (json, yaml or toml), defaults to the extension of -out")
+	out := flags.String("out", "", "file to write to, defaults to stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Printf("[ERR] Expecting exactly one channels file to convert\n")
+		return exitUsage
+	}
+	in := flags.Arg(0)
+
+	to := *format
+	if to == "" {
+		to = agent.FormatOf(*out)
+	}
+	if to == "" {
+		fmt.Printf("[ERR] Unable to guess the target format, use -format\n")
+		return exitUsage
+	}
+
+	from := agent.FormatOf(in)
+	if from == "" {
+		from = agent.FormatJson
+	}
+
+	file, err := ioutil.ReadFile(in)
+	if err != nil {
+		fmt.Printf("[ERR] Unable to read '%s': %v\n", in, err)
+		return exitConfig
+	}
+
+	groups, err := agent.UnmarshalChannelGroups(file, from)
+	if err != nil {
+		fmt.Printf("[ERR] Unable to unmarshal '%s': %v\n", in, err)
+		return exitConfig
+	}
+
+	bytes, err := agent.MarshalChannelGroups(groups, to)
+	if err != nil {
+		fmt.Printf("[ERR] Unable to marshal to %s: %v\n", to, err)
+		return exitFailure
+	}
+
+	if *out == "" {
+		os.Stdout.Write(bytes)
+		return exitOk
+	}
+
+	err = ioutil.WriteFile(*out, bytes, 0666)
+	if err != nil {
+		fmt.Printf("[ERR] Unable to write to '%s': %v\n", *out, err)
+		return exitFailure
+	}
+
+	return exitOk
+}
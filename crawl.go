@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/marouenj/rss/agent"
+)
+
+// download the items of the feeds once and persist them
+func crawl(args []string) int {
+	opts := newOptions("crawl")
+	crawlOpts := newCrawlOptions(opts.flags)
+	retentionOpts := newRetentionOptions(opts.flags)
+	pruneAfter := opts.flags.Bool("prune", false, "apply the retention once the items are saved")
+	asJson := opts.flags.Bool("json", false, "print the crawl report as json, with the traffic of each feed")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	// check the channels exist
+	if _, err := os.Stat(opts.channelsPath()); err != nil {
+		printf("[ERR] Channels not exist: %v\n", err)
+		return exitConfig
+	}
+
+	outDir := opts.itemsDir()
+
+	// moves are kept in the data dir, even if the items are kept elsewhere
+	dirs := []string{opts.dataDir()}
+	if *opts.itemsDb == "" {
+		dirs = append(dirs, outDir)
+	}
+	for _, dir := range dirs {
+		// create if not exists
+		err := os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+		if err != nil {
+			printf("[ERR] Unable to create dir '%s': %v\n", dir, err)
+			return exitStorage
+		}
+	}
+
+	// check outDir is a dir
+	if *opts.itemsDb == "" {
+		info, _ := os.Stat(outDir)
+		if !info.IsDir() {
+			printf("[ERR] Output dir not a dir\n")
+			return exitStorage
+		}
+	}
+
+	// create marshaller, days damaged by a previous run are reported first
+	marshaller, err := opts.marshaller()
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	if err := checkStore(marshaller.Store); err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	// load
+	loader, err := opts.load()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	// create crawler
+	crawler, err := agent.NewCrawler()
+	if err != nil {
+		printf("%v\n", err)
+		return exitFailure
+	}
+	crawlOpts.apply(crawler)
+
+	// crawl
+	err = crawler.Crawl(loader)
+	if err != nil {
+		printf("[ERR] Unable to download items: %v\n", err)
+		return exitFailure
+	}
+
+	// rearrange items
+	err = marshaller.ReArrange(crawler.Rss.Channels)
+	if err != nil {
+		printf("%v\n", err)
+		return exitFailure
+	}
+
+	// persist
+	err = marshaller.Save()
+	if err != nil {
+		printf("[ERR] Unable to merge and persist new items: %v\n", err)
+		return exitStorage
+	}
+
+	// apply the retention
+	if *pruneAfter {
+		retention, err := retentionOpts.retention()
+		if err != nil {
+			printf("%v\n", err)
+			return exitStorage
+		}
+		removals, err := retention.Apply(marshaller.Store, time.Now(), false)
+		printRemovals(removals, retention.Archive != nil, false)
+		if err != nil {
+			printf("%v\n", err)
+			return exitStorage
+		}
+	}
+
+	if *asJson {
+		bytes, err := json.MarshalIndent(crawler.Report, "", "  ")
+		if err != nil {
+			printf("[ERR] Unable to marshal: %v\n", err)
+			return exitFailure
+		}
+		printf("%s\n", bytes)
+	} else {
+		printf("%v\n", crawler.Report)
+	}
+
+	// record the feeds that moved or are gone, for fix-config
+	m, err := agent.NewMoves(filepath.Join(opts.dataDir(), moves))
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+	m.Record(crawler.Report, time.Now())
+	if err := m.Save(); err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	// the items of the other feeds are saved all the same
+	if crawler.Report.Failed() > 0 {
+		return exitFailure
+	}
+
+	return exitOk
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/marouenj/rss/agent"
+)
+
+var schedule string = "schedule.json"
+
+// keep polling the feeds, each one on its own schedule, until interrupted
+func daemon(args []string) int {
+	opts := newOptions("daemon")
+	flags := opts.flags
+	crawlOpts := newCrawlOptions(flags)
+	retentionOpts := newRetentionOptions(flags)
+	pruneAfter := flags.Bool("prune", false, "apply the retention once the items are saved, at most hourly")
+	interval := flags.Duration("interval", agent.DefaultInterval, "poll interval of the feeds with no interval of their own")
+	minInterval := flags.Duration("min_interval", agent.MinInterval, "shortest poll interval")
+	maxInterval := flags.Duration("max_interval", agent.MaxInterval, "longest poll interval, quiet feeds back off up to it")
+	reload := flags.Duration("reload_interval", agent.DefaultReloadInterval, "how often the channels files are checked for changes, never if 0")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	dataDir := opts.dataDir()
+	inPath := opts.channelsPath()
+	outDir := opts.itemsDir()
+
+	// the schedule is kept in dataDir, even if the items are kept elsewhere
+	dirs := []string{dataDir}
+	if *opts.itemsDb == "" {
+		dirs = append(dirs, outDir)
+	}
+	for _, dir := range dirs {
+		// create if not exists
+		err := os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+		if err != nil {
+			printf("[ERR] Unable to create dir '%s': %v\n", dir, err)
+			return exitStorage
+		}
+	}
+
+	// load
+	loader, err := opts.load()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	// restore the schedule of the previous run
+	s, err := agent.NewSchedule(filepath.Join(dataDir, schedule))
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+	s.DefaultInterval = *interval
+	s.MinInterval = *minInterval
+	s.MaxInterval = *maxInterval
+
+	store, err := opts.store()
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	if err := checkStore(store); err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	d, err := agent.NewDaemon(loader, inPath, s, store)
+	if err != nil {
+		printf("%v\n", err)
+		return exitFailure
+	}
+	d.ReloadInterval = *reload
+	crawlOpts.apply(d.Crawler)
+	if *pruneAfter {
+		d.Retention, err = retentionOpts.retention()
+		if err != nil {
+			printf("%v\n", err)
+			return exitStorage
+		}
+	}
+
+	// record the feeds that moved or are gone, for fix-config
+	d.Moves, err = agent.NewMoves(filepath.Join(dataDir, moves))
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	// stop on interrupt, the schedule is persisted on the way out
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	err = d.Run(stop)
+	if err != nil {
+		printf("%v\n", err)
+		return exitFailure
+	}
+
+	return exitOk
+}
@@ -8,18 +8,18 @@ do
   docker run \
          --rm \
          --name go \
-         -v $(pwd):/go \
+         -v $(pwd):/src \
          -v $(pwd)/resources:/out \
-         golang:1.6 \
+         -w /src \
+         golang:1.22 \
   go test -coverprofile=/out/${PACKAGES[((IDX - 1))]}.out ${PATHS[((IDX - 1))]}
 
-  sudo sed -i "s/_\/go\///g" ./resources/agent.out
-
   docker run \
          --rm \
          --name go \
-         -v $(pwd):/go/src \
+         -v $(pwd):/src \
          -v $(pwd)/resources:/out \
-         golang:1.6 \
+         -w /src \
+         golang:1.22 \
   go tool cover -html=/out/${PACKAGES[((IDX - 1))]}.out -o /out/${PACKAGES[((IDX - 1))]}.html
 done
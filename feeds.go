@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marouenj/rss/agent"
+)
+
+// list the feeds of the channels files, one per line or as json
+func listFeeds(args []string) int {
+	opts := newOptions("list-feeds")
+	owner := opts.flags.String("owner", "", "only list the feeds of this owner")
+	asJson := opts.flags.Bool("json", false, "print the channel groups as json")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	loader, err := opts.load()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	groups := agent.ChannelGroups{}
+	for _, group := range loader.ChannelGroups {
+		if *owner == "" || group.Owner == *owner {
+			groups = append(groups, group)
+		}
+	}
+
+	if *asJson {
+		bytes, err := json.MarshalIndent(groups.Masked(), "", "  ")
+		if err != nil {
+			printf("[ERR] Unable to marshal: %v\n", err)
+			return exitFailure
+		}
+		printf("%s\n", bytes)
+		return exitOk
+	}
+
+	for _, group := range groups {
+		for _, feed := range group.Channels {
+			fields := []string{group.Owner, feed.Url}
+			if !feed.IsEnabled() {
+				fields = append(fields, "(disabled)")
+			}
+			if feed.Name != "" {
+				fields = append(fields, feed.Name)
+			}
+			printf("%s\n", strings.Join(fields, "\t"))
+		}
+	}
+
+	return exitOk
+}
+
+// add feeds to an owner, in the file declaring the owner
+// or in a new file named after the owner
+// each feed is fetched and parsed once beforehand, unless -no_check is set
+func addFeed(args []string) int {
+	opts := newOptions("add-feed")
+	owner := opts.flags.String("owner", "", "owner of the feeds, required")
+	file := opts.flags.String("file", "", "channels file to add the feeds to, defaults to the file declaring the owner")
+	name := opts.flags.String("name", "", "name of the feed")
+	noCheck := opts.flags.Bool("no_check", false, "add the feeds without fetching them first")
+	follow := opts.flags.Bool("follow", false, "add the first feed advertised by the html pages given instead of a feed")
+	var tags patterns
+	opts.flags.Var(&tags, "tag", "tag of the feed, repeatable")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	if *owner == "" || opts.flags.NArg() == 0 {
+		printf("[ERR] Expecting an owner and at least one url\n")
+		return exitUsage
+	}
+
+	loader, err := opts.load()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	target, code := ownerFile(opts, loader, *owner, *file, true)
+	if code != exitOk {
+		return code
+	}
+
+	for _, link := range opts.flags.Args() {
+		if hasFeed(loader, *owner, link) {
+			printf("[INF] '%s' already has '%s'\n", *owner, link)
+			continue
+		}
+
+		feed := agent.Feed{Url: link, Name: *name, Tags: []string(tags)}
+		if !*noCheck {
+			checked, err := checkFeed(feed, *follow)
+			if err != nil {
+				printf("%v\n", err)
+				return exitFailure
+			}
+			feed = checked
+		}
+
+		if err := agent.AddFeed(target, *owner, feed); err != nil {
+			printf("%v\n", err)
+			return exitConfig
+		}
+		printf("[INF] Added '%s' to '%s' in '%s'\n", feed.Url, *owner, target)
+	}
+
+	return exitOk
+}
+
+// remove feeds from an owner, in the file declaring the owner
+func removeFeed(args []string) int {
+	opts := newOptions("remove-feed")
+	owner := opts.flags.String("owner", "", "owner of the feeds, required")
+	file := opts.flags.String("file", "", "channels file to remove the feeds from, defaults to the file declaring the owner")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	if *owner == "" || opts.flags.NArg() == 0 {
+		printf("[ERR] Expecting an owner and at least one url\n")
+		return exitUsage
+	}
+
+	loader, err := opts.load()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	target, code := ownerFile(opts, loader, *owner, *file, false)
+	if code != exitOk {
+		return code
+	}
+
+	for _, link := range opts.flags.Args() {
+		found, err := agent.RemoveFeed(target, *owner, link)
+		if err != nil {
+			printf("%v\n", err)
+			return exitConfig
+		}
+		if !found {
+			printf("[ERR] No feed '%s' for '%s' in '%s'\n", link, *owner, target)
+			return exitConfig
+		}
+		printf("[INF] Removed '%s' from '%s' in '%s'\n", link, *owner, target)
+	}
+
+	return exitOk
+}
+
+// move feeds from an owner to another, along with their options
+func moveFeed(args []string) int {
+	opts := newOptions("move-feed")
+	owner := opts.flags.String("owner", "", "owner of the feeds, required")
+	to := opts.flags.String("to", "", "owner to move the feeds to, required")
+	file := opts.flags.String("file", "", "channels file to move the feeds from, defaults to the file declaring the owner")
+	toFile := opts.flags.String("to_file", "", "channels file to move the feeds to, defaults to the file declaring the new owner")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	if *owner == "" || *to == "" || opts.flags.NArg() == 0 {
+		printf("[ERR] Expecting an owner, an owner to move to and at least one url\n")
+		return exitUsage
+	}
+
+	loader, err := opts.load()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	source, code := ownerFile(opts, loader, *owner, *file, false)
+	if code != exitOk {
+		return code
+	}
+	target, code := ownerFile(opts, loader, *to, *toFile, true)
+	if code != exitOk {
+		return code
+	}
+
+	for _, link := range opts.flags.Args() {
+		found, err := agent.MoveFeed(source, *owner, target, *to, link)
+		if err != nil {
+			printf("%v\n", err)
+			return exitConfig
+		}
+		if !found {
+			printf("[ERR] No feed '%s' for '%s' in '%s'\n", link, *owner, source)
+			return exitConfig
+		}
+		printf("[INF] Moved '%s' from '%s' in '%s' to '%s' in '%s'\n", link, *owner, source, *to, target)
+	}
+
+	return exitOk
+}
+
+// fetch and parse a feed once
+// for an html page, the feeds it advertises are listed, or the first one that parses is taken with follow
+func checkFeed(feed agent.Feed, follow bool) (agent.Feed, error) {
+	err := agent.CheckFeed(feed)
+	discovery, ok := err.(*agent.DiscoveryError)
+	if !ok {
+		return feed, err
+	}
+
+	if !follow {
+		for _, candidate := range discovery.Candidates {
+			printf("[INF] Found %s '%s' %s\n", candidate.Type, candidate.Url, candidate.Title)
+		}
+		return feed, err
+	}
+
+	for _, candidate := range discovery.Candidates {
+		discovered := feed
+		discovered.Url = candidate.Url
+		if agent.CheckFeed(discovered) == nil {
+			printf("[INF] '%s' is an html page, adding '%s' instead\n", feed.Url, candidate.Url)
+			return discovered, nil
+		}
+	}
+	return feed, err
+}
+
+// whether the loaded channels already have the url for the owner
+func hasFeed(loader *agent.Loader, owner, link string) bool {
+	for _, group := range loader.ChannelGroups {
+		if group.Owner != owner {
+			continue
+		}
+		for _, feed := range group.Channels {
+			if feed.Url == link {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the channels file to edit for an owner
+// unless given, it's the first file declaring the owner
+// when there's none, a file named after the owner is created in the channels dir if create is set
+func ownerFile(opts *options, loader *agent.Loader, owner, file string, create bool) (string, int) {
+	if file != "" {
+		return file, exitOk
+	}
+
+	found, err := loader.OwnerFile(owner)
+	if err != nil {
+		printf("%v\n", err)
+		return "", exitConfig
+	}
+	if found != "" {
+		return found, exitOk
+	}
+
+	if !create {
+		printf("[ERR] No channels file declares '%s'\n", owner)
+		return "", exitConfig
+	}
+
+	if strings.ContainsAny(owner, `/\`) || owner == "." || owner == ".." {
+		printf("[ERR] Unable to name a channels file after '%s', use -file\n", owner)
+		return "", exitUsage
+	}
+
+	// a single channels file is added to, a dir gets a new file
+	path := opts.channelsPath()
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return path, exitOk
+	}
+	return filepath.Join(path, owner+".json"), exitOk
+}
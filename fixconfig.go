@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marouenj/rss/agent"
+)
+
+var moves string = "moves.json"
+
+// rewrite the urls of the feeds that moved for good and disable the ones that are gone
+// as recorded by the crawls, each change is confirmed unless -yes is set
+func fixConfig(args []string) int {
+	opts := newOptions("fix-config")
+	yes := opts.flags.Bool("yes", false, "apply the changes with no confirmation")
+	dryRun := opts.flags.Bool("dry_run", false, "only list the changes")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	m, err := agent.NewMoves(filepath.Join(opts.dataDir(), moves))
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	loader, err := opts.load()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	code := exitOk
+	for _, move := range m.List() {
+		file, err := loader.FeedFile(move.Owner, move.Url)
+		if err != nil {
+			printf("%v\n", err)
+			return exitConfig
+		}
+		if file == "" { // removed since, or written with references
+			printf("[ERR] No channels file holds '%s' for '%s' as written, fix it by hand\n", move.Url, move.Owner)
+			code = exitConfig
+			continue
+		}
+
+		change := fmt.Sprintf("rewrite '%s' to '%s' for '%s' in '%s'", move.Url, move.To, move.Owner, file)
+		if move.Gone {
+			change = fmt.Sprintf("disable '%s' of '%s' in '%s', gone since %s", move.Url, move.Owner, file, move.Since.Format(dateLayout))
+		}
+
+		if *dryRun {
+			printf("[INF] Would %s\n", change)
+			continue
+		}
+		if !*yes && !confirm(stdin, change) {
+			continue
+		}
+
+		var found bool
+		if move.Gone {
+			found, err = agent.DisableFeed(file, move.Owner, move.Url)
+		} else {
+			found, err = agent.RewriteFeed(file, move.Owner, move.Url, move.To)
+		}
+		if err != nil {
+			printf("%v\n", err)
+			return exitConfig
+		}
+		if found {
+			printf("[INF] Done, %s\n", change)
+		}
+
+		m.Forget(move.Owner, move.Url)
+	}
+
+	if !*dryRun {
+		if err := m.Save(); err != nil {
+			printf("%v\n", err)
+			return exitStorage
+		}
+	}
+
+	return code
+}
+
+// ask for a yes on the standard input, anything else is a no
+func confirm(stdin *bufio.Reader, change string) bool {
+	printf("%s%s? [y/N] ", strings.ToUpper(change[:1]), change[1:])
+	answer, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
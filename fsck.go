@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/marouenj/rss/agent"
+)
+
+// check the day files hold the days as saving leaves them, and repair them
+func fsck(args []string) int {
+	opts := newOptions("fsck")
+	repair := opts.flags.Bool("repair", false, "rewrite the days found wrong, set aside the day files that can't be parsed")
+	asJson := opts.flags.Bool("json", false, "print the report as json")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	if *opts.itemsDb != "" {
+		printf("[ERR] fsck checks the day files of the items dir, not -items_db\n")
+		return exitUsage
+	}
+
+	stores, err := opts.dirStores()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	report := &agent.FsckReport{Problems: []agent.DayProblem{}, Repaired: []string{}}
+	for _, store := range stores {
+		checked, err := store.Fsck(*repair)
+		if err != nil {
+			printf("%v\n", err)
+			return exitStorage
+		}
+		report.Files += checked.Files
+		report.Problems = append(report.Problems, checked.Problems...)
+		report.Repaired = append(report.Repaired, checked.Repaired...)
+	}
+
+	if *asJson {
+		bytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			printf("[ERR] Unable to marshal: %v\n", err)
+			return exitFailure
+		}
+		printf("%s\n", bytes)
+	} else {
+		for _, problem := range report.Problems {
+			printf("[ERR] '%s' of '%s': %s\n", problem.Date, problem.Path, problem.Problem)
+		}
+		for _, path := range report.Repaired {
+			printf("[INF] Repaired '%s'\n", path)
+		}
+		printf("[INF] %d file(s) checked, %d problem(s), %d file(s) repaired\n", report.Files, len(report.Problems), len(report.Repaired))
+	}
+
+	// problems left as is
+	repaired := map[string]bool{}
+	for _, path := range report.Repaired {
+		repaired[path] = true
+	}
+	for _, problem := range report.Problems {
+		if !repaired[problem.Path] {
+			return exitStorage
+		}
+	}
+	return exitOk
+}
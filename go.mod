@@ -0,0 +1,13 @@
+module github.com/marouenj/rss
+
+go 1.22
+
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.18.0
+	go.etcd.io/bbolt v1.3.11
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require golang.org/x/sys v0.9.0 // indirect
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/marouenj/rss/agent"
+)
+
+// upgrade the day files of the items dir to the current version of the schema
+func migrate(args []string) int {
+	opts := newOptions("migrate")
+	dryRun := opts.flags.Bool("dry_run", false, "only list the files that would be upgraded")
+	asJson := opts.flags.Bool("json", false, "print the report as json")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	if *opts.itemsDb != "" {
+		printf("[ERR] Migrations apply to the day files of the items dir, not to -items_db\n")
+		return exitUsage
+	}
+
+	stores, err := opts.dirStores()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	report := &agent.MigrationReport{Migrated: []agent.MigratedFile{}, Newer: []string{}}
+	for _, store := range stores {
+		migrated, err := store.Migrate(*dryRun)
+		if err != nil {
+			printf("%v\n", err)
+			return exitStorage
+		}
+		report.Migrated = append(report.Migrated, migrated.Migrated...)
+		report.UpToDate += migrated.UpToDate
+		report.Newer = append(report.Newer, migrated.Newer...)
+	}
+
+	if *asJson {
+		bytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			printf("[ERR] Unable to marshal: %v\n", err)
+			return exitFailure
+		}
+		printf("%s\n", bytes)
+	} else {
+		printMigration(report, *dryRun)
+	}
+
+	// this version can't read them
+	if len(report.Newer) > 0 {
+		return exitStorage
+	}
+	return exitOk
+}
+
+// print the files migrated, or that would be
+func printMigration(report *agent.MigrationReport, dryRun bool) {
+	verb := "Migrated"
+	if dryRun {
+		verb = "Would migrate"
+	}
+	for _, file := range report.Migrated {
+		printf("[INF] %s '%s' from version %d to %d\n", verb, file.Path, file.From, agent.SchemaVersion)
+	}
+	for _, path := range report.Newer {
+		printf("[ERR] '%s' is written by a newer version, left as is\n", path)
+	}
+	summary := "migrated"
+	if dryRun {
+		summary = "to migrate"
+	}
+	printf("[INF] %d file(s) %s, %d up to date, %d newer\n", len(report.Migrated), summary, report.UpToDate, len(report.Newer))
+}
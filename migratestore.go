@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/marouenj/rss/agent"
+)
+
+// import the day files of the items dir to the database
+func migrateStore(args []string) int {
+	opts := newOptions("migrate-store")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	if *opts.itemsDb == "" {
+		*opts.itemsDb = filepath.Join(opts.dataDir(), itemsDb)
+	}
+
+	store, err := opts.store()
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	dir, err := opts.dirStore()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	days, items := 0, 0
+	err = agent.CopyDays(dir, store, func(date string, n int) {
+		printf("[INF] Imported '%s', %d item(s)\n", date, n)
+		days++
+		items += n
+	})
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	printf("[INF] Imported %d day(s), %d item(s) to '%s'\n", days, items, *opts.itemsDb)
+	return exitOk
+}
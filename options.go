@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/marouenj/rss/agent"
+)
+
+// the flags shared by the commands
+type options struct {
+	flags       *flag.FlagSet
+	config      *string
+	baseDir     *string
+	channels    *string
+	items       *string
+	itemsDb     *string
+	secretsDir  *string
+	recursive   *bool
+	lockTimeout *time.Duration
+	compress    *string
+	layout      *string
+	pretty      *bool
+	include     patterns
+	exclude     patterns
+}
+
+func newOptions(name string) *options {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	o := &options{
+		flags:       flags,
+		config:      flags.String("config", "", "file of defaults for the flags, defaults to <base_dir>/data/"+config+" if it exists"),
+		baseDir:     flags.String("base_dir", "./", ""),
+		channels:    flags.String("channels", "", "channels file or dir, defaults to <base_dir>/data/"+in),
+		items:       flags.String("items", "", "dir of the persisted items, defaults to <base_dir>/data/"+out),
+		itemsDb:     flags.String("items_db", "", "single-file database of the persisted items, used instead of the items dir when set"),
+		secretsDir:  flags.String("secrets_dir", "/run/secrets", "dir holding one file per secret"),
+		recursive:   flags.Bool("recursive", false, "read the channels files of sub dirs too"),
+		compress:    flags.String("compress", "", "compression of the day files written, gzip or zstd, none if empty, all are read"),
+		layout:      flags.String("layout", sharedLayout, "how the day files are laid out in the items dir, shared by the owners or split per owner in a dir each"),
+		pretty:      flags.Bool("pretty", false, "write the day files indented, one item per block, so that they diff well, e.g. in git"),
+		lockTimeout: flags.Duration("lock_timeout", agent.DefaultLockTimeout, "how long to wait for the items dir or db locked by another run, fail right away if 0"),
+	}
+	flags.Var(&o.include, "include", "glob pattern of the channels files to read, repeatable")
+	flags.Var(&o.exclude, "exclude", "glob pattern of the channels files to skip, repeatable")
+	return o
+}
+
+// parse the command line, then fill the flags it doesn't set from the config file
+func (o *options) parse(args []string) error {
+	o.flags.Parse(args)
+
+	set := map[string]bool{}
+	o.flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	path := *o.config
+	if path == "" {
+		path = filepath.Join(*o.baseDir, data, config)
+		if _, err := os.Stat(path); err != nil { // no config, that's fine
+			path = ""
+		}
+	}
+	if path != "" {
+		if err := o.applyConfig(path, set); err != nil {
+			return err // already formatted
+		}
+	}
+
+	agent.SecretsDir = *o.secretsDir
+	return nil
+}
+
+// the config file is a json object, keyed by flag name
+// keys of the flags of other commands are ignored
+func (o *options) applyConfig(path string, set map[string]bool) error {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to read '%s': %v", path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(file))
+	dec.UseNumber()
+	var defaults map[string]interface{}
+	if err := dec.Decode(&defaults); err != nil {
+		return fmt.Errorf("[ERR] Unable to unmarshal '%s': %v", path, err)
+	}
+
+	for name, value := range defaults {
+		if set[name] || name == "config" || o.flags.Lookup(name) == nil {
+			continue
+		}
+
+		// lists are for the repeatable flags
+		values := []interface{}{value}
+		if list, ok := value.([]interface{}); ok {
+			values = list
+		}
+		for _, v := range values {
+			if err := o.flags.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("[ERR] Invalid value for '%s' in '%s': %v", name, path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o *options) dataDir() string {
+	return filepath.Join(*o.baseDir, data)
+}
+
+func (o *options) channelsPath() string {
+	if *o.channels != "" {
+		return *o.channels
+	}
+	return filepath.Join(o.dataDir(), in)
+}
+
+func (o *options) itemsDir() string {
+	if *o.items != "" {
+		return *o.items
+	}
+	return filepath.Join(o.dataDir(), out)
+}
+
+// where the items are persisted
+// the database is created if missing
+func (o *options) store() (agent.Store, error) {
+	if *o.layout != sharedLayout && *o.layout != ownerLayout {
+		return nil, fmt.Errorf("[ERR] Unknown layout '%s', expected %s or %s", *o.layout, sharedLayout, ownerLayout)
+	}
+	if *o.itemsDb == "" && *o.layout == ownerLayout {
+		return o.ownerStore()
+	}
+	if *o.itemsDb == "" {
+		return o.dirStore()
+	}
+
+	dir := filepath.Dir(*o.itemsDb)
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to create dir '%s': %v", dir, err)
+	}
+	store, err := agent.OpenBoltStore(*o.itemsDb)
+	if err != nil {
+		return nil, err // already formatted
+	}
+	store.LockTimeout = *o.lockTimeout
+	return store, nil
+}
+
+// the day files of the items dir, whatever -items_db
+func (o *options) dirStore() (*agent.DirStore, error) {
+	if !agent.ValidCompression(*o.compress) {
+		return nil, fmt.Errorf("[ERR] Unknown compression '%s', expected gzip or zstd", *o.compress)
+	}
+
+	store := agent.NewDirStore(o.itemsDir())
+	store.Compression = *o.compress
+	store.Pretty = *o.pretty
+	store.LockTimeout = *o.lockTimeout
+	return store, nil
+}
+
+// the day files of the items dir, split per owner
+func (o *options) ownerStore() (*agent.OwnerStore, error) {
+	if !agent.ValidCompression(*o.compress) {
+		return nil, fmt.Errorf("[ERR] Unknown compression '%s', expected gzip or zstd", *o.compress)
+	}
+
+	store := agent.NewOwnerStore(o.itemsDir())
+	store.Compression = *o.compress
+	store.Pretty = *o.pretty
+	store.LockTimeout = *o.lockTimeout
+	return store, nil
+}
+
+// the dirs of day files of the items dir, one per owner if split per owner
+func (o *options) dirStores() ([]*agent.DirStore, error) {
+	switch *o.layout {
+	case sharedLayout:
+		store, err := o.dirStore()
+		if err != nil {
+			return nil, err // already formatted
+		}
+		return []*agent.DirStore{store}, nil
+	case ownerLayout:
+		store, err := o.ownerStore()
+		if err != nil {
+			return nil, err // already formatted
+		}
+		return store.Stores()
+	}
+	return nil, fmt.Errorf("[ERR] Unknown layout '%s', expected %s or %s", *o.layout, sharedLayout, ownerLayout)
+}
+
+// a marshaller persisting to the store
+func (o *options) marshaller() (*agent.Marshaller, error) {
+	store, err := o.store()
+	if err != nil {
+		return nil, err // already formatted
+	}
+	return agent.NewStoreMarshaller(store)
+}
+
+// report the days of the store that can't be loaded, e.g. truncated by a crash
+// they're set aside, so that they start over rather than failing every save
+func checkStore(store agent.Store) error {
+	checked, ok := store.(agent.CheckedStore)
+	if !ok {
+		return nil
+	}
+
+	problems, err := checked.Check()
+	if err != nil {
+		return err // already formatted
+	}
+
+	// the day files of a date are set aside at once, e.g. the ones of several owners
+	asides := map[string]string{}
+	for _, problem := range problems {
+		aside, found := asides[problem.Date]
+		if !found {
+			aside, err = checked.Quarantine(problem.Date)
+			if err != nil {
+				return err // already formatted
+			}
+			asides[problem.Date] = aside
+		}
+		printf("[ERR] %v, moved to '%s'\n", problem, aside)
+	}
+	return nil
+}
+
+// a loader set up with the options, with nothing loaded yet
+func (o *options) loader() (*agent.Loader, error) {
+	loader, err := agent.NewLoader()
+	if err != nil {
+		return nil, err // already formatted
+	}
+	loader.Recursive = *o.recursive
+	loader.Include = o.include
+	loader.Exclude = o.exclude
+	return loader, nil
+}
+
+// a loader having loaded the channels
+func (o *options) load() (*agent.Loader, error) {
+	loader, err := o.loader()
+	if err != nil {
+		return nil, err // already formatted
+	}
+
+	err = loader.Load(o.channelsPath())
+	if err != nil {
+		return nil, fmt.Errorf("[ERR] Unable to load channels: %v", err)
+	}
+	return loader, nil
+}
+
+// the flags of the commands crawling feeds
+type crawlOptions struct {
+	maxBodySize *int64
+	lenient     *bool
+	polite      *bool
+	minDelay    *time.Duration
+}
+
+func newCrawlOptions(flags *flag.FlagSet) *crawlOptions {
+	return &crawlOptions{
+		maxBodySize: flags.Int64("max_body_size", agent.DefaultMaxBodySize, "feeds larger than this many bytes are rejected, no limit if 0"),
+		lenient:     flags.Bool("lenient", false, "recover what can be from malformed feeds"),
+		polite:      flags.Bool("polite", false, "honor robots.txt and space the requests to the same host"),
+		minDelay:    flags.Duration("min_delay", agent.DefaultMinDelay, "delay between requests to the same host when polite, raised by Crawl-delay"),
+	}
+}
+
+func (co *crawlOptions) apply(crawler *agent.Crawler) {
+	crawler.MaxBodySize = *co.maxBodySize
+	crawler.Lenient = *co.lenient
+	if *co.polite {
+		crawler.Politeness = agent.NewPoliteness()
+		crawler.Politeness.MinDelay = *co.minDelay
+	}
+}
+
+// the flags of the retention of the items
+type retentionOptions struct {
+	keepDays   *int
+	keepOwner  ownerDays
+	maxItems   *int
+	archiveDir *string
+}
+
+func newRetentionOptions(flags *flag.FlagSet) *retentionOptions {
+	ro := &retentionOptions{
+		keepDays:   flags.Int("keep_days", 0, "remove the items older than this many days, kept forever if 0"),
+		maxItems:   flags.Int("max_items", 0, "most items of a channel per day, the first by title are kept, no cap if 0"),
+		archiveDir: flags.String("archive_dir", "", "dir the removed items are moved to, as day files, deleted if not set"),
+	}
+	flags.Var(&ro.keepOwner, "keep_owner", "owner=days, the -keep_days of an owner, kept forever if 0, repeatable")
+	return ro
+}
+
+// whether any retention is set
+func (ro *retentionOptions) set() bool {
+	return *ro.keepDays > 0 || len(ro.keepOwner) > 0 || *ro.maxItems > 0
+}
+
+// the retention of the flags, the archive dir is created if missing
+func (ro *retentionOptions) retention() (*agent.Retention, error) {
+	retention := &agent.Retention{
+		KeepDays: *ro.keepDays,
+		Owners:   ro.keepOwner,
+		MaxItems: *ro.maxItems,
+	}
+
+	if *ro.archiveDir != "" {
+		if err := os.MkdirAll(*ro.archiveDir, os.ModeDir|os.ModePerm); err != nil {
+			return nil, fmt.Errorf("[ERR] Unable to create dir '%s': %v", *ro.archiveDir, err)
+		}
+		retention.Archive = agent.NewDirStore(*ro.archiveDir)
+	}
+	return retention, nil
+}
+
+// print what a retention removed, or would remove
+func printRemovals(removals []agent.Removal, archived, dryRun bool) {
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	} else if archived {
+		verb = "Archived"
+	}
+	for _, removal := range removals {
+		printf("[INF] %s %v\n", verb, removal)
+	}
+}
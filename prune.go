@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+)
+
+// remove, or archive, the items past the retention
+func prune(args []string) int {
+	opts := newOptions("prune")
+	before := opts.flags.String("before", "", "remove the items of the days before this date (YYYY-MM-DD), overrides -keep_days")
+	retentionOpts := newRetentionOptions(opts.flags)
+	dryRun := opts.flags.Bool("dry_run", false, "only list the items that would be removed")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	if *before == "" && !retentionOpts.set() {
+		printf("[ERR] Expecting -before, -keep_days, -keep_owner or -max_items\n")
+		return exitUsage
+	}
+	if *before != "" {
+		if _, err := time.Parse(dateLayout, *before); err != nil {
+			printf("[ERR] Invalid date '%s', expected YYYY-MM-DD\n", *before)
+			return exitUsage
+		}
+	}
+
+	retention, err := retentionOpts.retention()
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+	retention.Before = *before
+
+	store, err := opts.store()
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	removals, err := retention.Apply(store, time.Now(), *dryRun)
+	printRemovals(removals, retention.Archive != nil, *dryRun)
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	return exitOk
+}
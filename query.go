@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/marouenj/rss/agent"
+)
+
+// the query flags, shared by the query command and the server
+type queryFlags struct {
+	owner   string
+	channel string
+	since   string
+	until   string
+	match   string
+}
+
+// check the flags and turn them into a query
+func (qf queryFlags) query() (agent.Query, error) {
+	q := agent.Query{
+		Owner:   qf.owner,
+		Channel: qf.channel,
+		Since:   qf.since,
+		Until:   qf.until,
+	}
+
+	for _, date := range []string{qf.since, qf.until} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return q, fmt.Errorf("[ERR] Invalid date '%s', expected YYYY-MM-DD", date)
+		}
+	}
+
+	if qf.match != "" {
+		re, err := regexp.Compile(qf.match)
+		if err != nil {
+			return q, fmt.Errorf("[ERR] Invalid pattern '%s': %v", qf.match, err)
+		}
+		q.Match = re
+	}
+
+	return q, nil
+}
+
+// print the persisted items matching some criteria, one per line or as json
+func query(args []string) int {
+	opts := newOptions("query")
+	var qf queryFlags
+	opts.flags.StringVar(&qf.owner, "owner", "", "only the items of this owner")
+	opts.flags.StringVar(&qf.channel, "channel", "", "only the items of the channel with this title")
+	opts.flags.StringVar(&qf.since, "since", "", "only the items of this date (YYYY-MM-DD) or later")
+	opts.flags.StringVar(&qf.until, "until", "", "only the items of this date (YYYY-MM-DD) or earlier")
+	opts.flags.StringVar(&qf.match, "match", "", "only the items whose title matches this regular expression")
+	asJson := opts.flags.Bool("json", false, "print the items as json")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	q, err := qf.query()
+	if err != nil {
+		printf("%v\n", err)
+		return exitUsage
+	}
+
+	marshaller, err := opts.marshaller()
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	results, err := marshaller.Query(q)
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	if *asJson {
+		bytes, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			printf("[ERR] Unable to marshal: %v\n", err)
+			return exitFailure
+		}
+		printf("%s\n", bytes)
+		return exitOk
+	}
+
+	for _, result := range results {
+		printf("%s\t%s\t%s\t%s\t%s\n", result.Date, result.Owner, result.Channel, result.Item.Title, result.Item.Link)
+	}
+
+	return exitOk
+}
+
+// count the persisted items, per owner and channel
+func stats(args []string) int {
+	opts := newOptions("stats")
+	asJson := opts.flags.Bool("json", false, "print the stats as json")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	marshaller, err := opts.marshaller()
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	s, err := marshaller.Stats()
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	if *asJson {
+		bytes, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			printf("[ERR] Unable to marshal: %v\n", err)
+			return exitFailure
+		}
+		printf("%s\n", bytes)
+		return exitOk
+	}
+
+	printf("%d item(s) over %d day(s)", s.Items, s.Days)
+	if s.Days > 0 {
+		printf(", from %s to %s", s.First, s.Last)
+	}
+	printf("\n")
+	for _, owner := range s.Owners {
+		printf("%s\t%d\n", owner.Id, owner.Items)
+		for _, channel := range owner.Channels {
+			printf("  %s\t%d\tlast on %s\n", channel.Title, channel.Items, channel.Last)
+		}
+	}
+
+	return exitOk
+}
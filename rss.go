@@ -1,134 +1,145 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/marouenj/rss/agent"
 )
 
 var (
-	config string = "config"
-	data   string = "data"
-	in     string = "channels"
-	out    string = "items"
+	config  string = "config.json"
+	data    string = "data"
+	in      string = "channels"
+	out     string = "items"
+	itemsDb string = "items.db"
 )
 
-func main() {
-	// parse args
-	baseDir := flag.String("base_dir", "./", "")
-	flag.Parse()
-
-	// check baseDir exists
-	if _, err := os.Stat(*baseDir); err != nil {
-		fmt.Printf("[ERR] Base dir not exists: %v\n", err)
-		os.Exit(1)
-	}
+// layout of the dates naming the day files
+const dateLayout = "2006-01-02"
 
-	// check baseDir is a dir
-	info, _ := os.Stat(*baseDir)
-	if !info.IsDir() {
-		fmt.Printf("[ERR] Base dir not a dir\n")
-		os.Exit(1)
-	}
+// the layouts of the items dir, a day file per day shared by the owners, or a dir of day files per owner
+const (
+	sharedLayout = "shared"
+	ownerLayout  = "owner"
+)
 
-	dataDir := filepath.Join(*baseDir, data)
+// exit codes, per class of failure
+const (
+	exitOk      = 0 // success
+	exitFailure = 1 // failure while running, e.g. feeds that can't be crawled or an address that can't be listened on
+	exitUsage   = 2 // wrong command line, like the flag package does
+	exitConfig  = 3 // config or channels files that can't be read or don't validate
+	exitStorage = 4 // items that can't be read or written
+)
 
-	// check dataDir exists
-	if _, err := os.Stat(dataDir); err != nil {
-		fmt.Printf("[ERR] Data dir not exists: %v\n", err)
-		os.Exit(1)
-	}
+type command struct {
+	run  func(args []string) int
+	help string
+}
 
-	// check dataDir is a dir
-	info, _ = os.Stat(dataDir)
-	if !info.IsDir() {
-		fmt.Printf("[ERR] Data dir not a dir\n")
-		os.Exit(1)
-	}
+var commands = map[string]command{
+	"crawl":          {crawl, "download the items of the feeds once and persist them"},
+	"daemon":         {daemon, "keep polling the feeds, each one on its own schedule"},
+	"validate":       {validate, "check the channels files"},
+	"convert-config": {convertConfig, "translate a channels file to another format"},
+	"fix-config":     {fixConfig, "rewrite the urls of the feeds that moved, disable the ones that are gone"},
+	"list-feeds":     {listFeeds, "list the feeds of the channels files"},
+	"add-feed":       {addFeed, "add a feed to an owner"},
+	"remove-feed":    {removeFeed, "remove a feed from an owner"},
+	"move-feed":      {moveFeed, "move a feed from an owner to another"},
+	"query":          {query, "print the persisted items matching some criteria"},
+	"stats":          {stats, "count the persisted items, per owner and channel"},
+	"prune":          {prune, "remove, or archive, the items past the retention"},
+	"archive":        {archive, "roll the day files of the past months into monthly compressed archives"},
+	"fsck":           {fsck, "check the day files hold sorted, deduplicated days, and repair them"},
+	"migrate":        {migrate, "upgrade the day files to the current version of the schema"},
+	"migrate-store":  {migrateStore, "import the day files of the items dir to the database"},
+	"split-owners":   {splitOwners, "split the day files shared by the owners into a dir of day files per owner"},
+	"serve":          {serve, "serve the feeds and the persisted items over http"},
+}
 
-	inDir := filepath.Join(dataDir, in)
+func main() {
+	// with no subcommand, crawl, as the single command of the previous versions did
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(crawl(os.Args[1:]))
+	}
 
-	// check inDir exists
-	if _, err := os.Stat(inDir); err != nil {
-		fmt.Printf("[ERR] Input dir not exists: %v\n", err)
-		os.Exit(1)
+	name := os.Args[1]
+	if name == "help" {
+		usage()
+		os.Exit(exitOk)
 	}
 
-	// check inDir is a dir
-	info, _ = os.Stat(inDir)
-	if !info.IsDir() {
-		fmt.Printf("[ERR] Input dir not a dir\n")
-		os.Exit(1)
+	cmd, ok := commands[name]
+	if !ok {
+		printf("[ERR] Unknown command '%s'\n", name)
+		usage()
+		os.Exit(exitUsage)
 	}
 
-	outDir := filepath.Join(dataDir, out)
+	os.Exit(cmd.run(os.Args[2:]))
+}
 
-	// check outDir exists
-	if _, err := os.Stat(outDir); err != nil {
-		// create if not exists
-		err = os.Mkdir(outDir, os.ModeDir|os.ModePerm)
-		if err != nil {
-			fmt.Printf("[ERR] Unable to create dir '%s': %v\n", outDir, err)
-			os.Exit(1)
-		}
+func usage() {
+	names := []string{}
+	for name, _ := range commands {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	// check outDir is a dir
-	info, _ = os.Stat(outDir)
-	if !info.IsDir() {
-		fmt.Printf("[ERR] Output dir not a dir\n")
-		os.Exit(1)
+	fmt.Fprintf(os.Stderr, "Usage: rss <command> [flags]\n\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", name, commands[name].help)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun 'rss <command> -h' for the flags of a command.\n")
+}
 
-	// create loader
-	loader, err := agent.NewLoader()
-	if err != nil {
-		fmt.Printf("%v\n", err)
-		os.Exit(1)
-	}
+// print to the standard output, with the secrets hidden
+func printf(format string, args ...interface{}) {
+	fmt.Print(agent.Redact(fmt.Sprintf(format, args...)))
+}
 
-	// load
-	err = loader.Load(inDir)
-	if err != nil {
-		fmt.Printf("[ERR] Unable to load channels: %v\n", err)
-		os.Exit(1)
-	}
+// a repeatable flag collecting glob patterns
+type patterns []string
 
-	// create crawler
-	crawler, err := agent.NewCrawler()
-	if err != nil {
-		fmt.Printf("%v\n", err)
-		os.Exit(1)
-	}
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
 
-	// crawl
-	err = crawler.Crawl(loader)
-	if err != nil {
-		fmt.Printf("[ERR] Unable to download items: %v\n", err)
-		os.Exit(1)
-	}
+func (p *patterns) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// a repeatable flag collecting owner=days pairs
+type ownerDays map[string]int
 
-	// create marshaller
-	marshaller, err := agent.NewMarshaller(outDir)
-	if err != nil {
-		fmt.Printf("%v\n", err)
-		os.Exit(1)
+func (o *ownerDays) String() string {
+	pairs := []string{}
+	for owner, days := range *o {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", owner, days))
 	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
 
-	// rearrange items
-	err = marshaller.ReArrange(crawler.Rss.Channels)
-	if err != nil {
-		fmt.Printf("%v\n", err)
-		os.Exit(1)
+func (o *ownerDays) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expecting owner=days, found '%s'", value)
+	}
+	days, err := strconv.Atoi(parts[1])
+	if err != nil || days < 0 {
+		return fmt.Errorf("expecting a number of days, found '%s'", parts[1])
 	}
 
-	// persist
-	err = marshaller.Save()
-	if err != nil {
-		fmt.Printf("[ERR] Unable to merge and persist new items: %v\n", err)
-		os.Exit(1)
+	if *o == nil {
+		*o = ownerDays{}
 	}
+	(*o)[parts[0]] = days
+	return nil
 }
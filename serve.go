@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/marouenj/rss/agent"
+)
+
+// serve the feeds and the persisted items over http, as json
+// files are read on each request, so that the responses follow the crawls
+func serve(args []string) int {
+	opts := newOptions("serve")
+	addr := opts.flags.String("addr", ":8080", "address to listen on")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	// fail early on channels that can't be loaded
+	if _, err := opts.load(); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	marshaller, err := opts.marshaller()
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	mux := http.NewServeMux()
+
+	// the channel groups, '?owner=' selects an owner
+	mux.HandleFunc("/feeds", func(w http.ResponseWriter, r *http.Request) {
+		loader, err := opts.load()
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		owner := r.URL.Query().Get("owner")
+		groups := agent.ChannelGroups{}
+		for _, group := range loader.ChannelGroups {
+			if owner == "" || group.Owner == owner {
+				groups = append(groups, group)
+			}
+		}
+		writeJson(w, groups.Masked())
+	})
+
+	// the items, selected by the parameters of the query command
+	mux.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		q, err := queryFlags{
+			owner:   params.Get("owner"),
+			channel: params.Get("channel"),
+			since:   params.Get("since"),
+			until:   params.Get("until"),
+			match:   params.Get("match"),
+		}.query()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		results, err := marshaller.Query(q)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		writeJson(w, results)
+	})
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		s, err := marshaller.Stats()
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		writeJson(w, s)
+	})
+
+	printf("[INF] Listening on '%s'\n", *addr)
+	err = http.ListenAndServe(*addr, mux)
+	if err != nil {
+		printf("[ERR] Unable to listen on '%s': %v\n", *addr, err)
+		return exitFailure
+	}
+
+	return exitOk
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+// errors are logged and answered with the secrets hidden
+func httpError(w http.ResponseWriter, err error, status int) {
+	msg := agent.Redact(err.Error())
+	printf("%s\n", msg)
+	http.Error(w, msg, status)
+}
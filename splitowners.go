@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/marouenj/rss/agent"
+)
+
+// split the day files shared by the owners into a dir of day files per owner
+func splitOwners(args []string) int {
+	opts := newOptions("split-owners")
+	to := opts.flags.String("to", "", "dir of the dirs of the owners, defaults to the items dir")
+	keep := opts.flags.Bool("keep", false, "keep the shared day files once split")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	if *opts.itemsDb != "" {
+		printf("[ERR] The day files of the items dir are split, not -items_db\n")
+		return exitUsage
+	}
+
+	shared, err := opts.dirStore()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+	owned, err := opts.ownerStore()
+	if err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+	if *to != "" {
+		owned = agent.NewOwnerStore(*to)
+		owned.Compression = *opts.compress
+		owned.Pretty = *opts.pretty
+		owned.LockTimeout = *opts.lockTimeout
+	}
+
+	dates := []string{}
+	err = agent.SplitOwners(shared, owned, func(date string, owners int) {
+		printf("[INF] Split '%s' to %d owner(s)\n", date, owners)
+		dates = append(dates, date)
+	})
+	if err != nil {
+		printf("%v\n", err)
+		return exitStorage
+	}
+
+	if !*keep {
+		for _, date := range dates {
+			if err := shared.RemoveDay(date); err != nil {
+				printf("%v\n", err)
+				return exitStorage
+			}
+		}
+	}
+
+	printf("[INF] Split %d day(s), run with -layout %s from now on\n", len(dates), ownerLayout)
+	return exitOk
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// write a file so that it's either left as it was or fully written
+// data goes to a temp file of the same dir, synced to disk, then renamed over the file
+// the file keeps its mode if it exists, else it's created with perm less the umask, as ioutil.WriteFile does
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := createTemp(dir, "."+name+".tmp", perm)
+	if err != nil {
+		return fmt.Errorf("[ERR] Unable to create a temp file for '%s': %v", path, err)
+	}
+	// gone once renamed, left over on failure
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("[ERR] Unable to write to '%s': %v", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("[ERR] Unable to sync '%s': %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("[ERR] Unable to close '%s': %v", tmp.Name(), err)
+	}
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+			return fmt.Errorf("[ERR] Unable to chmod '%s': %v", tmp.Name(), err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("[ERR] Unable to rename '%s': %v", tmp.Name(), err)
+	}
+
+	// the rename itself is durable once the dir is synced
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// create a new file of a unique name, the umask applies to perm
+func createTemp(dir, prefix string, perm os.FileMode) (*os.File, error) {
+	for idx := 0; ; idx++ {
+		name := filepath.Join(dir, prefix+strconv.Itoa(os.Getpid())+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+		file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+		if os.IsExist(err) && idx < 100 {
+			continue
+		}
+		return file, err
+	}
+}
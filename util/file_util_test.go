@@ -0,0 +1,91 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_WriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		path  string
+		data  string
+		error bool
+	}{
+		{filepath.Join(dir, "file"), "first", false},
+		{filepath.Join(dir, "file"), "second", false}, // replaced
+		{filepath.Join(dir, "missing", "file"), "x", true},
+	}
+
+	for idx, testCase := range testCases {
+		err := WriteFileAtomic(testCase.path, []byte(testCase.data), 0644)
+		if testCase.error != (err != nil) {
+			t.Errorf("[Test case %d] Expected error %v, found '%v'", idx, testCase.error, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		data, _ := ioutil.ReadFile(testCase.path)
+		if string(data) != testCase.data {
+			t.Errorf("[Test case %d] Expected '%s', found '%s'", idx, testCase.data, data)
+		}
+		info, _ := os.Stat(testCase.path)
+		if info.Mode().Perm() != 0644 {
+			t.Errorf("[Test case %d] Expected mode 0644, found %v", idx, info.Mode().Perm())
+		}
+	}
+
+	// no temp file left behind
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected a single file, found %d", len(entries))
+	}
+}
+
+func Test_WriteFileAtomic_mode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the mode ioutil.WriteFile gives under the umask
+	probe := filepath.Join(dir, "probe")
+	ioutil.WriteFile(probe, nil, 0666)
+	info, _ := os.Stat(probe)
+	created := info.Mode().Perm()
+
+	testCases := []struct {
+		name     string
+		existing os.FileMode // of the file replaced, none if 0
+		mode     os.FileMode
+	}{
+		{"new", 0, created}, // less the umask
+		{"private", 0600, 0600},
+		{"shared", 0664, 0664},
+	}
+
+	for idx, testCase := range testCases {
+		path := filepath.Join(dir, testCase.name)
+		if testCase.existing != 0 {
+			ioutil.WriteFile(path, []byte("previous"), testCase.existing)
+			os.Chmod(path, testCase.existing)
+		}
+
+		if err := WriteFileAtomic(path, []byte("data"), 0666); err != nil {
+			t.Errorf("[Test case %d] %v", idx, err)
+		}
+		info, _ := os.Stat(path)
+		if info.Mode().Perm() != testCase.mode {
+			t.Errorf("[Test case %d] Expected mode %v, found %v", idx, testCase.mode, info.Mode().Perm())
+		}
+	}
+}
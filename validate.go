@@ -0,0 +1,44 @@
+package main
+
+// check the channels files, the exit code is
+// 0 if no problem is found, 3 if problems are found or the files can't be read
+func validate(args []string) int {
+	opts := newOptions("validate")
+	if err := opts.parse(args); err != nil {
+		printf("%v\n", err)
+		return exitConfig
+	}
+
+	loader, err := opts.loader()
+	if err != nil {
+		printf("%v\n", err)
+		return exitFailure
+	}
+
+	// files given as args take precedence over the channels
+	paths := opts.flags.Args()
+	if len(paths) == 0 {
+		paths = []string{opts.channelsPath()}
+	}
+
+	count := 0
+	for _, path := range paths {
+		diags, err := loader.Validate(path)
+		if err != nil {
+			printf("%v\n", err)
+			return exitConfig
+		}
+
+		for _, diag := range diags {
+			printf("%v\n", diag)
+		}
+		count += len(diags)
+	}
+
+	if count > 0 {
+		printf("[ERR] %d problem(s) found\n", count)
+		return exitConfig
+	}
+
+	return exitOk
+}